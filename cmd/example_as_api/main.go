@@ -7,6 +7,10 @@ import (
 	"github.com/ing-bank/orchestration-pkg/pkg/orchestration"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
@@ -18,27 +22,32 @@ func main() {
 				writer.WriteHeader(http.StatusBadRequest)
 				_, _ = writer.Write([]byte("could not unmarshal request payload: " + err.Error()))
 			}
-			CopyClaim := func(claim example.MemoryClaim) *example.MemoryClaim {
-				return &claim
-			}
 
 			// Create memory API claims in many datacenters/zones as an example
-			services := []orchestration.Service{
-				orchestration.RestApiAsService(&example.MyServiceApi{Datacenter: "DC1_BLUE"},
-					orchestration.REST_API_POST, "MyService Create DC1_BLUE", "", CopyClaim(claim)),
-				orchestration.RestApiAsService(&example.MyServiceApi{Datacenter: "DC1_RED"},
-					orchestration.REST_API_POST, "MyService Create DC1_RED", "", CopyClaim(claim)),
-				orchestration.RestApiAsService(&example.MyServiceApi{Datacenter: "DC2_BLUE"},
-					orchestration.REST_API_POST, "MyService Create DC2_BLUE", "", CopyClaim(claim)),
-				orchestration.RestApiAsService(&example.MyServiceApi{Datacenter: "DC2_RED"},
-					orchestration.REST_API_POST, "MyService Create DC2_RED", "", CopyClaim(claim)),
+			fanOut := orchestration.FanOut{
+				ApiName: "MyService Create",
+				Action:  orchestration.REST_API_POST,
+				Payload: &claim, // Copied for every datacenter
+			}
+			for _, datacenter := range []string{"DC1_BLUE", "DC1_RED", "DC2_BLUE", "DC2_RED"} {
+				fanOut.Targets = append(fanOut.Targets, orchestration.Target{
+					Name: datacenter,
+					Api:  &example.MyServiceApi{Datacenter: datacenter},
+				})
+			}
+			services, err := fanOut.Services()
+			if err != nil {
+				writer.WriteHeader(http.StatusInternalServerError)
+				_, _ = writer.Write([]byte(err.Error()))
+				return
 			}
+
 			errs, err := orchestration.CallServices(context.TODO(), services, orchestration.CallServicesOpts{}) // Calls: Check -> Run -> Rollback
 
-			// Generate response
-			status, resp := orchestration.GenerateResponse(services, errs, err)
+			// Generate response with the result of every datacenter, and the status code of the orchestration
+			status, _ := orchestration.GenerateResponse(services, errs, err)
 			writer.WriteHeader(status)
-			rawResp, _ := json.Marshal(resp)
+			rawResp, _ := json.Marshal(fanOut.Merge(services, errs))
 			_, _ = writer.Write(append(rawResp, '\n'))
 
 		} else {
@@ -46,6 +55,15 @@ func main() {
 		}
 	})
 
+	// The memory API of a single datacenter can be served as is, so it can be called with an HTTPRestApi from another
+	// process
+	claims := &orchestration.RestApiHandler{
+		Api:    &example.MyServiceApi{Datacenter: "DC1_BLUE"},
+		Decode: orchestration.JSONDecoder[*example.MemoryClaim](),
+	}
+	http.Handle("/api/v1/claims", http.StripPrefix("/api/v1/claims", claims))
+	http.Handle("/api/v1/claims/", http.StripPrefix("/api/v1/claims", claims))
+
 	// When a Service has a Rollback it is executed "in the background". Since a rollback is a fallible
 	// operation the error needs to be reported somewhere. The orchestration package calls the RollbackErrorReporter
 	// with all the services in a stage when a Service Rollback has an error.
@@ -57,5 +75,21 @@ func main() {
 		}
 	}
 
-	log.Fatal(http.ListenAndServe(":8090", nil))
+	// On redeploy, stop accepting requests and wait for the background rollbacks before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	server := &http.Server{Addr: ":8090"}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_ = server.Shutdown(shutdownCtx)
+	if err := orchestration.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Exiting with background rollbacks still running: %v", err)
+	}
 }
@@ -7,6 +7,10 @@ import (
 	"github.com/ing-bank/orchestration-pkg/pkg/orchestration"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
@@ -50,5 +54,21 @@ func main() {
 		}
 	}
 
-	log.Fatal(http.ListenAndServe(":8090", nil))
+	// On redeploy, stop accepting requests and wait for the background rollbacks before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	server := &http.Server{Addr: ":8090"}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_ = server.Shutdown(shutdownCtx)
+	if err := orchestration.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Exiting with background rollbacks still running: %v", err)
+	}
 }
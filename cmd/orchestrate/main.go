@@ -0,0 +1,13 @@
+package main
+
+import (
+	"github.com/ing-bank/orchestration-pkg/internal/example"
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration/cli"
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration/spec"
+)
+
+func main() {
+	// Register the Service factories that specs can refer to, other binaries register their own
+	example.RegisterFactories(spec.DefaultRegistry)
+	cli.Main(spec.DefaultRegistry)
+}
@@ -14,9 +14,10 @@ type MyServiceApi struct {
 
 func (m *MyServiceApi) Get(ctx context.Context, name string) (orchestration.Nameable, error) {
 	if claim, ok := FakeDbRead(m.Datacenter + name); ok {
+		claim.ClaimName = name // Datacenter prefix is internal, so the claim can be compared with the payload
 		return &claim, nil
 	}
-	return nil, errors.New("not found")
+	return nil, orchestration.ErrNotFound
 }
 
 func (m *MyServiceApi) Post(ctx context.Context, obj orchestration.Nameable) (interface{}, error) {
@@ -56,7 +57,7 @@ func (m *MyServiceApi) Put(ctx context.Context, obj orchestration.Nameable) (int
 
 	existingClaim, ok := FakeDbRead(claim.ClaimName)
 	if !ok {
-		return nil, errors.New("not found")
+		return nil, orchestration.ErrNotFound
 	}
 
 	if FakeDbGetAvailableMemory()+existingClaim.MemoryInMb-claim.MemoryInMb < 0 {
@@ -72,7 +73,7 @@ func (m *MyServiceApi) Delete(ctx context.Context, name string) (interface{}, er
 	name = m.Datacenter + name
 	claim, ok := FakeDbRead(name)
 	if !ok {
-		return nil, errors.New("not found")
+		return nil, orchestration.ErrNotFound
 	}
 	FakeDbDelete(name)
 	FakeDbUpdateAvailableMemory(claim.MemoryInMb)
@@ -0,0 +1,41 @@
+package example
+
+import (
+	"errors"
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration"
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration/spec"
+)
+
+// RegisterFactories registers the example Services, so they can be used in a spec:
+//
+//   - memory-claim-create: MemoryApiCreate, params is a MemoryClaim and the target is the datacenter
+//   - memory-claim-api: MyServiceApi as Service, params is a MemoryClaim, the target is the datacenter and the
+//     name is the RestApiAction (default POST)
+func RegisterFactories(registry *spec.Registry) {
+	registry.Register("memory-claim-create", func(def spec.Definition) (orchestration.Service, error) {
+		if def.Target == "" {
+			return nil, errors.New("a datacenter target is required")
+		}
+		claim := MemoryClaim{}
+		if err := def.DecodeParams(&claim); err != nil {
+			return nil, err
+		}
+		return &MemoryApiCreate{Claim: claim, Datacenter: def.Target}, nil
+	})
+
+	registry.Register("memory-claim-api", func(def spec.Definition) (orchestration.Service, error) {
+		if def.Target == "" {
+			return nil, errors.New("a datacenter target is required")
+		}
+		claim := &MemoryClaim{}
+		if err := def.DecodeParams(claim); err != nil {
+			return nil, err
+		}
+		action := orchestration.RestApiAction(def.Name)
+		if action == "" {
+			action = orchestration.REST_API_POST
+		}
+		return orchestration.RestApiAsService(&MyServiceApi{Datacenter: def.Target}, action,
+			"MyService "+string(action)+" "+def.Target, claim.ClaimName, claim), nil
+	})
+}
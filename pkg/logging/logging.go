@@ -9,8 +9,11 @@ package logging
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"strings"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/redact"
 )
 
 const (
@@ -80,7 +83,7 @@ func Logf(logLevel int, format string, params ...interface{}) {
 		}
 		preFormatParams = append(preFormatParams, params...)
 
-		log.Printf(preFormat+format, preFormatParams...)
+		log.Print(redact.String(fmt.Sprintf(preFormat+format, preFormatParams...)))
 	}
 }
 
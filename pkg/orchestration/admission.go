@@ -0,0 +1,273 @@
+package orchestration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrAdmissionRejected is wrapped by every AdmissionError
+var ErrAdmissionRejected = errors.New("too many orchestrations")
+
+// StatusCoder can be implemented by an error to select the HTTP status of the generated Response
+type StatusCoder interface {
+	StatusCode() int
+}
+
+// AdmissionError is returned when the AdmissionController rejects a call, it results in HTTP 429
+type AdmissionError struct {
+	Tenant     string
+	Reason     string
+	RetryAfter time.Duration // Estimate when a new call could be admitted, zero when unknown
+}
+
+// TenantLimits limit the orchestrations of a single tenant. Zero values mean unlimited, except for QueueTimeout: a
+// zero QueueTimeout means a call does not wait, it is rejected right away when there is no token or slot.
+type TenantLimits struct {
+	MaxConcurrent int           // Maximum number of orchestrations running at the same time
+	Rate          float64       // Orchestrations started per second on average, refills the token bucket
+	Burst         int           // Size of the token bucket, default 1 when Rate is set
+	QueueTimeout  time.Duration // How long a call may wait for a token and a slot before it is rejected, 0 never waits
+}
+
+// AdmissionController limits the number of orchestrations per tenant, so the bulk script of one tenant cannot starve
+// the others. The tenant is read from the context, see WithTenant. Set it in CallServicesOpts.Admission:
+//
+//	admission := NewAdmissionController(TenantLimits{MaxConcurrent: 10, Rate: 5, Burst: 20, QueueTimeout: 5 * time.Second})
+//	admission.SetLimits("bulk-tenant", TenantLimits{MaxConcurrent: 2, Rate: 1, QueueTimeout: time.Minute})
+//
+//	ctx = WithTenant(ctx, tenantID)
+//	status, response := CallServicesAndReply(ctx, services, CallServicesOpts{Admission: admission}) // 429 when rejected
+type AdmissionController struct {
+	Default TenantLimits // Limits of tenants without SetLimits, and of calls without a tenant
+
+	lock    sync.Mutex
+	limits  map[string]TenantLimits
+	tenants map[string]*tenantState
+	swept   time.Time // Last eviction of idle tenants
+}
+
+type tenantState struct {
+	limits    TenantLimits
+	slots     chan struct{} // Buffered with MaxConcurrent, nil when unlimited
+	tokens    float64
+	last      time.Time // Last refill of tokens
+	admitting int       // Calls in Admit, which keep using this state
+}
+
+// idleSweepInterval is how often the states of idle tenants are evicted, so every tenant ever seen is not kept
+const idleSweepInterval = time.Minute
+
+func NewAdmissionController(defaults TenantLimits) *AdmissionController {
+	return &AdmissionController{
+		Default: defaults,
+		limits:  map[string]TenantLimits{},
+		tenants: map[string]*tenantState{},
+	}
+}
+
+// WithTenant stores the tenant of the orchestration in ctx
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, "tenant", tenant)
+}
+
+// SetLimits overrides the Default limits of tenant. Running orchestrations keep the limits they were admitted with.
+func (a *AdmissionController) SetLimits(tenant string, limits TenantLimits) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.limits[tenant] = limits
+	delete(a.tenants, tenant)
+}
+
+// Running returns the number of running orchestrations of tenant
+func (a *AdmissionController) Running(tenant string) int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if state, ok := a.tenants[tenant]; ok {
+		return len(state.slots)
+	}
+	return 0
+}
+
+// Admit waits until the tenant in ctx may start an orchestration, release must be called when it is done. It returns
+// an AdmissionError when the limits are not met within the QueueTimeout, or the error of ctx.
+func (a *AdmissionController) Admit(ctx context.Context) (release func(), err error) {
+	tenant, _ := ctx.Value("tenant").(string)
+	state := a.state(tenant)
+	defer a.admitted(state)
+
+	var deadline <-chan time.Time
+	if state.limits.QueueTimeout > 0 {
+		timer := time.NewTimer(state.limits.QueueTimeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	if wait, ok := a.reserveToken(state); !ok {
+		return nil, &AdmissionError{Tenant: tenant, Reason: "rate limit exceeded", RetryAfter: wait}
+	} else if wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			a.returnToken(state)
+			return nil, ctx.Err()
+		}
+	}
+
+	if state.slots == nil {
+		return func() {}, nil
+	}
+	full := &AdmissionError{Tenant: tenant, Reason: fmt.Sprintf("limit of %d concurrent orchestrations reached", state.limits.MaxConcurrent)}
+	select {
+	case state.slots <- struct{}{}:
+	default:
+		if deadline == nil {
+			a.returnToken(state)
+			return nil, full
+		}
+		select {
+		case state.slots <- struct{}{}:
+		case <-deadline:
+			a.returnToken(state)
+			return nil, full
+		case <-ctx.Done():
+			a.returnToken(state)
+			return nil, ctx.Err()
+		}
+	}
+
+	var once sync.Once
+	return func() { once.Do(func() { <-state.slots }) }, nil
+}
+
+// state returns the state of tenant for a call to Admit, which must call admitted when it is done with it
+func (a *AdmissionController) state(tenant string) *tenantState {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if now := time.Now(); now.Sub(a.swept) >= idleSweepInterval {
+		a.evictIdle(now)
+		a.swept = now
+	}
+	if state, ok := a.tenants[tenant]; ok {
+		state.admitting++
+		return state
+	}
+
+	limits, ok := a.limits[tenant]
+	if !ok {
+		limits = a.Default
+	}
+	state := &tenantState{limits: limits, tokens: float64(limits.burst()), last: time.Now()}
+	if limits.MaxConcurrent > 0 {
+		state.slots = make(chan struct{}, limits.MaxConcurrent)
+	}
+	state.admitting++
+	a.tenants[tenant] = state
+	return state
+}
+
+func (a *AdmissionController) admitted(state *tenantState) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	state.admitting--
+}
+
+// evictIdle removes the states of tenants without calls that are running or in Admit, and with a full token bucket.
+// A new state for such a tenant is the same as the evicted one. The lock must be held.
+func (a *AdmissionController) evictIdle(now time.Time) {
+	for tenant, state := range a.tenants {
+		if state.admitting > 0 || len(state.slots) > 0 {
+			continue
+		}
+		limits := state.limits
+		if limits.Rate > 0 && state.tokens+now.Sub(state.last).Seconds()*limits.Rate < float64(limits.burst()) {
+			continue
+		}
+		delete(a.tenants, tenant)
+	}
+}
+
+// reserveToken takes a token from the bucket, possibly in the future: wait is how long to wait for it. When that is
+// longer than the QueueTimeout no token is taken, and wait is the time until one is available.
+func (a *AdmissionController) reserveToken(state *tenantState) (wait time.Duration, ok bool) {
+	if state.limits.Rate <= 0 {
+		return 0, true
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	now := time.Now()
+	state.tokens += now.Sub(state.last).Seconds() * state.limits.Rate
+	if burst := float64(state.limits.burst()); state.tokens > burst {
+		state.tokens = burst
+	}
+	state.last = now
+
+	if state.tokens >= 1 {
+		state.tokens--
+		return 0, true
+	}
+	wait = time.Duration((1 - state.tokens) / state.limits.Rate * float64(time.Second))
+	if wait > state.limits.QueueTimeout {
+		return wait, false
+	}
+	state.tokens-- // Reserved, the bucket refills before the wait is over
+	return wait, true
+}
+
+// returnToken gives back the token of a call that was not admitted after all
+func (a *AdmissionController) returnToken(state *tenantState) {
+	if state.limits.Rate <= 0 {
+		return
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	state.tokens++
+}
+
+func (l TenantLimits) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return 1
+}
+
+// admit is Admit for an optional AdmissionController
+func (opts CallServicesOpts) admit(ctx context.Context) (release func(), err error) {
+	if opts.Admission == nil {
+		return func() {}, nil
+	}
+	return opts.Admission.Admit(ctx)
+}
+
+func (e *AdmissionError) Error() string {
+	message := ErrAdmissionRejected.Error() + ": " + e.Reason
+	if e.Tenant != "" {
+		message += " for tenant " + e.Tenant
+	}
+	if e.RetryAfter > 0 {
+		message += ", retry after " + e.RetryAfter.Round(time.Millisecond).String()
+	}
+	return message
+}
+
+func (e *AdmissionError) Is(target error) bool {
+	return target == ErrAdmissionRejected
+}
+
+func (e *AdmissionError) StatusCode() int {
+	return http.StatusTooManyRequests
+}
+
+// SetRetryAfter sets the Retry-After header in whole seconds when the response is a rejection by the
+// AdmissionController with a RetryAfter. Call it before writing the status of the generated Response.
+func SetRetryAfter(header http.Header, response *Response) {
+	if response == nil || response.RetryAfter <= 0 {
+		return
+	}
+	seconds := (response.RetryAfter + time.Second - 1) / time.Second
+	header.Set("Retry-After", fmt.Sprint(int64(seconds)))
+}
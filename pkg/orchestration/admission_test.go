@@ -0,0 +1,146 @@
+package orchestration
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Struct definition required to satisfy the Service interface. Run blocks until release is closed.
+type BlockingService struct {
+	SimpleService
+	release chan struct{}
+}
+
+func (b *BlockingService) Name() string { return "Blocking" }
+
+func (b *BlockingService) Run(_ context.Context) error {
+	<-b.release
+	return nil
+}
+
+func TestAdmissionConcurrencyLimit(t *testing.T) {
+	admission := NewAdmissionController(TenantLimits{MaxConcurrent: 1, QueueTimeout: 20 * time.Millisecond})
+	opts := CallServicesOpts{Admission: admission}
+	blocking := &BlockingService{release: make(chan struct{})}
+	ctx := WithTenant(context.TODO(), "bulk")
+
+	done := make(chan error)
+	go func() {
+		_, err := CallServices(ctx, []Service{blocking}, opts)
+		done <- err
+	}()
+	for admission.Running("bulk") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	status, response := CallServicesAndReply(ctx, []Service{&BlockingService{}}, opts)
+	if status != http.StatusTooManyRequests || response.Details[0].Detail == "ok" {
+		t.Errorf("Expected 429 while the tenant is at its limit, got %d %v\n", status, response)
+	}
+	released := &BlockingService{release: make(chan struct{})}
+	close(released.release)
+	if _, err := CallServices(WithTenant(context.TODO(), "other"), []Service{released}, opts); err != nil {
+		t.Errorf("Expected another tenant to be admitted, got %v\n", err)
+	}
+
+	go func() { // Queued until the first call is done
+		time.Sleep(5 * time.Millisecond)
+		close(blocking.release)
+	}()
+	if _, err := CallServices(ctx, []Service{released}, opts); err != nil {
+		t.Errorf("Expected the queued call to be admitted after the first one, got %v\n", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Expected the first call to succeed, got %v\n", err)
+	}
+}
+
+func TestAdmissionRateLimit(t *testing.T) {
+	admission := NewAdmissionController(TenantLimits{Rate: 1, Burst: 2})
+	released := &BlockingService{release: make(chan struct{})}
+	close(released.release)
+
+	var errs []error
+	for i := 0; i < 3; i++ {
+		_, err := CallServices(context.TODO(), []Service{released}, CallServicesOpts{Admission: admission})
+		errs = append(errs, err)
+	}
+
+	var admissionErr *AdmissionError
+	if errs[0] != nil || errs[1] != nil || !errors.As(errs[2], &admissionErr) || !errors.Is(errs[2], ErrAdmissionRejected) {
+		t.Fatalf("Expected the burst of 2 to be admitted and the third call rejected, got %v\n", errs)
+	}
+	if admissionErr.RetryAfter <= 0 || admissionErr.RetryAfter > time.Second {
+		t.Errorf("Expected a retry after of at most a second, got %v\n", admissionErr.RetryAfter)
+	}
+}
+
+func TestAdmissionReturnsTokenWhenNoSlot(t *testing.T) {
+	// No QueueTimeout: a call is rejected right away when the tenant is at its limit
+	admission := NewAdmissionController(TenantLimits{MaxConcurrent: 1, Rate: 0.001, Burst: 2})
+	opts := CallServicesOpts{Admission: admission}
+	blocking := &BlockingService{release: make(chan struct{})}
+
+	done := make(chan error)
+	go func() {
+		_, err := CallServices(context.TODO(), []Service{blocking}, opts)
+		done <- err
+	}()
+	for admission.Running("") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	released := &BlockingService{release: make(chan struct{})}
+	close(released.release)
+	if _, err := CallServices(context.TODO(), []Service{released}, opts); !errors.Is(err, ErrAdmissionRejected) {
+		t.Fatalf("Expected a rejection without waiting, got %v\n", err)
+	}
+	close(blocking.release)
+	<-done
+	if _, err := CallServices(context.TODO(), []Service{released}, opts); err != nil {
+		t.Errorf("Expected the token of the rejected call to be returned, got %v\n", err)
+	}
+}
+
+func TestSetRetryAfter(t *testing.T) {
+	_, response := GenerateResponse(nil, nil, &AdmissionError{Reason: "rate limit exceeded", RetryAfter: 1500 * time.Millisecond})
+	header := http.Header{}
+	SetRetryAfter(header, response)
+	if header.Get("Retry-After") != "2" {
+		t.Errorf("Expected Retry-After to be rounded up to 2 seconds, got %q\n", header.Get("Retry-After"))
+	}
+
+	_, response = GenerateResponse(nil, nil, errors.New("failed"))
+	header = http.Header{}
+	SetRetryAfter(header, response)
+	if _, ok := header["Retry-After"]; ok {
+		t.Errorf("Expected no Retry-After for other errors\n")
+	}
+}
+
+func TestAdmissionEvictsIdleTenants(t *testing.T) {
+	admission := NewAdmissionController(TenantLimits{MaxConcurrent: 1, Rate: 1000, Burst: 1})
+	release, _ := admission.Admit(WithTenant(context.TODO(), "running"))
+	idle, _ := admission.Admit(WithTenant(context.TODO(), "idle"))
+	idle()
+
+	time.Sleep(5 * time.Millisecond) // Refills the bucket of "idle"
+	admission.swept = time.Time{}    // Sweeps on the next Admit
+	next, _ := admission.Admit(WithTenant(context.TODO(), "other"))
+	next()
+
+	admission.lock.Lock()
+	_, keptRunning := admission.tenants["running"]
+	_, keptIdle := admission.tenants["idle"]
+	admission.lock.Unlock()
+	if !keptRunning || keptIdle {
+		t.Errorf("Expected only the idle tenant to be evicted, kept running %v and idle %v\n", keptRunning, keptIdle)
+	}
+	if _, err := admission.Admit(WithTenant(context.TODO(), "running")); !errors.Is(err, ErrAdmissionRejected) {
+		t.Errorf("Expected the running tenant to keep its limit, got %v\n", err)
+	}
+	release()
+}
@@ -0,0 +1,168 @@
+package orchestration
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var _ http.Handler = &RestApiHandler{}
+
+// RestApiHandler serves a RestApi over HTTP, with the same REST conventions as HTTPRestApi, so a RestApi of one
+// process can be used by another. Mount it with the path of the resource stripped:
+//
+//	handler := &RestApiHandler{Api: &example.MyServiceApi{}, Decode: JSONDecoder[*example.MemoryClaim]()}
+//	http.Handle("/api/v1/memory/", http.StripPrefix("/api/v1/memory", handler))
+//
+// Errors are replied as a Response with the error as status. ErrNotFound is replied with 404, ErrConflict with 409,
+// errors that implement StatusCoder with their own status, a payload that cannot be decoded or is null with 400, and
+// other errors with 500. PATCH is served when the RestApi implements Patcher, and If-Match when it implements
+// ConditionalRestApi. Versioned responses, e.g. the result of Get, are replied with an ETag.
+type RestApiHandler struct {
+	Api    RestApi
+	Decode func(body []byte) (Nameable, error) // Decodes the payload of POST and PUT, see JSONDecoder
+}
+
+// JSONDecoder returns a decoder for RestApiHandler that decodes JSON into T, e.g. JSONDecoder[*MemoryClaim]()
+func JSONDecoder[T Nameable]() func(body []byte) (Nameable, error) {
+	return func(body []byte) (Nameable, error) {
+		obj := new(T)
+		if err := json.Unmarshal(body, obj); err != nil {
+			return nil, err
+		}
+		return *obj, nil
+	}
+}
+
+func (h *RestApiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(r.URL.Path, "/")
+	ctx := r.Context()
+	ifMatch := r.Header.Get("If-Match")
+	conditional, isConditional := h.Api.(ConditionalRestApi)
+	if ifMatch != "" && !isConditional {
+		h.replyError(w, &statusError{error: errors.New("conditional requests are not supported"), status: http.StatusNotImplemented})
+		return
+	}
+
+	status := http.StatusOK
+	var response any
+	var obj Nameable
+	var err error
+	switch {
+	case r.Method == http.MethodGet && name == "":
+		response, err = h.Api.List(ctx)
+	case r.Method == http.MethodGet:
+		if obj, err = h.Api.Get(ctx, name); err == nil {
+			response = obj
+		}
+	case r.Method == http.MethodPost && name == "":
+		if obj, err = h.decode(r); err == nil {
+			status = http.StatusCreated
+			response, err = h.Api.Post(ctx, obj)
+		}
+	case r.Method == http.MethodPut && name != "":
+		if obj, err = h.decode(r); err != nil {
+			break
+		}
+		if obj.Name() != name {
+			err = &statusError{error: errors.New("payload name " + obj.Name() + " does not match " + name), status: http.StatusBadRequest}
+		} else if ifMatch != "" {
+			response, err = conditional.PutIfMatch(ctx, obj, ifMatch)
+		} else {
+			response, err = h.Api.Put(ctx, obj)
+		}
+	case r.Method == http.MethodDelete && name != "":
+		if ifMatch != "" {
+			response, err = conditional.DeleteIfMatch(ctx, name, ifMatch)
+		} else {
+			response, err = h.Api.Delete(ctx, name)
+		}
+	case r.Method == http.MethodPatch && name != "":
+		patcher, ok := h.Api.(Patcher)
+		if !ok {
+			err = &statusError{error: errors.New("PATCH is not supported"), status: http.StatusMethodNotAllowed}
+			break
+		}
+		var patch []byte
+		if patch, err = io.ReadAll(r.Body); err == nil {
+			response, err = patcher.Patch(ctx, name, PatchType(r.Header.Get("Content-Type")), patch)
+		}
+	default:
+		err = &statusError{error: errors.New(r.Method + " is not allowed on this path"), status: http.StatusMethodNotAllowed}
+	}
+
+	if err != nil {
+		h.replyError(w, err)
+		return
+	}
+	if version := versionOf(response); version != "" {
+		w.Header().Set("ETag", version) // Lets the client make its next write conditional, e.g. a rollback
+	}
+	h.reply(w, status, response)
+}
+
+func (h *RestApiHandler) decode(r *http.Request) (Nameable, error) {
+	if h.Decode == nil {
+		return nil, errors.New("RestApiHandler: Decode is required for " + r.Method)
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := h.Decode(body)
+	if err != nil {
+		return nil, &statusError{error: errors.New("could not unmarshal request payload: " + err.Error()), status: http.StatusBadRequest}
+	}
+	if isNil(obj) {
+		return nil, &statusError{error: errors.New("request payload is empty"), status: http.StatusBadRequest}
+	}
+	return obj, nil
+}
+
+// reply writes response as JSON, a nil response is replied without content
+func (h *RestApiHandler) reply(w http.ResponseWriter, status int, response any) {
+	if response == nil {
+		if status == http.StatusOK {
+			status = http.StatusNoContent
+		}
+		w.WriteHeader(status)
+		return
+	}
+	raw, err := json.Marshal(response)
+	if err != nil {
+		h.replyError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(append(raw, '\n'))
+}
+
+func (h *RestApiHandler) replyError(w http.ResponseWriter, err error) {
+	status, response := generateResponseContainer(err)
+	if errors.Is(err, ErrNotFound) {
+		status = http.StatusNotFound
+	} else if errors.Is(err, ErrConflict) && status == http.StatusInternalServerError {
+		status = http.StatusConflict
+	}
+	raw, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(append(raw, '\n'))
+}
+
+// statusError is an error that is replied with status
+type statusError struct {
+	error
+	status int
+}
+
+func (e *statusError) StatusCode() int {
+	return e.status
+}
+
+func (e *statusError) Unwrap() error {
+	return e.error
+}
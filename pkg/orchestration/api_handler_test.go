@@ -0,0 +1,70 @@
+package orchestration
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRestApiHandler(t *testing.T) {
+	backend := &VersionedApi{claims: map[string]VersionedClaim{}}
+	mux := http.NewServeMux()
+	mux.Handle("/claims/", http.StripPrefix("/claims", &RestApiHandler{Api: backend, Decode: JSONDecoder[*VersionedClaim]()}))
+	mux.Handle("/claims", http.StripPrefix("/claims", &RestApiHandler{Api: backend, Decode: JSONDecoder[*VersionedClaim]()}))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// The RestApi of the backend, used from another process
+	api := &HTTPRestApi{BaseURL: server.URL, Resource: "claims", NewObject: func() Nameable { return &VersionedClaim{} }}
+	ctx := context.TODO()
+
+	equal := func(want, got Nameable) bool { return want.(*VersionedClaim).Claim == got.(*VersionedClaim).Claim }
+	create := RestApiAsService(api, REST_API_POST, "Create a", "a", &VersionedClaim{Claim: Claim{ClaimName: "a", MemoryInMb: 128}}).(*RestApiService)
+	create.Equal = equal
+	if errs, err := CallServices(ctx, []Service{create}, CallServicesOpts{}); err != nil {
+		t.Fatalf("Expected the claim to be created, got %v %v\n", err, errs)
+	}
+	got, err := api.Get(ctx, "a")
+	if err != nil || got.(*VersionedClaim).MemoryInMb != 128 || got.(*VersionedClaim).Version() != "1" {
+		t.Errorf("Expected claim a with version 1, got %+v %v\n", got, err)
+	}
+
+	update := RestApiAsService(api, REST_API_PUT, "Resize a", "a", &VersionedClaim{Claim: Claim{ClaimName: "a", MemoryInMb: 256}}).(*RestApiService)
+	update.Equal = equal
+	if errs, err := CallServices(ctx, []Service{update}, CallServicesOpts{}); err != nil {
+		t.Fatalf("Expected the claim to be updated, got %v %v\n", err, errs)
+	}
+	_, _ = backend.Put(ctx, &VersionedClaim{Claim: Claim{ClaimName: "a", MemoryInMb: 512}})
+	if err := update.Rollback(ctx); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected the rollback to conflict through the handler, got %v\n", err)
+	}
+
+	var httpErr *HTTPError
+	if _, err := api.Get(ctx, "b"); !errors.Is(err, ErrNotFound) || !errors.As(err, &httpErr) ||
+		httpErr.Body != `{"status":"not found","details":null}` {
+		t.Errorf("Expected a 404 with a JSON error, got %v\n", err)
+	}
+
+	requests := map[string]int{
+		http.MethodPut + " /claims/b " + `{"name": "a"}`: http.StatusBadRequest,
+		http.MethodPost + " /claims {":                   http.StatusBadRequest,
+		http.MethodPost + " /claims null":                http.StatusBadRequest,
+		http.MethodPut + " /claims/a null":               http.StatusBadRequest,
+		http.MethodPatch + " /claims/a {}":               http.StatusMethodNotAllowed,
+		http.MethodPost + " /claims/a {}":                http.StatusMethodNotAllowed,
+	}
+	for request, expected := range requests {
+		parts := strings.SplitN(request, " ", 3)
+		r, _ := http.NewRequest(parts[0], server.URL+parts[1], strings.NewReader(parts[2]))
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil || resp.StatusCode != expected {
+			t.Errorf("Expected %d for %s, got %v %v\n", expected, request, resp, err)
+		}
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+	}
+}
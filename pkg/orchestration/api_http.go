@@ -0,0 +1,259 @@
+package orchestration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+// ErrNotFound matches errors of a RestApi for an object that does not exist, e.g. an HTTPError with status 404
+var ErrNotFound = errors.New("not found")
+
+// HTTPError is returned by HTTPRestApi for a response with a non-2xx status
+type HTTPError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       string // Truncated to maxErrorBody
+}
+
+const maxErrorBody = 4096
+
+func (e *HTTPError) Error() string {
+	message := fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Body != "" {
+		message += ": " + e.Body
+	}
+	return message
+}
+
+// Is allows errors.Is(err, ErrNotFound) for a 404, and errors.Is(err, ErrConflict) for a 409 or 412
+func (e *HTTPError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict || e.StatusCode == http.StatusPreconditionFailed
+	}
+	return false
+}
+
+// Codec encodes and decodes the bodies of an HTTPRestApi
+type Codec interface {
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+type JSONCodec struct{}
+
+func (JSONCodec) ContentType() string                { return "application/json" }
+func (JSONCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (JSONCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+var _ RestApi = &HTTPRestApi{}
+var _ Patcher = &HTTPRestApi{}
+var _ ConditionalRestApi = &HTTPRestApi{}
+
+// HTTPRestApi is a RestApi for a resource that follows the usual REST conventions:
+//
+//	Get     GET    {BaseURL}/{Resource}/{name}
+//	Post    POST   {BaseURL}/{Resource}
+//	Put     PUT    {BaseURL}/{Resource}/{name}
+//	Delete  DELETE {BaseURL}/{Resource}/{name}
+//	Patch   PATCH  {BaseURL}/{Resource}/{name}
+//	List    GET    {BaseURL}/{Resource}
+//
+// A response with a non-2xx status is returned as HTTPError. Put and Delete are conditional with PutIfMatch and
+// DeleteIfMatch, which send If-Match. Get sets the ETag on objects that implement VersionSetter, and the responses of
+// Post, Put and Patch with an ETag are returned as VersionedResponse. For example:
+//
+//	api := &HTTPRestApi{
+//	    BaseURL:   "https://claims.example.com/api/v1",
+//	    Resource:  "claims",
+//	    NewObject: func() Nameable { return &MemoryClaim{} },
+//	}
+//	svc := RestApiAsService(api, REST_API_POST, "Create claim", claim.Name(), claim)
+type HTTPRestApi struct {
+	BaseURL   string
+	Resource  string
+	NewObject func() Nameable // Builds the object that Get decodes into, List decodes into a slice of its type
+	Client    *http.Client    // Default http.DefaultClient
+	Codec     Codec           // Default JSONCodec
+	Header    http.Header     // Added to every request, e.g. Authorization
+}
+
+func (a *HTTPRestApi) Get(ctx context.Context, name string) (Nameable, error) {
+	if a.NewObject == nil {
+		return nil, errors.New("HTTPRestApi: NewObject is required to Get " + name)
+	}
+	obj := a.NewObject()
+	target := reflect.ValueOf(obj)
+	byValue := target.Kind() != reflect.Pointer
+	if byValue {
+		target = reflect.New(reflect.TypeOf(obj)) // Decode a value type through a pointer to a copy
+		target.Elem().Set(reflect.ValueOf(obj))
+	}
+	header, err := a.do(ctx, http.MethodGet, a.url(name), nil, "", target.Interface())
+	if err != nil {
+		return nil, err
+	}
+	if setter, ok := target.Interface().(VersionSetter); ok && header.Get("ETag") != "" {
+		setter.SetVersion(header.Get("ETag"))
+	}
+	if byValue {
+		return target.Elem().Interface().(Nameable), nil
+	}
+	return obj, nil
+}
+
+func (a *HTTPRestApi) Post(ctx context.Context, obj Nameable) (interface{}, error) {
+	var response any
+	header, err := a.do(ctx, http.MethodPost, a.url(""), obj, "", &response)
+	return withVersion(header, response, err)
+}
+
+func (a *HTTPRestApi) Put(ctx context.Context, obj Nameable) (interface{}, error) {
+	return a.PutIfMatch(ctx, obj, "")
+}
+
+func (a *HTTPRestApi) Delete(ctx context.Context, name string) (interface{}, error) {
+	return a.DeleteIfMatch(ctx, name, "")
+}
+
+// PutIfMatch sends the version as If-Match header, an empty version makes it unconditional
+func (a *HTTPRestApi) PutIfMatch(ctx context.Context, obj Nameable, version string) (interface{}, error) {
+	var response any
+	header, err := a.do(ctx, http.MethodPut, a.url(obj.Name()), obj, version, &response)
+	return withVersion(header, response, err)
+}
+
+// DeleteIfMatch sends the version as If-Match header, an empty version makes it unconditional
+func (a *HTTPRestApi) DeleteIfMatch(ctx context.Context, name, version string) (interface{}, error) {
+	var response any
+	_, err := a.do(ctx, http.MethodDelete, a.url(name), nil, version, &response)
+	return response, err
+}
+
+// Patch sends patch as is, with patchType as Content-Type
+func (a *HTTPRestApi) Patch(ctx context.Context, name string, patchType PatchType, patch []byte) (interface{}, error) {
+	var response any
+	header, err := a.send(ctx, httpCall{method: http.MethodPatch, url: a.url(name), body: patch, contentType: string(patchType)}, &response)
+	return withVersion(header, response, err)
+}
+
+// List returns a slice of the type built by NewObject, e.g. []*MemoryClaim, or the generically decoded body when
+// NewObject is not set
+func (a *HTTPRestApi) List(ctx context.Context) (interface{}, error) {
+	if a.NewObject == nil {
+		var response any
+		_, err := a.do(ctx, http.MethodGet, a.url(""), nil, "", &response)
+		return response, err
+	}
+	list := reflect.New(reflect.SliceOf(reflect.TypeOf(a.NewObject())))
+	if _, err := a.do(ctx, http.MethodGet, a.url(""), nil, "", list.Interface()); err != nil {
+		return nil, err
+	}
+	return list.Elem().Interface(), nil
+}
+
+func (a *HTTPRestApi) url(name string) string {
+	u := strings.TrimSuffix(a.BaseURL, "/")
+	if resource := strings.Trim(a.Resource, "/"); resource != "" {
+		u += "/" + resource
+	}
+	if name != "" {
+		u += "/" + url.PathEscape(name)
+	}
+	return u
+}
+
+type httpCall struct {
+	method, url string
+	body        []byte // Optional
+	contentType string
+	ifMatch     string // Optional
+}
+
+// do sends a request with the encoded payload, if any, and decodes a non-empty response body into response
+func (a *HTTPRestApi) do(ctx context.Context, method, u string, payload any, ifMatch string, response any) (http.Header, error) {
+	call := httpCall{method: method, url: u, ifMatch: ifMatch}
+	if payload != nil {
+		raw, err := a.codec().Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		call.body, call.contentType = raw, a.codec().ContentType()
+	}
+	return a.send(ctx, call, response)
+}
+
+// send sends call, decodes a non-empty response body into response, and returns the response headers
+func (a *HTTPRestApi) send(ctx context.Context, call httpCall, response any) (http.Header, error) {
+	client := a.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	var body io.Reader
+	if call.body != nil {
+		body = bytes.NewReader(call.body)
+	}
+	request, err := http.NewRequestWithContext(ctx, call.method, call.url, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range a.Header {
+		for _, value := range values {
+			request.Header.Add(key, value)
+		}
+	}
+	request.Header.Set("Accept", a.codec().ContentType())
+	if call.body != nil {
+		request.Header.Set("Content-Type", call.contentType)
+	}
+	if call.ifMatch != "" {
+		request.Header.Set("If-Match", call.ifMatch)
+	}
+
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, err // Wraps ctx.Err() when the context is done
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return resp.Header, &HTTPError{Method: call.method, URL: call.url, StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(raw))}
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.Header, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return resp.Header, nil
+	}
+	return resp.Header, a.codec().Unmarshal(data, response)
+}
+
+// withVersion returns response as VersionedResponse when the write was successful and replied with an ETag
+func withVersion(header http.Header, response any, err error) (any, error) {
+	if etag := header.Get("ETag"); err == nil && etag != "" {
+		return &VersionedResponse{Body: response, ETag: etag}, nil
+	}
+	return response, err
+}
+
+func (a *HTTPRestApi) codec() Codec {
+	if a.Codec == nil {
+		return JSONCodec{}
+	}
+	return a.Codec
+}
@@ -0,0 +1,121 @@
+package orchestration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type Claim struct {
+	ClaimName  string `json:"name"`
+	MemoryInMb int    `json:"memory_in_mb"`
+}
+
+func (c Claim) Name() string { return c.ClaimName }
+
+// newClaimServer serves claims from memory under /claims, like a typical REST API
+func newClaimServer() *httptest.Server {
+	var lock sync.Mutex
+	claims := map[string]Claim{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/claims"), "/")
+		claim, exists := claims[name]
+
+		switch {
+		case r.Method == http.MethodGet && name == "":
+			list := []Claim{}
+			for _, claim := range claims {
+				list = append(list, claim)
+			}
+			_ = json.NewEncoder(w).Encode(list)
+		case r.Method == http.MethodGet && exists:
+			_ = json.NewEncoder(w).Encode(claim)
+		case r.Method == http.MethodPost || r.Method == http.MethodPut:
+			if err := json.NewDecoder(r.Body).Decode(&claim); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			claims[claim.ClaimName] = claim
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPatch && exists && r.Header.Get("Content-Type") == string(PATCH_MERGE):
+			var patch any
+			if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			doc, _ := toJSONObject(claim)
+			raw, _ := json.Marshal(applyMergePatch(doc, patch))
+			patched := Claim{}
+			_ = json.Unmarshal(raw, &patched)
+			claims[name] = patched
+		case r.Method == http.MethodDelete && exists:
+			delete(claims, name)
+			_, _ = w.Write([]byte(`{"deleted": true}`))
+		default:
+			http.Error(w, "claim "+name+" not found", http.StatusNotFound)
+		}
+	}))
+}
+
+func TestHTTPRestApi(t *testing.T) {
+	server := newClaimServer()
+	defer server.Close()
+	api := &HTTPRestApi{BaseURL: server.URL, Resource: "claims", NewObject: func() Nameable { return Claim{} }}
+	ctx := context.TODO()
+
+	services := []Service{
+		RestApiAsService(api, REST_API_POST, "Create a", "a", Claim{ClaimName: "a", MemoryInMb: 128}),
+		RestApiAsService(api, REST_API_POST, "Create b", "b", Claim{ClaimName: "b", MemoryInMb: 256}),
+	}
+	if errs, err := CallServices(ctx, services, CallServicesOpts{}); err != nil {
+		t.Fatalf("Expected the claims to be created, got %v %v\n", err, errs)
+	}
+
+	got, err := api.Get(ctx, "b")
+	if err != nil || got != (Claim{ClaimName: "b", MemoryInMb: 256}) {
+		t.Errorf("Expected claim b, got %v %v\n", got, err)
+	}
+	list, err := api.List(ctx)
+	if claims, ok := list.([]Claim); err != nil || !ok || len(claims) != 2 {
+		t.Errorf("Expected a list of 2 claims, got %#v %v\n", list, err)
+	}
+	response, err := api.Delete(ctx, "a")
+	if err != nil || response.(map[string]any)["deleted"] != true {
+		t.Errorf("Expected the decoded delete response, got %v %v\n", response, err)
+	}
+
+	_, err = api.Get(ctx, "a")
+	var httpErr *HTTPError
+	if !errors.Is(err, ErrNotFound) || !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusNotFound ||
+		httpErr.Body != "claim a not found" {
+		t.Errorf("Expected a 404 HTTPError, got %v\n", err)
+	}
+}
+
+func TestHTTPRestApiCancel(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	api := &HTTPRestApi{BaseURL: server.URL, Resource: "claims"}
+	done := make(chan error)
+	go func() {
+		_, err := api.List(ctx)
+		done <- err
+	}()
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the request to be cancelled, got %v\n", err)
+	}
+}
@@ -2,8 +2,10 @@ package orchestration
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"net/http"
+	"reflect"
 )
 
 type RestApi interface {
@@ -27,7 +29,8 @@ const (
 	REST_API_POST   RestApiAction = http.MethodPost
 	REST_API_PUT    RestApiAction = http.MethodPut
 	REST_API_DELETE RestApiAction = http.MethodDelete
-	REST_API_LIST   RestApiAction = "LIST" // Not an HTTP standard
+	REST_API_PATCH  RestApiAction = http.MethodPatch // Requires a RestApi that implements Patcher
+	REST_API_LIST   RestApiAction = "LIST"           // Not an HTTP standard
 )
 
 var _ Service = &SimpleRestApiService{}
@@ -39,16 +42,29 @@ type SimpleRestApiService struct {
 	ApiName        string
 	Api            RestApi       // Always required
 	Action         RestApiAction // Always Required, selects what action the Service should provide
-	RequestName    string        // Required when Action is GET, DELETE
+	RequestName    string        // Required when Action is GET, DELETE, PATCH
 	RequestPayload Nameable      // Required when Action is POST, PUT
+	PatchType      PatchType     // Used when Action is PATCH, default PATCH_MERGE
+	Patch          []byte        // Required when Action is PATCH
 
 	Response interface{} // Rest API response will be stored here when calling Run
 }
 
-// RestApiService converts a Rest API to a Service with inferred Check and Rollback
+var _ Verifier = &RestApiService{}
+
+// RestApiService converts a Rest API to a Service with inferred Check, Verify and Rollback. By default Verify compares
+// the members of the payload that are also in the object returned by Get as JSON, since that object often has fewer
+// members (e.g. write-only secrets) or more (e.g. set by the server). Set Equal to compare differently. When the
+// objects are Versioned and the RestApi implements ConditionalRestApi, updates are conditional on the version seen by
+// Check, and Rollback returns a ConflictError instead of overwriting a change that was made after Run.
 type RestApiService struct {
 	SimpleRestApiService
-	backup Nameable
+	Equal func(want, got Nameable) bool // Used by Verify to compare the payload with the result of Get, default JSONSubsetEqual
+
+	backup     Nameable
+	version    string // Version of backup
+	ran        bool   // Run succeeded
+	runVersion string // Version of the object after Run, as reported by the write
 }
 
 func RestApiAsService(api RestApi, action RestApiAction, apiName, name string, payload Nameable) Service {
@@ -92,6 +108,12 @@ func (proto *SimpleRestApiService) Run(ctx context.Context) error {
 		response, err = proto.Api.Delete(ctx, proto.RequestName)
 	} else if proto.Action == REST_API_LIST {
 		response, err = proto.Api.List(ctx)
+	} else if proto.Action == REST_API_PATCH {
+		patcher, ok := proto.Api.(Patcher)
+		if !ok {
+			return errors.New("RestApi of " + proto.ApiName + " does not implement Patcher")
+		}
+		response, err = patcher.Patch(ctx, proto.RequestName, proto.patchType(), proto.Patch)
 	} else {
 		return errors.New("unrecognized RestApiService Action (did you init?): " + string(proto.Action))
 	}
@@ -119,12 +141,98 @@ func (proto *RestApiService) Check(ctx context.Context) error {
 	}
 
 	proto.backup = backup
+	if err == nil {
+		proto.version = versionOf(backup)
+	}
 	return err
 }
 
+// Run makes PUT and DELETE conditional on the version seen by Check, when the RestApi implements ConditionalRestApi.
+// The version produced by the write is taken from its response, e.g. a VersionedResponse or the updated object.
+func (proto *RestApiService) Run(ctx context.Context) error {
+	conditional, isConditional := proto.Api.(ConditionalRestApi)
+	var err error
+	if isConditional && proto.Action == REST_API_PUT && proto.version != "" {
+		proto.Response, err = conditional.PutIfMatch(ctx, proto.RequestPayload, proto.version)
+	} else if isConditional && proto.Action == REST_API_DELETE && proto.version != "" {
+		proto.Response, err = conditional.DeleteIfMatch(ctx, proto.RequestName, proto.version)
+	} else {
+		err = proto.SimpleRestApiService.Run(ctx)
+	}
+
+	// Reading the version with a Get after the write could return the version of a concurrent writer as ours
+	proto.ran = err == nil
+	proto.runVersion = ""
+	if err == nil {
+		proto.runVersion = versionOf(proto.Response)
+	}
+	return err
+}
+
+// Verify re-reads the object with Get. After a POST/PUT it should equal the payload, see Equal, after a DELETE it
+// should be gone.
+func (proto *RestApiService) Verify(ctx context.Context) error {
+	if proto.Action == REST_API_POST || proto.Action == REST_API_PUT {
+		name := proto.RequestPayload.Name()
+		got, err := proto.Api.Get(ctx, name)
+		if err != nil {
+			return err
+		}
+		equal := proto.Equal
+		if equal == nil {
+			equal = JSONSubsetEqual
+		}
+		if !equal(proto.RequestPayload, got) {
+			return errors.New(name + " does not match the requested payload")
+		}
+		return nil
+	}
+
+	if proto.Action == REST_API_DELETE {
+		if _, err := proto.Api.Get(ctx, proto.RequestName); err == nil {
+			return errors.New(proto.RequestName + " still exists")
+		}
+	}
+
+	if proto.Action == REST_API_PATCH && proto.patchType() == PATCH_MERGE {
+		// Applying the patch again should not change anything, JSON Patches are not verified since they need not
+		// be idempotent
+		got, err := proto.Api.Get(ctx, proto.RequestName)
+		if err != nil {
+			return err
+		}
+		gotDoc, err := toJSONObject(got)
+		if err != nil {
+			return err
+		}
+		var patch any
+		if err := json.Unmarshal(proto.Patch, &patch); err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(applyMergePatch(gotDoc, patch), any(gotDoc)) {
+			return errors.New(proto.RequestName + " does not match the requested patch")
+		}
+	}
+	return nil // Nothing to verify for Get/List
+}
+
 func (proto *RestApiService) Rollback(ctx context.Context) error {
+	conditional, isConditional := proto.Api.(ConditionalRestApi)
+	expected := proto.version // The version before Run, when Run failed
+	if isConditional && proto.ran && proto.Action != REST_API_DELETE {
+		if proto.runVersion == "" && proto.versioned() {
+			return errors.New("rollback of " + proto.ApiName + " skipped: the version written by Run is unknown, so " +
+				"a change made after Run could be overwritten")
+		}
+		expected = proto.runVersion
+	}
+
 	if proto.Action == REST_API_PUT {
 		// In case Update failed, we Update again to restore backup
+		if isConditional && expected != "" {
+			_, err := conditional.PutIfMatch(ctx, proto.backup, expected)
+			return err
+		}
 		_, err := proto.Api.Put(ctx, proto.backup)
 		return err
 	}
@@ -132,6 +240,10 @@ func (proto *RestApiService) Rollback(ctx context.Context) error {
 	if proto.Action == REST_API_POST {
 		// In case Creation failed, we Delete
 		name := proto.RequestPayload.Name()
+		if isConditional && expected != "" {
+			_, err := conditional.DeleteIfMatch(ctx, name, expected)
+			return err
+		}
 		_, err := proto.Api.Delete(ctx, name)
 		return err
 	}
@@ -142,5 +254,73 @@ func (proto *RestApiService) Rollback(ctx context.Context) error {
 		return err
 	}
 
+	if proto.Action == REST_API_PATCH {
+		// In case Patch failed, we apply the reverse patch to restore the patched members of backup
+		var current Nameable
+		if proto.patchType() == PATCH_JSON || isConditional {
+			var err error
+			if current, err = proto.Api.Get(ctx, proto.RequestName); err != nil {
+				return err
+			}
+			if actual := versionOf(current); isConditional && expected != "" && actual != expected {
+				return &ConflictError{Name: proto.RequestName, Expected: expected, Actual: actual}
+			}
+		}
+		reverse, err := reversePatch(proto.patchType(), proto.Patch, proto.backup, current)
+		if err != nil || reverse == nil {
+			return err
+		}
+		patcher, ok := proto.Api.(Patcher)
+		if !ok {
+			return errors.New("RestApi of " + proto.ApiName + " does not implement Patcher")
+		}
+		_, err = patcher.Patch(ctx, proto.RequestName, proto.patchType(), reverse)
+		return err
+	}
+
 	return nil // Nothing to rollback for Get/List
 }
+
+// versioned returns whether the object is Versioned, so the version written by Run is needed for a safe Rollback
+func (proto *RestApiService) versioned() bool {
+	_, isVersioned := proto.RequestPayload.(Versioned)
+	return proto.version != "" || isVersioned
+}
+
+func (proto *SimpleRestApiService) patchType() PatchType {
+	if proto.PatchType == "" {
+		return PATCH_MERGE
+	}
+	return proto.PatchType
+}
+
+// JSONSubsetEqual compares want and got as JSON objects, only the members of want that are also in got are compared.
+// Nested objects are compared the same way, other values must be equal.
+func JSONSubsetEqual(want, got Nameable) bool {
+	if want == nil || got == nil {
+		return want == nil && got == nil
+	}
+	wantDoc, err := toJSONObject(want)
+	if err != nil {
+		return false
+	}
+	gotDoc, err := toJSONObject(got)
+	if err != nil {
+		return false
+	}
+	return jsonSubset(wantDoc, gotDoc)
+}
+
+func jsonSubset(want, got any) bool {
+	wantObject, isObject := want.(map[string]any)
+	gotObject, bothObjects := got.(map[string]any)
+	if !isObject || !bothObjects {
+		return reflect.DeepEqual(want, got)
+	}
+	for member, value := range wantObject {
+		if gotValue, ok := gotObject[member]; ok && !jsonSubset(value, gotValue) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,50 @@
+package orchestration
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// StoringApi stores the payloads it receives, and returns Stored from Get when set
+type StoringApi struct {
+	GreedyApi
+	Stored Nameable
+}
+
+func (a *StoringApi) Get(ctx context.Context, name string) (Nameable, error) {
+	if a.Stored != nil && a.received != nil {
+		return a.Stored, nil
+	}
+	return a.GreedyApi.Get(ctx, name)
+}
+
+func TestRestApiServiceVerify(t *testing.T) {
+	payload := func() Nameable {
+		return &Quota{QuotaName: "team-a", Limits: map[string]int{"cpu": 4}, Tags: []string{"a"}}
+	}
+	opts := CallServicesOpts{VerifyTimeout: 10 * time.Millisecond}
+
+	api := &StoringApi{Stored: &Quota{QuotaName: "team-a", Limits: map[string]int{"cpu": 2}, Tags: []string{"a"}}}
+	errs, err := CallServices(context.TODO(), []Service{RestApiAsService(api, REST_API_POST, "Create quota", "team-a", payload())}, opts)
+	Wait()
+	if !errors.Is(err, ErrVerifyFailed) || errs[0] == nil {
+		t.Errorf("Expected a Get that returns another object to fail the verification, got %v %v\n", errs, err)
+	}
+	if api.received != nil {
+		t.Errorf("Expected the failed verification to roll back the creation\n")
+	}
+
+	// Members that Get does not return, like write-only secrets, or that it adds are not compared
+	api = &StoringApi{Stored: &Claim{ClaimName: "team-a"}}
+	if _, err := CallServices(context.TODO(), []Service{RestApiAsService(api, REST_API_POST, "Create quota", "team-a", payload())}, opts); err != nil {
+		t.Errorf("Expected only the members in both objects to be compared, got %v\n", err)
+	}
+
+	svc := RestApiAsService(&StoringApi{Stored: &Quota{QuotaName: "team-b"}}, REST_API_POST, "Create quota", "team-a", payload()).(*RestApiService)
+	svc.Equal = func(_, _ Nameable) bool { return true }
+	if _, err := CallServices(context.TODO(), []Service{svc}, opts); err != nil {
+		t.Errorf("Expected Equal to replace the default comparison, got %v\n", err)
+	}
+}
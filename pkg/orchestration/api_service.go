@@ -3,9 +3,10 @@ package orchestration
 import (
 	"context"
 	"errors"
+	"github.com/ing-bank/orchestration-pkg/pkg/redact"
 	"github.com/ing-bank/orchestration-pkg/pkg/task"
-	"log"
 	"strings"
+	"time"
 )
 
 type Service interface {
@@ -19,11 +20,70 @@ type Service interface {
 	GetResponse(err error) any
 }
 
+// Verifier can be implemented by a Service to verify that its Run is live and healthy. Verify is polled every
+// VerifyInterval after all Runs succeeded, until it returns nil or CallServicesOpts.VerifyTimeout has passed. A
+// failed verification triggers the same Rollback as a failed Run.
+type Verifier interface {
+	Verify(ctx context.Context) error
+}
+
+// ServiceWrapper can be implemented by a Service that wraps another Service, like DryRunService. ResultOf and
+// RollbackQueue.Enqueue unwrap it to find the TypedService and PersistentService of the wrapped Service.
+type ServiceWrapper interface {
+	Unwrap() Service
+}
+
+// VerifyingWrapper is a ServiceWrapper that forwards Verify to the wrapped Service, see WrapPreserving
+type VerifyingWrapper interface {
+	Service
+	Verifier
+	ServiceWrapper
+}
+
+// nonVerifyingWrapper hides the Verify of a VerifyingWrapper
+type nonVerifyingWrapper interface {
+	Service
+	ServiceWrapper
+}
+
+// WrapPreserving returns outer, a wrapper of inner, that implements Verifier and OutputConsumer only when inner does.
+// Without it a wrapper either hides the optional interfaces of inner, or claims to verify a Service that can't:
+//
+//	return orchestration.WrapPreserving(service, &myWrapper{Wrapped: service})
+func WrapPreserving(inner Service, outer VerifyingWrapper) Service {
+	consumer, isConsumer := inner.(OutputConsumer)
+	_, isVerifier := inner.(Verifier)
+	switch {
+	case isConsumer && isVerifier:
+		return &struct {
+			VerifyingWrapper
+			OutputConsumer
+		}{outer, consumer}
+	case isConsumer:
+		return &struct {
+			nonVerifyingWrapper
+			OutputConsumer
+		}{outer, consumer}
+	case isVerifier:
+		return outer
+	}
+	return &struct{ nonVerifyingWrapper }{outer}
+}
+
 type CallServicesOpts struct {
 	SkipRollback  bool
 	OnActionError func(ctx context.Context, action ServiceAction, services []Service, errs []error) // Check/Run actions
 
 	OnStageStart func(ctx context.Context, services []Service)
+
+	VerifyTimeout time.Duration // Maximum time to poll Verify after all Runs succeeded, default DefaultVerifyTimeout
+
+	Approvals      *ApprovalGate // Required when ApprovalStages is set
+	ApprovalStages []int         // Stages that need approval between their Check and Run, see CallStagedServices
+
+	Admission *AdmissionController // Optional per-tenant limits, a rejected call returns an AdmissionError
+
+	resumed *Approval // Set by ApprovalGate.Resume, the call starts at the stage of this approval
 }
 
 // SimpleService implements, Check, Recover, Rollback, and GetResponse with dummy implementations
@@ -79,9 +139,13 @@ var RollbackErrorReporter func(context.Context, []Service, []error)
 
 var ActionLogger = func(_ context.Context, _ []Service, _ ServiceAction) {} // Default is no logs
 
+// ActionReporter is called after every action with the errors of the Services (aligned), e.g. for auditing. Called
+// synchronously, so keep it fast.
+var ActionReporter func(ctx context.Context, services []Service, action ServiceAction, errs []error, start time.Time)
+
 func GenericActionLogger(_ context.Context, svcs []Service, action ServiceAction) {
 	names := Services(svcs).GetNames()
-	log.Printf("[CallServices]: Running stage %s for: %s\n", action, strings.Join(names, ","))
+	redact.Logf("[CallServices]: Running stage %s for: %s\n", action, strings.Join(names, ","))
 }
 
 type Services []Service
@@ -103,8 +167,32 @@ func (r *Recoverable) Recover(ctx context.Context) error {
 }
 
 var _ Service = &DryRunService{}
+var _ ServiceWrapper = &DryRunService{}
+
+var (
+	ErrCheckFailed    = errors.New("one or more pre-run checks failed")
+	ErrRecoverFailed  = errors.New("unable to recover from one or more failed pre-run checks")
+	ErrRunFailed      = errors.New("one or more runs failed")
+	ErrVerifyFailed   = errors.New("one or more verifications failed")
+	ErrRollbackFailed = errors.New("one or more rollbacks failed")
+)
 
 func CallServices(ctx context.Context, services []Service, opts CallServicesOpts) ([]error, error) {
+	if IsShuttingDown() {
+		return fillErrors(ErrShuttingDown, len(services)), ErrShuttingDown
+	}
+	release, err := opts.admit(ctx)
+	if err != nil {
+		return fillErrors(err, len(services)), err
+	}
+	defer release()
+	return callServices(ctx, services, opts, nil)
+}
+
+// callServices is CallServices with an optional beforeRun, which is called after all Checks passed. When it returns
+// an error the Services are not run.
+func callServices(ctx context.Context, services []Service, opts CallServicesOpts, beforeRun func(checkErrs []error) error) ([]error, error) {
+	ctx = WithOutputs(ctx)
 	errs := RunServiceAction(ctx, services, SERVICE_CHECK)
 	if task.AnyError(errs) {
 		if opts.OnActionError != nil {
@@ -113,23 +201,43 @@ func CallServices(ctx context.Context, services []Service, opts CallServicesOpts
 
 		if ctx.Value("dryRun") == nil && ctx.Value("recover") != nil {
 			if task.AnyError(RunServiceAction(ctx, services, SERVICE_RECOVER)) { // Recovery errors are discarded
-				return errs, errors.New("unable to recover from one or more failed pre-run checks")
+				return errs, ErrRecoverFailed
 			}
 		} else {
-			return errs, errors.New("one or more pre-run checks failed")
+			return errs, ErrCheckFailed
 		}
 	}
 
 	if ctx.Value("dryRun") == nil {
+		if beforeRun != nil {
+			if err := beforeRun(errs); err != nil {
+				return fillErrors(err, len(services)), err
+			}
+		}
 		errs = RunServiceAction(ctx, services, SERVICE_RUN)
 		if task.AnyError(errs) {
 			if opts.OnActionError != nil {
 				opts.OnActionError(ctx, SERVICE_RUN, services, errs)
 			}
 			if !opts.SkipRollback {
-				go RunServiceAction(ctx, services, SERVICE_ROLLBACK)
+				goBackground(func() { RunServiceAction(ctx, services, SERVICE_ROLLBACK) })
+			}
+			return errs, ErrRunFailed
+		}
+
+		if anyVerifier(services) {
+			verifyCtx, cancel := context.WithTimeout(ctx, opts.verifyTimeout())
+			errs = RunServiceAction(verifyCtx, services, SERVICE_VERIFY)
+			cancel()
+			if task.AnyError(errs) {
+				if opts.OnActionError != nil {
+					opts.OnActionError(ctx, SERVICE_VERIFY, services, errs)
+				}
+				if !opts.SkipRollback {
+					goBackground(func() { RunServiceAction(ctx, services, SERVICE_ROLLBACK) })
+				}
+				return errs, ErrVerifyFailed
 			}
-			return errs, errors.New("one or more runs failed")
 		}
 	}
 	return errs, nil
@@ -141,27 +249,80 @@ func CallServicesAndReply(ctx context.Context, services []Service, opts CallServ
 }
 
 func CallStagedServices(ctx context.Context, stages [][]Service, opts CallServicesOpts) (int, []error, error) {
-	for i := 0; i < len(stages); i++ {
+	return callStagedServices(ctx, stages, opts, nil)
+}
+
+// callStagedServices calls the stages one after another. When call is set the stages can be cancelled, and all
+// rollbacks are done before returning.
+func callStagedServices(ctx context.Context, stages [][]Service, opts CallServicesOpts, call *StagedCall) (int, []error, error) {
+	if IsShuttingDown() && len(stages) > 0 {
+		return 0, fillErrors(ErrShuttingDown, len(stages[0])), ErrShuttingDown
+	}
+	if len(opts.ApprovalStages) > 0 && opts.Approvals == nil && len(stages) > 0 {
+		return 0, fillErrors(ErrNoApprovalGate, len(stages[0])), ErrNoApprovalGate
+	}
+	release, err := opts.admit(ctx)
+	if err != nil {
+		if len(stages) == 0 {
+			return 0, nil, err
+		}
+		return 0, fillErrors(err, len(stages[0])), err
+	}
+	defer release()
+	ctx = WithOutputs(ctx) // Shared by all stages
+	ctx = withStageHistory(ctx)
+	stageOpts := opts
+	stageOpts.SkipRollback = true // All stages that ran are rolled back below, in reversed order
+
+	for i := opts.firstStage(); i < len(stages); i++ {
+		if call.isCancelled() {
+			call.rollback(ctx, stages[:i], opts)
+			return i, fillErrors(ErrCancelled, len(stages[i])), ErrCancelled
+		}
 		if opts.OnStageStart != nil {
 			opts.OnStageStart(ctx, stages[i])
 		}
-		errs, err := CallServices(ctx, stages[i], opts) // Note: opts.SkipRollback = true
+		var beforeRun func([]error) error
+		if opts.requiresApproval(i) {
+			stage, index := stages[i], i
+			beforeRun = func(checkErrs []error) error {
+				return opts.Approvals.await(ctx, index, stage, checkErrs, call.cancelChan(), opts.resumedAt(index))
+			}
+		}
+		errs, err := callServices(ctx, stages[i], stageOpts, beforeRun)
 		if err != nil {
-			// Stage failed. Rollback all stages that ran in reversed order
-			if !opts.SkipRollback {
-				go func() {
-					for j := i - 1; j >= 0; j-- { // We don't Roll back current stage because CallServices will do that
-						RunServiceAction(ctx, stages[j], SERVICE_ROLLBACK)
-					}
-				}()
+			// Stage failed. Rollback all stages that ran in reversed order, including the current one when it ran
+			ran := stages[:i]
+			if errors.Is(err, ErrRunFailed) || errors.Is(err, ErrVerifyFailed) {
+				ran = stages[:i+1]
+			}
+			if call != nil {
+				call.rollback(ctx, ran, opts)
+				if call.isCancelled() {
+					err = ErrCancelled
+				}
+			} else if !opts.SkipRollback {
+				goBackground(func() { rollbackStages(ctx, ran) })
 			}
 			return i, errs, err
 		}
+		recordStage(ctx, i, stages[i], errs)
+	}
+	if call.isCancelled() { // Cancelled during the last stage, roll back all stages even though they all ran
+		call.rollback(ctx, stages, opts)
+		return len(stages), nil, ErrCancelled
 	}
 
 	return len(stages), nil, nil
 }
 
+// rollbackStages rolls back stages one after another, in reversed order
+func rollbackStages(ctx context.Context, stages [][]Service) {
+	for j := len(stages) - 1; j >= 0; j-- {
+		RunServiceAction(ctx, stages[j], SERVICE_ROLLBACK)
+	}
+}
+
 func CallStagedServicesAndReply(ctx context.Context, stages [][]Service, opts CallServicesOpts) (int, *Response) {
 	nStagesRun, errs, err := CallStagedServices(ctx, stages, opts)
 	return GenerateStagedResponse(stages, nStagesRun, errs, err)
@@ -181,24 +342,73 @@ const (
 	SERVICE_CHECK    ServiceAction = "CHECK"
 	SERVICE_RECOVER  ServiceAction = "RECOVER"
 	SERVICE_RUN      ServiceAction = "RUN"
+	SERVICE_VERIFY   ServiceAction = "VERIFY"
 	SERVICE_ROLLBACK ServiceAction = "ROLLBACK"
 )
 
+var DefaultVerifyTimeout = 30 * time.Second
+var VerifyInterval = time.Second
+
 func (p ProtoService) Run(ctx context.Context) error {
 	if p.action == SERVICE_CHECK {
+		if consumer, ok := p.service.(OutputConsumer); ok {
+			if err := RequireOutputs(ctx, consumer.RequiredOutputs()...); err != nil {
+				return err
+			}
+		}
 		return p.service.Check(ctx)
 	} else if p.action == SERVICE_RECOVER {
 		return p.service.Recover(ctx)
 	} else if p.action == SERVICE_RUN {
 		return p.service.Run(ctx)
+	} else if p.action == SERVICE_VERIFY {
+		if verifier, ok := p.service.(Verifier); ok {
+			return pollVerify(ctx, verifier)
+		}
+		return nil
 	} else if p.action == SERVICE_ROLLBACK {
 		return p.service.Rollback(ctx)
 	}
 	return errors.New("ProtoService Run called with invalid action (did you init?): " + string(p.action))
 }
 
+// pollVerify calls Verify until it succeeds, or until the next poll would pass the deadline of ctx
+func pollVerify(ctx context.Context, verifier Verifier) error {
+	for {
+		err := verifier.Verify(ctx)
+		if err == nil {
+			return nil
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(VerifyInterval).After(deadline) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(VerifyInterval):
+		}
+	}
+}
+
+func anyVerifier(services []Service) bool {
+	for _, service := range services {
+		if _, ok := service.(Verifier); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (opts CallServicesOpts) verifyTimeout() time.Duration {
+	if opts.VerifyTimeout > 0 {
+		return opts.VerifyTimeout
+	}
+	return DefaultVerifyTimeout
+}
+
 func RunServiceAction(ctx context.Context, services []Service, action ServiceAction) []error {
 	ActionLogger(ctx, services, action)
+	start := time.Now()
 
 	// Convert []Service to []task.Runnable using ProtoService
 	var tasks []task.Runnable
@@ -208,10 +418,13 @@ func RunServiceAction(ctx context.Context, services []Service, action ServiceAct
 
 	// Run all Services concurrently
 	errs := task.Run(tasks, ctx)
+	if ActionReporter != nil {
+		ActionReporter(ctx, services, action, errs, start)
+	}
 
 	// In case of Rollback errors a reporter function is informed
 	if action == SERVICE_ROLLBACK && RollbackErrorReporter != nil {
-		go RollbackErrorReporter(ctx, services, errs)
+		goBackground(func() { RollbackErrorReporter(ctx, services, errs) })
 	}
 	return errs
 }
@@ -238,3 +451,7 @@ func (d *DryRunService) Rollback(_ context.Context) error {
 func (d *DryRunService) GetResponse(err error) any {
 	return d.Wrapper.GetResponse(err)
 }
+
+func (d *DryRunService) Unwrap() Service {
+	return d.Wrapper
+}
@@ -0,0 +1,102 @@
+package orchestration_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration"
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration/orchestrationtest"
+)
+
+func TestStagedRollbackInReverseStageOrder(t *testing.T) {
+	recorder := orchestrationtest.NewRecorder()
+	stages := [][]orchestration.Service{
+		{recorder.Fake("a")},
+		{recorder.Fake("b").DelayOn(orchestration.SERVICE_ROLLBACK, 10*time.Millisecond)},
+		{recorder.Fake("c").DelayOn(orchestration.SERVICE_ROLLBACK, 10*time.Millisecond).FailOn(orchestration.SERVICE_VERIFY, errors.New("unhealthy")).Verifying()},
+	}
+
+	_, _, err := orchestration.CallStagedServices(context.TODO(), stages, orchestration.CallServicesOpts{VerifyTimeout: time.Millisecond})
+	orchestration.Wait()
+	if !errors.Is(err, orchestration.ErrVerifyFailed) {
+		t.Fatalf("Expected ErrVerifyFailed, got %v\n", err)
+	}
+	// The failed stage is rolled back once, before the stages that completed
+	orchestrationtest.AssertActions(t, recorder, "c", orchestration.SERVICE_CHECK, orchestration.SERVICE_RUN, orchestration.SERVICE_VERIFY, orchestration.SERVICE_ROLLBACK)
+	orchestrationtest.AssertRolledBackInReverseStageOrder(t, recorder, stages)
+}
+
+func TestStagedSkipRollback(t *testing.T) {
+	recorder := orchestrationtest.NewRecorder()
+	stages := [][]orchestration.Service{
+		{recorder.Fake("a")},
+		{recorder.Fake("b").FailOn(orchestration.SERVICE_RUN, errors.New("boom"))},
+	}
+
+	_, _, err := orchestration.CallStagedServices(context.TODO(), stages, orchestration.CallServicesOpts{SkipRollback: true})
+	orchestration.Wait()
+	if !errors.Is(err, orchestration.ErrRunFailed) {
+		t.Fatalf("Expected ErrRunFailed, got %v\n", err)
+	}
+	orchestrationtest.AssertNotRolledBack(t, recorder, "a", "b")
+}
+
+// Struct definition required to satisfy the Service interface, it is a Verifier and an OutputConsumer
+type VerifyingConsumer struct {
+	orchestration.SimpleService
+	Verified bool
+}
+
+func (v *VerifyingConsumer) Name() string                     { return "Verifying consumer" }
+func (v *VerifyingConsumer) Run(_ context.Context) error      { return nil }
+func (v *VerifyingConsumer) Rollback(_ context.Context) error { return nil }
+func (v *VerifyingConsumer) RequiredOutputs() []string        { return []string{"id"} }
+
+func (v *VerifyingConsumer) Verify(_ context.Context) error {
+	v.Verified = true
+	return nil
+}
+
+// Struct definition required to satisfy the VerifyingWrapper interface
+type CountingWrapper struct {
+	orchestration.Service
+	Verifies int
+}
+
+func (c *CountingWrapper) Verify(ctx context.Context) error {
+	c.Verifies++
+	return c.Service.(orchestration.Verifier).Verify(ctx)
+}
+
+func (c *CountingWrapper) Unwrap() orchestration.Service { return c.Service }
+
+func TestWrapPreserving(t *testing.T) {
+	inner := &VerifyingConsumer{}
+	outer := &CountingWrapper{Service: inner}
+	wrapped := orchestration.WrapPreserving(inner, outer)
+	verifier, isVerifier := wrapped.(orchestration.Verifier)
+	consumer, isConsumer := wrapped.(orchestration.OutputConsumer)
+	if !isVerifier || !isConsumer {
+		t.Fatalf("Expected the Verifier and OutputConsumer of the inner Service to be preserved\n")
+	}
+	if err := verifier.Verify(context.TODO()); err != nil || outer.Verifies != 1 || !inner.Verified {
+		t.Errorf("Expected Verify to go through the wrapper, got %v\n", err)
+	}
+	if outputs := consumer.RequiredOutputs(); len(outputs) != 1 || outputs[0] != "id" {
+		t.Errorf("Expected the required outputs of the inner Service, got %v\n", outputs)
+	}
+	if unwrapped := wrapped.(orchestration.ServiceWrapper).Unwrap(); unwrapped != inner {
+		t.Errorf("Expected Unwrap to return the inner Service, got %v\n", unwrapped)
+	}
+
+	plain := orchestrationtest.NewRecorder().Fake("plain")
+	wrapped = orchestration.WrapPreserving(plain, &CountingWrapper{Service: plain})
+	if _, ok := wrapped.(orchestration.Verifier); ok {
+		t.Errorf("Expected no Verifier for a Service that can't verify\n")
+	}
+	if _, ok := wrapped.(orchestration.ServiceWrapper); !ok {
+		t.Errorf("Expected the wrapper to be unwrappable\n")
+	}
+}
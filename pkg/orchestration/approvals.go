@@ -0,0 +1,442 @@
+package orchestration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/redact"
+)
+
+var (
+	ErrApprovalRejected = errors.New("approval rejected")
+	ErrApprovalTimeout  = errors.New("approval timed out")
+	ErrNoApprovalGate   = errors.New("approval stages require CallServicesOpts.Approvals")
+
+	// ErrApprovalNotPending is wrapped by every ApprovalNotPendingError
+	ErrApprovalNotPending = errors.New("approval is not pending")
+)
+
+type ApprovalStatus string
+
+const (
+	APPROVAL_PENDING  ApprovalStatus = "PENDING"
+	APPROVAL_APPROVED ApprovalStatus = "APPROVED"
+	APPROVAL_REJECTED ApprovalStatus = "REJECTED"
+	APPROVAL_EXPIRED  ApprovalStatus = "EXPIRED" // Timed out or cancelled
+)
+
+// ApprovalNotPendingError is returned when deciding on or resuming an approval that was already decided, expired, or
+// that an orchestration already waits for. It results in HTTP 409.
+type ApprovalNotPendingError struct {
+	ID     string
+	Status ApprovalStatus
+}
+
+// Approval is a request to run a stage, after the Checks of that stage passed
+type Approval struct {
+	ID          string         `json:"id"`
+	Stage       int            `json:"stage"`
+	Services    []string       `json:"services"`
+	Checks      *Response      `json:"checks"` // Responses of the stage after its Check
+	Status      ApprovalStatus `json:"status"`
+	Approver    string         `json:"approver,omitempty"`
+	Reason      string         `json:"reason,omitempty"`
+	RequestedAt time.Time      `json:"requested_at"`
+	DecidedAt   time.Time      `json:"decided_at,omitempty"`
+}
+
+// ApprovalStore persists Approval items, Save is expected to insert or overwrite by ID
+type ApprovalStore interface {
+	Save(approval Approval) error
+	List() ([]Approval, error)
+}
+
+var _ ApprovalStore = &MemoryApprovalStore{}
+var _ ApprovalStore = &FileApprovalStore{}
+
+// MemoryApprovalStore keeps approvals in memory, they are lost on restart
+type MemoryApprovalStore struct {
+	lock      sync.Mutex
+	approvals map[string]Approval
+}
+
+func (s *MemoryApprovalStore) Save(approval Approval) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.approvals == nil {
+		s.approvals = map[string]Approval{}
+	}
+	s.approvals[approval.ID] = approval
+	return nil
+}
+
+func (s *MemoryApprovalStore) List() ([]Approval, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	approvals := []Approval{}
+	for _, approval := range s.approvals {
+		approvals = append(approvals, approval)
+	}
+	sortApprovals(approvals)
+	return approvals, nil
+}
+
+// FileApprovalStore keeps all approvals in a single JSON file, which is rewritten atomically on every change
+type FileApprovalStore struct {
+	Path string
+
+	lock sync.Mutex
+}
+
+func (s *FileApprovalStore) Save(approval Approval) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	approvals := map[string]Approval{}
+	if err := readJSONFile(s.Path, &approvals); err != nil {
+		return err
+	}
+	approvals[approval.ID] = approval
+	return writeJSONFile(s.Path, approvals)
+}
+
+func (s *FileApprovalStore) List() ([]Approval, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	stored := map[string]Approval{}
+	if err := readJSONFile(s.Path, &stored); err != nil {
+		return nil, err
+	}
+	approvals := []Approval{}
+	for _, approval := range stored {
+		approvals = append(approvals, approval)
+	}
+	sortApprovals(approvals)
+	return approvals, nil
+}
+
+// ApprovalGate pauses CallStagedServices between the Check and Run of the stages in CallServicesOpts.ApprovalStages,
+// until an approver calls Approve or Reject (e.g. through the Handler). A rejection or timeout fails the stage, which
+// rolls back the stages that completed. The approvals are persisted in the Store, with the stage the orchestration
+// paused at. After a restart the paused orchestration is continued with Resume, approvals can be decided before or
+// after that.
+type ApprovalGate struct {
+	Store     ApprovalStore
+	Timeout   time.Duration  // Maximum time to wait for a decision, 0 waits until the context is done
+	OnRequest func(Approval) // Optional, e.g. to notify approvers
+
+	lock    sync.Mutex
+	waiting map[string]chan Approval
+}
+
+func NewApprovalGate(store ApprovalStore) *ApprovalGate {
+	return &ApprovalGate{Store: store, waiting: map[string]chan Approval{}}
+}
+
+// Pending returns the approvals that are not decided yet, including those of orchestrations that wait for a Resume
+func (g *ApprovalGate) Pending() ([]Approval, error) {
+	approvals, err := g.Store.List()
+	if err != nil {
+		return nil, err
+	}
+	pending := []Approval{}
+	for _, approval := range approvals {
+		if approval.Status == APPROVAL_PENDING {
+			pending = append(pending, approval)
+		}
+	}
+	return pending, nil
+}
+
+// Get returns the approval with id, regardless of its status
+func (g *ApprovalGate) Get(id string) (Approval, error) {
+	approvals, err := g.Store.List()
+	if err != nil {
+		return Approval{}, err
+	}
+	for _, approval := range approvals {
+		if approval.ID == id {
+			return approval, nil
+		}
+	}
+	return Approval{}, &statusError{error: errors.New("approval " + id + " not found"), status: http.StatusNotFound}
+}
+
+func (g *ApprovalGate) Approve(id, approver string) error {
+	return g.decide(id, APPROVAL_APPROVED, approver, "")
+}
+
+func (g *ApprovalGate) Reject(id, approver, reason string) error {
+	return g.decide(id, APPROVAL_REJECTED, approver, reason)
+}
+
+// Handler serves the approvals over HTTP, mount it using http.StripPrefix:
+//
+//	GET  /              lists pending approvals
+//	GET  /<id>          returns one approval
+//	POST /<id>/approve  with body {"approver": "..."}
+//	POST /<id>/reject   with body {"approver": "...", "reason": "..."}
+//
+// An unknown approval is replied with 404, a decision on an approval that is no longer pending with 409, and a
+// failure of the Store with 500.
+func (g *ApprovalGate) Handler() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		parts := strings.Split(strings.Trim(request.URL.Path, "/"), "/")
+		var result any
+		var err error
+
+		if request.Method == http.MethodGet && parts[0] == "" {
+			result, err = g.Pending()
+		} else if request.Method == http.MethodGet && len(parts) == 1 {
+			result, err = g.Get(parts[0])
+		} else if request.Method == http.MethodPost && len(parts) == 2 {
+			decision := struct {
+				Approver string `json:"approver"`
+				Reason   string `json:"reason"`
+			}{}
+			if err := json.NewDecoder(request.Body).Decode(&decision); err != nil || decision.Approver == "" {
+				writeJSON(writer, http.StatusBadRequest, &Response{Status: "an approver is required"})
+				return
+			}
+			if parts[1] == "approve" {
+				err = g.Approve(parts[0], decision.Approver)
+			} else if parts[1] == "reject" {
+				err = g.Reject(parts[0], decision.Approver, decision.Reason)
+			} else {
+				writeJSON(writer, http.StatusNotFound, &Response{Status: "not found"})
+				return
+			}
+			if err == nil {
+				result, err = g.Get(parts[0])
+			}
+		} else {
+			writeJSON(writer, http.StatusMethodNotAllowed, &Response{Status: "method not allowed"})
+			return
+		}
+
+		if err != nil {
+			status := http.StatusInternalServerError
+			var statusCoder StatusCoder
+			if errors.As(err, &statusCoder) {
+				status = statusCoder.StatusCode()
+			}
+			writeJSON(writer, status, &Response{Status: err.Error()})
+			return
+		}
+		writeJSON(writer, http.StatusOK, result)
+	})
+}
+
+func (g *ApprovalGate) decide(id string, status ApprovalStatus, approver, reason string) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	approval, err := g.Get(id)
+	if err != nil {
+		return err
+	}
+	if approval.Status != APPROVAL_PENDING {
+		return &ApprovalNotPendingError{ID: id, Status: approval.Status}
+	}
+
+	approval.Status = status
+	approval.Approver = approver
+	approval.Reason = reason
+	approval.DecidedAt = time.Now()
+	if err := g.Store.Save(approval); err != nil {
+		return err
+	}
+	if waiter, ok := g.waiting[id]; ok {
+		delete(g.waiting, id)
+		waiter <- approval // Buffered
+	} // Otherwise the decision is used by Resume
+	return nil
+}
+
+// Resume continues an orchestration that paused for the approval with id, e.g. after a restart. The stages must be
+// built the same way as for the original call, the stages before the approval are assumed to have completed and are
+// rolled back when the approval is rejected. The stage of the approval is checked again, and then waits for the
+// decision like the original call, or uses the decision that was made in the meantime. Outputs and PreviousStages of
+// the stages before the approval are not restored.
+func (g *ApprovalGate) Resume(ctx context.Context, id string, stages [][]Service, opts CallServicesOpts) (int, []error, error) {
+	approval, err := g.Get(id)
+	if err != nil {
+		return 0, nil, err
+	}
+	if approval.Status == APPROVAL_EXPIRED {
+		return 0, nil, &ApprovalNotPendingError{ID: id, Status: approval.Status} // The stages were rolled back
+	}
+	if approval.Stage >= len(stages) || !equalNames(approval.Services, Services(stages[approval.Stage]).GetNames()) {
+		return 0, nil, errors.New("approval " + id + " is not for these stages")
+	}
+	opts.Approvals = g
+	opts.ApprovalStages = append([]int{approval.Stage}, opts.ApprovalStages...)
+	opts.resumed = &approval
+	return CallStagedServices(ctx, stages, opts)
+}
+
+// await requests approval for a stage, and blocks until it is decided, timed out, cancelled or ctx is done. When
+// resumed is set, the approval of a paused orchestration is awaited instead of a new one.
+func (g *ApprovalGate) await(ctx context.Context, stage int, services []Service, checkErrs []error, cancelled <-chan struct{}, resumed *Approval) error {
+	_, checks := GenerateResponse(services, checkErrs, nil)
+	approval := Approval{
+		ID:          newID(),
+		Stage:       stage,
+		Services:    Services(services).GetNames(),
+		Checks:      checks,
+		Status:      APPROVAL_PENDING,
+		RequestedAt: time.Now(),
+	}
+	waiter := make(chan Approval, 1)
+
+	g.lock.Lock()
+	if resumed != nil {
+		// Reloaded while holding the lock, it may have been decided since Resume was called
+		stored, err := g.Get(resumed.ID)
+		if err != nil {
+			g.lock.Unlock()
+			return err
+		}
+		if _, waiting := g.waiting[stored.ID]; waiting || stored.Status == APPROVAL_EXPIRED {
+			g.lock.Unlock()
+			return &ApprovalNotPendingError{ID: stored.ID, Status: stored.Status}
+		}
+		if stored.Status != APPROVAL_PENDING {
+			g.lock.Unlock()
+			return decisionError(stored)
+		}
+		approval.ID, approval.RequestedAt = stored.ID, stored.RequestedAt
+	}
+	if g.waiting == nil {
+		g.waiting = map[string]chan Approval{}
+	}
+	g.waiting[approval.ID] = waiter
+	err := g.Store.Save(approval)
+	g.lock.Unlock()
+	if err != nil {
+		return g.expire(approval.ID, waiter, err)
+	}
+
+	redact.Logf("[ApprovalGate]: Stage %d is waiting for approval %s\n", stage, approval.ID)
+	if g.OnRequest != nil {
+		g.OnRequest(approval)
+	}
+
+	var timeout <-chan time.Time
+	if g.Timeout > 0 {
+		timer := time.NewTimer(g.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case decided := <-waiter:
+		return decisionError(decided)
+	case <-timeout:
+		return g.expire(approval.ID, waiter, ErrApprovalTimeout)
+	case <-cancelled:
+		return g.expire(approval.ID, waiter, ErrCancelled)
+	case <-ctx.Done():
+		return g.expire(approval.ID, waiter, ErrApprovalTimeout)
+	}
+}
+
+// expire marks a pending approval as expired, and returns err. A decision that raced with the expiry wins.
+func (g *ApprovalGate) expire(id string, waiter chan Approval, err error) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if _, ok := g.waiting[id]; !ok {
+		return decisionError(<-waiter) // decide sends while holding the lock, so the decision is there
+	}
+	delete(g.waiting, id)
+
+	if approval, getErr := g.Get(id); getErr == nil {
+		approval.Status = APPROVAL_EXPIRED
+		approval.DecidedAt = time.Now()
+		_ = g.Store.Save(approval)
+	}
+	return err
+}
+
+// decisionError returns nil for an approved Approval, otherwise the rejection
+func decisionError(approval Approval) error {
+	if approval.Status == APPROVAL_APPROVED {
+		return nil
+	}
+	if approval.Reason != "" {
+		return fmt.Errorf("%w: %s", ErrApprovalRejected, approval.Reason)
+	}
+	return ErrApprovalRejected
+}
+
+func (e *ApprovalNotPendingError) Error() string {
+	return "approval " + e.ID + " is " + strings.ToLower(string(e.Status))
+}
+
+func (e *ApprovalNotPendingError) Is(target error) bool {
+	return target == ErrApprovalNotPending
+}
+
+func (e *ApprovalNotPendingError) StatusCode() int {
+	return http.StatusConflict
+}
+
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (opts CallServicesOpts) requiresApproval(stage int) bool {
+	if opts.Approvals == nil {
+		return false
+	}
+	for _, gated := range opts.ApprovalStages {
+		if gated == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// firstStage returns the stage a call starts at, which is only not the first stage for a resumed call
+func (opts CallServicesOpts) firstStage() int {
+	if opts.resumed != nil {
+		return opts.resumed.Stage
+	}
+	return 0
+}
+
+// resumedAt returns the resumed approval when it is for stage
+func (opts CallServicesOpts) resumedAt(stage int) *Approval {
+	if opts.resumed != nil && opts.resumed.Stage == stage {
+		return opts.resumed
+	}
+	return nil
+}
+
+func sortApprovals(approvals []Approval) {
+	sort.Slice(approvals, func(i, j int) bool {
+		return approvals[i].RequestedAt.Before(approvals[j].RequestedAt)
+	})
+}
+
+func writeJSON(writer http.ResponseWriter, status int, v any) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	raw, _ := json.Marshal(v)
+	_, _ = writer.Write(append(raw, '\n'))
+}
@@ -0,0 +1,203 @@
+package orchestration_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration"
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration/orchestrationtest"
+)
+
+func approvalStages(recorder *orchestrationtest.Recorder) [][]orchestration.Service {
+	return [][]orchestration.Service{{recorder.Fake("a")}, {recorder.Fake("b")}}
+}
+
+func TestApprovalApproved(t *testing.T) {
+	recorder := orchestrationtest.NewRecorder()
+	gate := orchestration.NewApprovalGate(&orchestration.MemoryApprovalStore{})
+	gate.OnRequest = func(approval orchestration.Approval) {
+		if pending, _ := gate.Pending(); len(pending) != 1 || pending[0].ID != approval.ID {
+			t.Errorf("Expected the approval to be pending, got %v\n", pending)
+		}
+		go func() { _ = gate.Approve(approval.ID, "jane") }()
+	}
+
+	opts := orchestration.CallServicesOpts{Approvals: gate, ApprovalStages: []int{1}}
+	if nStagesRun, _, err := orchestration.CallStagedServices(context.TODO(), approvalStages(recorder), opts); nStagesRun != 2 || err != nil {
+		t.Fatalf("Expected both stages to run, got %d %v\n", nStagesRun, err)
+	}
+	approvals, _ := gate.Store.List()
+	if len(approvals) != 1 || approvals[0].Status != orchestration.APPROVAL_APPROVED || approvals[0].Approver != "jane" {
+		t.Errorf("Expected the approval to be approved by jane, got %v\n", approvals)
+	}
+	if err := gate.Reject(approvals[0].ID, "joe", ""); err == nil {
+		t.Errorf("Expected a second decision to fail\n")
+	}
+}
+
+func TestApprovalRejectedThroughHandler(t *testing.T) {
+	recorder := orchestrationtest.NewRecorder()
+	gate := orchestration.NewApprovalGate(&orchestration.MemoryApprovalStore{})
+	server := httptest.NewServer(gate.Handler())
+	defer server.Close()
+	gate.OnRequest = func(approval orchestration.Approval) {
+		go func() {
+			response, err := http.Post(server.URL+"/"+approval.ID+"/reject", "application/json", strings.NewReader(`{"approver":"jane","reason":"not today"}`))
+			if err != nil || response.StatusCode != http.StatusOK {
+				t.Errorf("Expected the rejection to succeed, got %v %v\n", response, err)
+			}
+		}()
+	}
+
+	opts := orchestration.CallServicesOpts{Approvals: gate, ApprovalStages: []int{1}}
+	_, _, err := orchestration.CallStagedServices(context.TODO(), approvalStages(recorder), opts)
+	orchestration.Wait()
+	if !errors.Is(err, orchestration.ErrApprovalRejected) || err.Error() != "approval rejected: not today" {
+		t.Errorf("Expected the rejection to fail the stage, got %v\n", err)
+	}
+	orchestrationtest.AssertRolledBack(t, recorder, "a")
+	orchestrationtest.AssertNotCalled(t, recorder, "b", orchestration.SERVICE_RUN)
+}
+
+func TestApprovalExpires(t *testing.T) {
+	recorder := orchestrationtest.NewRecorder()
+	gate := orchestration.NewApprovalGate(&orchestration.MemoryApprovalStore{})
+	gate.Timeout = 10 * time.Millisecond
+
+	opts := orchestration.CallServicesOpts{Approvals: gate, ApprovalStages: []int{1}}
+	_, _, err := orchestration.CallStagedServices(context.TODO(), approvalStages(recorder), opts)
+	orchestration.Wait()
+	if !errors.Is(err, orchestration.ErrApprovalTimeout) {
+		t.Fatalf("Expected ErrApprovalTimeout, got %v\n", err)
+	}
+	orchestrationtest.AssertRolledBack(t, recorder, "a")
+
+	approvals, _ := gate.Store.List()
+	if len(approvals) != 1 || approvals[0].Status != orchestration.APPROVAL_EXPIRED {
+		t.Fatalf("Expected the approval to expire, got %v\n", approvals)
+	}
+	if err := gate.Approve(approvals[0].ID, "jane"); err == nil {
+		t.Errorf("Expected an expired approval not to be approved\n")
+	}
+}
+
+func TestApprovalCancelled(t *testing.T) {
+	recorder := orchestrationtest.NewRecorder()
+	gate := orchestration.NewApprovalGate(&orchestration.MemoryApprovalStore{})
+	requested := make(chan struct{})
+	gate.OnRequest = func(_ orchestration.Approval) { close(requested) }
+
+	opts := orchestration.CallServicesOpts{Approvals: gate, ApprovalStages: []int{1}}
+	call := orchestration.CallStagedServicesAsync(context.TODO(), approvalStages(recorder), opts)
+	<-requested
+	call.Cancel()
+	if _, _, err := call.Wait(); !errors.Is(err, orchestration.ErrCancelled) {
+		t.Errorf("Expected ErrCancelled, got %v\n", err)
+	}
+	orchestrationtest.AssertRolledBack(t, recorder, "a")
+	if pending, _ := gate.Pending(); len(pending) != 0 {
+		t.Errorf("Expected no pending approvals after the cancel, got %v\n", pending)
+	}
+}
+
+func TestApprovalWithoutGate(t *testing.T) {
+	recorder := orchestrationtest.NewRecorder()
+	opts := orchestration.CallServicesOpts{ApprovalStages: []int{1}}
+	if _, _, err := orchestration.CallStagedServices(context.TODO(), approvalStages(recorder), opts); !errors.Is(err, orchestration.ErrNoApprovalGate) {
+		t.Errorf("Expected ErrNoApprovalGate, got %v\n", err)
+	}
+	orchestrationtest.AssertActions(t, recorder, "a")
+}
+
+// Struct definition required to satisfy the ApprovalStore interface, of which every action fails
+type BrokenApprovalStore struct{}
+
+func (s *BrokenApprovalStore) Save(_ orchestration.Approval) error { return errors.New("disk full") }
+
+func (s *BrokenApprovalStore) List() ([]orchestration.Approval, error) {
+	return nil, errors.New("disk full")
+}
+
+func TestApprovalHandler(t *testing.T) {
+	store := &orchestration.MemoryApprovalStore{}
+	_ = store.Save(orchestration.Approval{ID: "decided", Status: orchestration.APPROVAL_APPROVED})
+	gate := orchestration.NewApprovalGate(store)
+	server := httptest.NewServer(gate.Handler())
+	defer server.Close()
+	broken := httptest.NewServer(orchestration.NewApprovalGate(&BrokenApprovalStore{}).Handler())
+	defer broken.Close()
+
+	for _, test := range []struct {
+		url, method, path, body string
+		status                  int
+	}{
+		{server.URL, http.MethodGet, "/", "", http.StatusOK},
+		{server.URL, http.MethodGet, "/unknown", "", http.StatusNotFound},
+		{server.URL, http.MethodPost, "/unknown/approve", `{"approver":"jane"}`, http.StatusNotFound},
+		{server.URL, http.MethodPost, "/unknown/approve", `{}`, http.StatusBadRequest},
+		{server.URL, http.MethodPost, "/decided/reject", `{"approver":"jane"}`, http.StatusConflict},
+		{server.URL, http.MethodDelete, "/unknown", "", http.StatusMethodNotAllowed},
+		{broken.URL, http.MethodGet, "/", "", http.StatusInternalServerError},
+		{broken.URL, http.MethodPost, "/unknown/approve", `{"approver":"jane"}`, http.StatusInternalServerError},
+	} {
+		request, _ := http.NewRequest(test.method, test.url+test.path, strings.NewReader(test.body))
+		response, err := http.DefaultClient.Do(request)
+		if err != nil || response.StatusCode != test.status {
+			t.Errorf("Expected %d for %s %s, got %v %v\n", test.status, test.method, test.path, response, err)
+		}
+	}
+}
+
+// pausedApproval returns a store with a pending approval of the second approvalStages, like after a restart
+func pausedApproval() *orchestration.MemoryApprovalStore {
+	store := &orchestration.MemoryApprovalStore{}
+	_ = store.Save(orchestration.Approval{ID: "paused", Stage: 1, Services: []string{`"b"`}, Status: orchestration.APPROVAL_PENDING})
+	return store
+}
+
+func TestApprovalResume(t *testing.T) {
+	recorder := orchestrationtest.NewRecorder()
+	gate := orchestration.NewApprovalGate(pausedApproval())
+	if pending, _ := gate.Pending(); len(pending) != 1 {
+		t.Fatalf("Expected the paused approval to be pending, got %v\n", pending)
+	}
+	gate.OnRequest = func(approval orchestration.Approval) {
+		go func() { _ = gate.Approve(approval.ID, "jane") }()
+	}
+
+	if nStagesRun, _, err := gate.Resume(context.TODO(), "paused", approvalStages(recorder), orchestration.CallServicesOpts{}); nStagesRun != 2 || err != nil {
+		t.Fatalf("Expected the resumed call to complete, got %d %v\n", nStagesRun, err)
+	}
+	orchestrationtest.AssertActions(t, recorder, "a")
+	orchestrationtest.AssertActions(t, recorder, "b", orchestration.SERVICE_CHECK, orchestration.SERVICE_RUN)
+}
+
+func TestApprovalDecidedBeforeResume(t *testing.T) {
+	recorder := orchestrationtest.NewRecorder()
+	gate := orchestration.NewApprovalGate(pausedApproval())
+	if err := gate.Reject("paused", "jane", "not today"); err != nil {
+		t.Fatalf("Expected a paused approval to be rejected, got %v\n", err)
+	}
+
+	_, _, err := gate.Resume(context.TODO(), "paused", approvalStages(recorder), orchestration.CallServicesOpts{})
+	orchestration.Wait()
+	if !errors.Is(err, orchestration.ErrApprovalRejected) {
+		t.Errorf("Expected the rejection to fail the resumed stage, got %v\n", err)
+	}
+	orchestrationtest.AssertActions(t, recorder, "a", orchestration.SERVICE_ROLLBACK)
+	orchestrationtest.AssertNotCalled(t, recorder, "b", orchestration.SERVICE_RUN)
+
+	if _, _, err := gate.Resume(context.TODO(), "paused", [][]orchestration.Service{{recorder.Fake("c")}}, orchestration.CallServicesOpts{}); err == nil {
+		t.Errorf("Expected an approval not to be resumed with other stages\n")
+	}
+	store := pausedApproval()
+	_ = store.Save(orchestration.Approval{ID: "paused", Stage: 1, Services: []string{`"b"`}, Status: orchestration.APPROVAL_EXPIRED})
+	if _, _, err := orchestration.NewApprovalGate(store).Resume(context.TODO(), "paused", approvalStages(recorder), orchestration.CallServicesOpts{}); !errors.Is(err, orchestration.ErrApprovalNotPending) {
+		t.Errorf("Expected an expired approval not to be resumed, got %v\n", err)
+	}
+}
@@ -0,0 +1,227 @@
+// Package audit keeps an append-only log of all orchestration actions: who triggered them, with which payloads, and
+// the outcome of every Service, including rollbacks. Records are written as JSON Lines to a Sink, by default a
+// RotatingFileSink. Every record contains the hash of the previous one, so removing or changing a record breaks the
+// chain, see VerifyChain.
+//
+// Take the following example:
+//
+//	auditor, err := audit.NewAuditor(&audit.RotatingFileSink{Path: "/var/log/orchestration/audit.jsonl"})
+//	orchestration.ActionReporter = auditor.Reporter()
+//	defer auditor.Flush() // After orchestration.Shutdown, which waits for the last rollbacks to be audited
+//
+//	func handler(writer http.ResponseWriter, request *http.Request) {
+//	    ctx := audit.WithActor(request.Context(), request.Header.Get("X-User"))
+//	    ctx = audit.WithRequestID(ctx, request.Header.Get("X-Request-Id"))
+//	    status, response := orchestration.CallServicesAndReply(ctx, services, orchestration.CallServicesOpts{})
+//	    ...
+//	}
+package audit
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration"
+	"github.com/ing-bank/orchestration-pkg/pkg/redact"
+)
+
+// Record is a single action on one or more Services
+type Record struct {
+	Seq        uint64                      `json:"seq"`
+	Time       time.Time                   `json:"time"` // Start of the action
+	Actor      string                      `json:"actor,omitempty"`
+	RequestID  string                      `json:"requestId,omitempty"`
+	Action     orchestration.ServiceAction `json:"action"`
+	DurationMs int64                       `json:"durationMs"`
+	Services   []ServiceRecord             `json:"services"`
+	PrevHash   string                      `json:"prevHash"`
+	Hash       string                      `json:"hash"` // SHA-256 of the record with an empty Hash, in hex
+}
+
+type ServiceRecord struct {
+	Name    string          `json:"name"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// Payloader can be implemented by a Service to include its payload in the audit log. The RequestPayload of the REST
+// API Services is included without it, and so is the Patch of a PATCH.
+type Payloader interface {
+	AuditPayload() any
+}
+
+// Auditor writes Records to a Sink, it is safe for concurrent use
+type Auditor struct {
+	Sink Sink
+
+	lock     sync.Mutex
+	seq      uint64
+	lastHash string
+}
+
+// NewAuditor returns an Auditor that continues the chain of the last record in sink, when sink is a ResumableSink
+func NewAuditor(sink Sink) (*Auditor, error) {
+	auditor := &Auditor{Sink: sink}
+	resumable, ok := sink.(ResumableSink)
+	if !ok {
+		return auditor, nil
+	}
+	line, err := resumable.LastLine()
+	if err != nil || len(line) == 0 {
+		return auditor, err
+	}
+	last := Record{}
+	if err := json.Unmarshal(line, &last); err != nil {
+		return nil, errors.New("unable to resume the audit chain: " + err.Error())
+	}
+	auditor.seq, auditor.lastHash = last.Seq, last.Hash
+	return auditor, nil
+}
+
+// WithActor stores who triggered the orchestration in ctx
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, "actor", actor)
+}
+
+// WithRequestID stores the ID of the request that triggered the orchestration in ctx
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, "requestId", requestID)
+}
+
+// Reporter returns an orchestration.ActionReporter that audits every action. Failures to write are logged, they do
+// not fail the orchestration.
+func (a *Auditor) Reporter() func(context.Context, []orchestration.Service, orchestration.ServiceAction, []error, time.Time) {
+	return func(ctx context.Context, services []orchestration.Service, action orchestration.ServiceAction, errs []error, start time.Time) {
+		if err := a.Audit(ctx, services, action, errs, start); err != nil {
+			redact.Logf("[Audit]: Unable to write record for %s: %v\n", action, err)
+		}
+	}
+}
+
+// Audit writes a Record for action on services
+func (a *Auditor) Audit(ctx context.Context, services []orchestration.Service, action orchestration.ServiceAction, errs []error, start time.Time) error {
+	record := Record{
+		Time:       start.UTC(),
+		Action:     action,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	record.Actor, _ = ctx.Value("actor").(string)
+	record.RequestID, _ = ctx.Value("requestId").(string)
+	for i, service := range services {
+		serviceRecord := ServiceRecord{Name: service.Name(), Payload: payloadOf(service)}
+		if i < len(errs) && errs[i] != nil {
+			serviceRecord.Error = redact.String(errs[i].Error())
+		}
+		record.Services = append(record.Services, serviceRecord)
+	}
+	return a.write(record)
+}
+
+// write chains and writes record, the lock keeps the sequence and the chain in the order of the Sink
+func (a *Auditor) write(record Record) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	record.Seq = a.seq + 1
+	record.PrevHash = a.lastHash
+	line, err := seal(&record)
+	if err != nil {
+		return err
+	}
+	if err := a.Sink.Write(line); err != nil {
+		return err
+	}
+	a.seq, a.lastHash = record.Seq, record.Hash
+	return nil
+}
+
+// Flush writes out the records buffered by the Sink, when it is a Flusher
+func (a *Auditor) Flush() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if flusher, ok := a.Sink.(Flusher); ok {
+		return flusher.Flush()
+	}
+	return nil
+}
+
+// VerifyChain reads JSON Lines records from reader, and checks their hashes and that every record points to the
+// previous one. prevHash is the Hash of the record before the first one, empty to accept any first record (e.g.
+// after older files were rotated away). It returns the Hash of the last record.
+func VerifyChain(reader io.Reader, prevHash string) (string, error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	first := true
+	for line := 1; scanner.Scan(); line++ {
+		record := Record{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return "", fmt.Errorf("line %d: %v", line, err)
+		}
+		if !(first && prevHash == "") && record.PrevHash != prevHash {
+			return "", fmt.Errorf("line %d (seq %d): chain broken, previous hash does not match", line, record.Seq)
+		}
+		hash := record.Hash
+		if _, err := seal(&record); err != nil {
+			return "", fmt.Errorf("line %d: %v", line, err)
+		}
+		if record.Hash != hash {
+			return "", fmt.Errorf("line %d (seq %d): record was modified, hash does not match", line, record.Seq)
+		}
+		prevHash, first = hash, false
+	}
+	return prevHash, scanner.Err()
+}
+
+// seal sets the Hash of record, and returns it as a JSON line
+func seal(record *Record) ([]byte, error) {
+	record.Hash = ""
+	unsealed, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(unsealed)
+	record.Hash = hex.EncodeToString(sum[:])
+	line, err := json.Marshal(record)
+	return append(line, '\n'), err
+}
+
+func payloadOf(service orchestration.Service) json.RawMessage {
+	var payload any
+	switch typed := service.(type) {
+	case Payloader:
+		payload = typed.AuditPayload()
+	case *orchestration.RestApiService:
+		payload = requestOf(&typed.SimpleRestApiService)
+	case *orchestration.SimpleRestApiService:
+		payload = requestOf(typed)
+	}
+	if payload == nil {
+		return nil
+	}
+	raw, err := json.Marshal(redact.Value(payload))
+	if err != nil {
+		raw, _ = json.Marshal("unable to marshal payload: " + err.Error())
+	}
+	return raw
+}
+
+// requestOf returns the payload of a REST API Service, which is the patch for a PATCH
+func requestOf(service *orchestration.SimpleRestApiService) any {
+	if service.Action != orchestration.REST_API_PATCH || len(service.Patch) == 0 {
+		return service.RequestPayload
+	}
+	// A patch has no secret tags, so it is redacted as text. That can break the JSON, e.g. a number after "password".
+	redacted := redact.String(string(service.Patch))
+	if json.Valid([]byte(redacted)) {
+		return json.RawMessage(redacted)
+	}
+	return redacted
+}
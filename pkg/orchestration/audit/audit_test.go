@@ -0,0 +1,134 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration"
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration/orchestrationtest"
+)
+
+func TestAuditRunAndRollback(t *testing.T) {
+	sink := &MemorySink{}
+	auditor, _ := NewAuditor(sink)
+	orchestration.ActionReporter = auditor.Reporter()
+	defer func() { orchestration.ActionReporter = nil }()
+
+	recorder := orchestrationtest.NewRecorder()
+	services := []orchestration.Service{recorder.Fake("a"), recorder.Fake("b").FailOn(orchestration.SERVICE_RUN, errors.New("boom"))}
+	ctx := WithRequestID(WithActor(context.TODO(), "alice"), "request-1")
+	_, _ = orchestration.CallServices(ctx, services, orchestration.CallServicesOpts{})
+	orchestration.Wait()
+
+	var actions []orchestration.ServiceAction
+	for _, line := range bytes.SplitAfter(bytes.TrimSpace(sink.Bytes()), []byte("\n")) {
+		record := Record{}
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("Expected JSON lines, got %v\n", err)
+		}
+		if record.Actor != "alice" || record.RequestID != "request-1" || len(record.Services) != 2 {
+			t.Errorf("Expected actor, request ID and both services in %+v\n", record)
+		}
+		if record.Action == orchestration.SERVICE_RUN && record.Services[1].Error != "boom" {
+			t.Errorf("Expected the run error of \"b\", got %+v\n", record.Services)
+		}
+		actions = append(actions, record.Action)
+	}
+	if len(actions) != 3 || actions[2] != orchestration.SERVICE_ROLLBACK {
+		t.Errorf("Expected CHECK, RUN and ROLLBACK records, got %v\n", actions)
+	}
+	if _, err := VerifyChain(bytes.NewReader(sink.Bytes()), ""); err != nil {
+		t.Errorf("Expected a valid chain, got %v\n", err)
+	}
+
+	tampered := bytes.Replace(sink.Bytes(), []byte("alice"), []byte("mallory"), 1)
+	if _, err := VerifyChain(bytes.NewReader(tampered), ""); err == nil {
+		t.Errorf("Expected a tampered record to break the chain\n")
+	}
+}
+
+func TestRotatingFileSinkContinuesChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	services := []orchestration.Service{orchestrationtest.NewRecorder().Fake("a")}
+
+	for i := 0; i < 2; i++ { // The second auditor resumes the chain, like after a restart
+		sink := &RotatingFileSink{Path: path, MaxBytes: 400}
+		auditor, err := NewAuditor(sink)
+		if err != nil {
+			t.Fatalf("Expected to resume the chain, got %v\n", err)
+		}
+		for j := 0; j < 3; j++ {
+			if err := auditor.Audit(context.TODO(), services, orchestration.SERVICE_RUN, nil, time.Now()); err != nil {
+				t.Fatalf("Expected no write errors, got %v\n", err)
+			}
+		}
+		_ = sink.Close()
+	}
+
+	var chain []byte
+	for _, file := range []string{path + ".5", path + ".4", path + ".3", path + ".2", path + ".1", path} {
+		raw, _ := os.ReadFile(file) // Oldest first
+		chain = append(chain, raw...)
+	}
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Errorf("Expected the file to be rotated, got %v\n", err)
+	}
+	if _, err := VerifyChain(bytes.NewReader(chain), ""); err != nil {
+		t.Errorf("Expected a valid chain over all files, got %v\n", err)
+	}
+	if count := bytes.Count(chain, []byte("\n")); count != 6 {
+		t.Errorf("Expected 6 records, got %d\n", count)
+	}
+}
+
+func TestAuditIncludesRedactedPatches(t *testing.T) {
+	sink := &MemorySink{}
+	auditor, _ := NewAuditor(sink)
+	patch := orchestration.RestApiPatchAsService(nil, "claims", "claim-1", orchestration.PATCH_MERGE, []byte(`{"memory_in_mb": 200, "password": "hunter2"}`))
+	_ = auditor.Audit(context.TODO(), []orchestration.Service{patch}, orchestration.SERVICE_RUN, nil, time.Now())
+
+	record := Record{}
+	if err := json.Unmarshal(sink.Bytes(), &record); err != nil {
+		t.Fatalf("Expected a JSON record, got %v\n", err)
+	}
+	payload := string(record.Services[0].Payload)
+	if !strings.Contains(payload, `"memory_in_mb":200`) || strings.Contains(payload, "hunter2") {
+		t.Errorf("Expected the redacted patch as payload, got %s\n", payload)
+	}
+}
+
+func TestRotatingFileSinkBuffersUntilFlush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink := &RotatingFileSink{Path: path, FlushInterval: time.Hour}
+	auditor, _ := NewAuditor(sink)
+	services := []orchestration.Service{orchestrationtest.NewRecorder().Fake("a")}
+	_ = auditor.Audit(context.TODO(), services, orchestration.SERVICE_RUN, nil, time.Now())
+
+	if raw, _ := os.ReadFile(path); len(raw) != 0 {
+		t.Errorf("Expected the record to be buffered, got %s\n", raw)
+	}
+	if err := auditor.Flush(); err != nil {
+		t.Fatalf("Expected no flush errors, got %v\n", err)
+	}
+	if raw, _ := os.ReadFile(path); bytes.Count(raw, []byte("\n")) != 1 {
+		t.Errorf("Expected the record to be written by Flush, got %s\n", raw)
+	}
+
+	sink.FlushInterval = 10 * time.Millisecond
+	_ = auditor.Audit(context.TODO(), services, orchestration.SERVICE_ROLLBACK, nil, time.Now())
+	deadline := time.Now().Add(5 * time.Second)
+	for raw, _ := os.ReadFile(path); bytes.Count(raw, []byte("\n")) != 2; raw, _ = os.ReadFile(path) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the record to be written after the FlushInterval, got %s\n", raw)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	_ = sink.Close()
+}
@@ -0,0 +1,244 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/redact"
+)
+
+// Sink stores audit records, one JSON line per Write. The Auditor serializes the calls to Write.
+type Sink interface {
+	Write(line []byte) error
+}
+
+// ResumableSink is a Sink that can return its last line, so a new Auditor continues the hash chain after a restart
+type ResumableSink interface {
+	Sink
+	LastLine() ([]byte, error)
+}
+
+// Flusher is a Sink that buffers records, Flush writes them out. See Auditor.Flush.
+type Flusher interface {
+	Flush() error
+}
+
+var _ ResumableSink = &RotatingFileSink{}
+var _ Flusher = &RotatingFileSink{}
+var _ ResumableSink = &MemorySink{}
+
+// WriterSink writes records to an io.Writer, e.g. os.Stdout
+type WriterSink struct {
+	Writer io.Writer
+}
+
+func (w *WriterSink) Write(line []byte) error {
+	_, err := w.Writer.Write(line)
+	return err
+}
+
+// MemorySink keeps all records in memory, mostly useful for tests
+type MemorySink struct {
+	lock  sync.Mutex
+	lines [][]byte
+}
+
+func (m *MemorySink) Write(line []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.lines = append(m.lines, append([]byte{}, line...))
+	return nil
+}
+
+func (m *MemorySink) LastLine() ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if len(m.lines) == 0 {
+		return nil, nil
+	}
+	return bytes.TrimSpace(m.lines[len(m.lines)-1]), nil
+}
+
+// Bytes returns all records as JSON Lines
+func (m *MemorySink) Bytes() []byte {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return bytes.Join(m.lines, nil)
+}
+
+// RotatingFileSink appends records to the file at Path. When the file exceeds MaxBytes it is renamed to Path.1, the
+// existing Path.1 to Path.2 and so on, and the oldest file beyond MaxFiles is removed. The hash chain continues over
+// the files, verify them oldest first.
+//
+// Records are buffered, so a Write does not wait for the disk during an orchestration. They are written and synced
+// at most FlushInterval later, and by Flush and Close. Call Auditor.Flush after orchestration.Shutdown, since the
+// records of the last FlushInterval are lost when the process exits before that.
+type RotatingFileSink struct {
+	Path          string
+	MaxBytes      int64         // Default 10MB
+	MaxFiles      int           // Number of rotated files to keep, default 5
+	FlushInterval time.Duration // Maximum time a record stays in the buffer, default one second
+
+	lock   sync.Mutex // Guards the fields below, the timed flush runs concurrently with Write
+	file   *os.File
+	buffer *bufio.Writer
+	size   int64
+	timer  *time.Timer // Pending timed flush, nil when nothing is buffered
+}
+
+func (r *RotatingFileSink) Write(line []byte) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.file == nil {
+		if err := r.open(); err != nil {
+			return err
+		}
+	}
+	if r.size > 0 && r.size+int64(len(line)) > r.maxBytes() {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := r.buffer.Write(line)
+	r.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if r.timer == nil {
+		r.timer = time.AfterFunc(r.flushInterval(), func() {
+			if err := r.Flush(); err != nil {
+				redact.Logf("[Audit]: Unable to flush %s: %v\n", r.Path, err)
+			}
+		})
+	}
+	return nil
+}
+
+// Flush writes the buffered records to Path, and syncs it
+func (r *RotatingFileSink) Flush() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.flush()
+}
+
+// LastLine returns the last record in Path, or in Path.1 when Path was just rotated
+func (r *RotatingFileSink) LastLine() ([]byte, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err := r.flush(); err != nil {
+		return nil, err
+	}
+	for _, path := range []string{r.Path, r.rotated(1)} {
+		raw, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		raw = bytes.TrimSpace(raw)
+		if len(raw) == 0 {
+			continue
+		}
+		return raw[bytes.LastIndexByte(raw, '\n')+1:], nil
+	}
+	return nil, nil
+}
+
+// Close flushes and closes the current file, a later Write opens it again
+func (r *RotatingFileSink) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.close()
+}
+
+// flush writes and syncs the buffer, the lock must be held
+func (r *RotatingFileSink) flush() error {
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	if r.file == nil {
+		return nil
+	}
+	if err := r.buffer.Flush(); err != nil {
+		return err
+	}
+	return r.file.Sync()
+}
+
+// close flushes and closes the current file, the lock must be held
+func (r *RotatingFileSink) close() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.flush()
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	r.file, r.buffer = nil, nil
+	return err
+}
+
+func (r *RotatingFileSink) open() error {
+	file, err := os.OpenFile(r.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	r.file, r.buffer, r.size = file, bufio.NewWriter(file), info.Size()
+	return nil
+}
+
+func (r *RotatingFileSink) rotate() error {
+	if err := r.close(); err != nil {
+		return err
+	}
+	if err := os.Remove(r.rotated(r.maxFiles())); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for i := r.maxFiles() - 1; i >= 1; i-- {
+		if err := os.Rename(r.rotated(i), r.rotated(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(r.Path, r.rotated(1)); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *RotatingFileSink) rotated(i int) string {
+	return fmt.Sprintf("%s.%d", r.Path, i)
+}
+
+func (r *RotatingFileSink) maxBytes() int64 {
+	if r.MaxBytes > 0 {
+		return r.MaxBytes
+	}
+	return 10 * 1024 * 1024
+}
+
+func (r *RotatingFileSink) flushInterval() time.Duration {
+	if r.FlushInterval > 0 {
+		return r.FlushInterval
+	}
+	return time.Second
+}
+
+func (r *RotatingFileSink) maxFiles() int {
+	if r.MaxFiles > 0 {
+		return r.MaxFiles
+	}
+	return 5
+}
@@ -0,0 +1,214 @@
+// Package chaos injects faults into Services and RestApis, to exercise the Rollback and Recover paths of an
+// orchestration in staging before a real incident does. Faults are described by Rules, and an Injector decides per
+// action whether a Rule applies, by probability or always. Injection can be switched at runtime with SetConfig, or
+// per call with WithChaos.
+//
+// Take the following example:
+//
+//	injector := chaos.NewInjector(chaos.Config{
+//	    Enabled: os.Getenv("ENVIRONMENT") == "staging",
+//	    Seed:    42, // Same decisions for the same sequence of actions
+//	    Rules: []chaos.Rule{
+//	        {Service: "Claim DC1*", Action: "RUN", Fault: chaos.FAULT_ERROR, Probability: 0.1},
+//	        {Service: "Claim DC2*", Action: "ROLLBACK", Fault: chaos.FAULT_LATENCY, Latency: 5 * time.Second},
+//	    },
+//	})
+//	service := chaos.Wrap(orchestration.RestApiAsService(chaos.WrapApi(api, "memory", injector), ...), injector)
+package chaos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/redact"
+)
+
+// ErrInjected is wrapped by all injected errors, use errors.Is to tell them apart from real errors
+var ErrInjected = errors.New("chaos: injected fault")
+
+type Fault string
+
+const (
+	FAULT_ERROR   Fault = "ERROR"   // Return an error instead of calling the action
+	FAULT_LATENCY Fault = "LATENCY" // Wait Latency (or until the context is done) before calling the action
+	FAULT_PANIC   Fault = "PANIC"   // Panic instead of calling the action
+	FAULT_HANG    Fault = "HANG"    // Wait until the context is done instead of calling the action
+)
+
+// Rule describes a fault and the actions it applies to. The first matching Rule of which the Probability hits is
+// injected, the other Rules are ignored for that action.
+type Rule struct {
+	Service     string        `json:"service"`     // Pattern of the Service or RestApi name (see path.Match), empty matches all
+	Action      string        `json:"action"`      // A ServiceAction (e.g. "RUN") or RestApiAction (e.g. "POST"), empty matches all
+	Fault       Fault         `json:"fault"`       // Required
+	Probability float64       `json:"probability"` // Chance between 0 and 1 that the fault is injected, zero means always
+	Latency     time.Duration `json:"latency"`     // Used by FAULT_LATENCY, a string like "5s" in JSON
+	Message     string        `json:"message"`     // Optional message of FAULT_ERROR and FAULT_PANIC
+	Times       int           `json:"times"`       // Maximum number of injections, zero means unlimited
+}
+
+// jsonRule is a Rule with the Latency as a string
+type jsonRule struct {
+	ruleFields
+	Latency string `json:"latency,omitempty"`
+}
+
+// ruleFields has the fields of a Rule without its methods, so they are not called recursively
+type ruleFields Rule
+
+func (r Rule) MarshalJSON() ([]byte, error) {
+	rule := jsonRule{ruleFields: ruleFields(r)}
+	if r.Latency != 0 {
+		rule.Latency = r.Latency.String()
+	}
+	return json.Marshal(rule)
+}
+
+func (r *Rule) UnmarshalJSON(raw []byte) error {
+	rule := jsonRule{}
+	if err := json.Unmarshal(raw, &rule); err != nil {
+		return err
+	}
+	*r = Rule(rule.ruleFields)
+	if rule.Latency != "" {
+		latency, err := time.ParseDuration(rule.Latency)
+		if err != nil {
+			return errors.New("latency must be a string like \"5s\": " + err.Error())
+		}
+		r.Latency = latency
+	}
+	return nil
+}
+
+type Config struct {
+	Enabled bool   `json:"enabled"` // Can be overruled per call with WithChaos
+	Seed    int64  `json:"seed"`    // Makes the probabilities deterministic, zero uses the current time
+	Rules   []Rule `json:"rules"`
+}
+
+// Injector decides which faults are injected, and injects them. It is safe for concurrent use, but note that with a
+// Seed the decisions only repeat when the actions are called in the same order.
+type Injector struct {
+	lock     sync.Mutex
+	config   Config
+	random   *rand.Rand
+	injected []int // Number of injections per Rule
+}
+
+func NewInjector(config Config) *Injector {
+	injector := &Injector{}
+	injector.SetConfig(config)
+	return injector
+}
+
+// LoadConfig reads a JSON Config from path
+func LoadConfig(path string) (Config, error) {
+	config := Config{}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	err = json.Unmarshal(raw, &config)
+	return config, err
+}
+
+// SetConfig replaces the Config at runtime, which also resets the random source and the Times counters
+func (i *Injector) SetConfig(config Config) {
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.config = config
+	i.random = rand.New(rand.NewSource(seed))
+	i.injected = make([]int, len(config.Rules))
+}
+
+func (i *Injector) Config() Config {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return i.config
+}
+
+// WithChaos enables or disables injection for all calls with ctx, regardless of Config.Enabled
+func WithChaos(ctx context.Context, enabled bool) context.Context {
+	return context.WithValue(ctx, "chaos", enabled)
+}
+
+// Inject injects the fault of the first matching Rule into action of name, if any. It returns an error for
+// FAULT_ERROR and FAULT_HANG, panics for FAULT_PANIC, and returns nil after the delay of FAULT_LATENCY or when no
+// fault is injected. In that last case the caller should perform the action as usual.
+func (i *Injector) Inject(ctx context.Context, name, action string) error {
+	rule, ok := i.decide(ctx, name, action)
+	if !ok {
+		return nil
+	}
+	redact.Logf("[Chaos]: Injecting %s into %s of \"%s\"\n", rule.Fault, action, name)
+
+	message := rule.Message
+	if message == "" {
+		message = string(rule.Fault) + " in " + action + " of " + name
+	}
+	switch rule.Fault {
+	case FAULT_LATENCY:
+		select {
+		case <-time.After(rule.Latency):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	case FAULT_PANIC:
+		panic(fmt.Errorf("%w: %s", ErrInjected, message))
+	case FAULT_HANG:
+		<-ctx.Done()
+		return ctx.Err()
+	default:
+		return fmt.Errorf("%w: %s", ErrInjected, message)
+	}
+}
+
+// decide returns the Rule to inject into action of name
+func (i *Injector) decide(ctx context.Context, name, action string) (Rule, bool) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	enabled := i.config.Enabled
+	if value, ok := ctx.Value("chaos").(bool); ok {
+		enabled = value
+	}
+	if !enabled {
+		return Rule{}, false
+	}
+
+	for j, rule := range i.config.Rules {
+		if !rule.matches(name, action) || (rule.Times > 0 && i.injected[j] >= rule.Times) {
+			continue
+		}
+		if rule.Probability > 0 && i.random.Float64() >= rule.Probability {
+			continue
+		}
+		i.injected[j]++
+		return rule, true
+	}
+	return Rule{}, false
+}
+
+func (r Rule) matches(name, action string) bool {
+	if r.Action != "" && r.Action != action {
+		return false
+	}
+	if r.Service == "" {
+		return true
+	}
+	matched, err := path.Match(r.Service, name)
+	return err == nil && matched
+}
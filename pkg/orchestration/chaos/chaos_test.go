@@ -0,0 +1,138 @@
+package chaos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration"
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration/orchestrationtest"
+)
+
+func TestInjectedRunErrorRollsBack(t *testing.T) {
+	injector := NewInjector(Config{Enabled: true, Rules: []Rule{{Service: "b*", Action: "RUN", Fault: FAULT_ERROR}}})
+	recorder := orchestrationtest.NewRecorder()
+	services := []orchestration.Service{Wrap(recorder.Fake("a"), injector), Wrap(recorder.Fake("b1"), injector)}
+
+	errs, err := orchestration.CallServices(context.TODO(), services, orchestration.CallServicesOpts{})
+	orchestration.Wait()
+
+	if !errors.Is(err, orchestration.ErrRunFailed) || !errors.Is(errs[1], ErrInjected) {
+		t.Errorf("Expected an injected run failure of \"b1\", got %v and %v\n", errs, err)
+	}
+	orchestrationtest.AssertActions(t, recorder, "b1", orchestration.SERVICE_CHECK, orchestration.SERVICE_ROLLBACK)
+	orchestrationtest.AssertRolledBack(t, recorder, "a")
+
+	recorder.Reset()
+	if _, err := orchestration.CallServices(WithChaos(context.TODO(), false), services, orchestration.CallServicesOpts{}); err != nil {
+		t.Errorf("Expected no injection when disabled by the context, got %v\n", err)
+	}
+}
+
+func TestSeedIsDeterministic(t *testing.T) {
+	config := Config{Enabled: true, Seed: 42, Rules: []Rule{{Fault: FAULT_ERROR, Probability: 0.5, Times: 10}}}
+	decisions := func() []bool {
+		injector := NewInjector(config)
+		var injected []bool
+		for i := 0; i < 40; i++ {
+			injected = append(injected, injector.Inject(context.TODO(), "a", "RUN") != nil)
+		}
+		return injected
+	}
+
+	first, second := decisions(), decisions()
+	count := 0
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("Expected the same decisions with the same seed, got %v and %v\n", first, second)
+		}
+		if first[i] {
+			count++
+		}
+	}
+	if count != 10 {
+		t.Errorf("Expected exactly 10 injections because of Times, got %d\n", count)
+	}
+}
+
+// Struct definition required to satisfy the RestApi interface, without Patcher or ConditionalRestApi
+type PlainApi struct{}
+
+func (a *PlainApi) Get(_ context.Context, _ string) (orchestration.Nameable, error) { return nil, nil }
+
+func (a *PlainApi) Post(_ context.Context, _ orchestration.Nameable) (interface{}, error) {
+	return nil, nil
+}
+
+func (a *PlainApi) Put(_ context.Context, _ orchestration.Nameable) (interface{}, error) {
+	return nil, nil
+}
+
+func (a *PlainApi) Delete(_ context.Context, _ string) (interface{}, error) { return nil, nil }
+
+func (a *PlainApi) List(_ context.Context) (interface{}, error) { return nil, nil }
+
+// Struct definition required to satisfy the TypedService and OutputConsumer interfaces
+type TypedService struct {
+	orchestration.Recoverable
+	orchestration.TypedResponder[int]
+}
+
+func (s *TypedService) Name() string { return "typed" }
+
+func (s *TypedService) Check(_ context.Context) error { return nil }
+
+func (s *TypedService) Run(_ context.Context) error { return nil }
+
+func (s *TypedService) Rollback(_ context.Context) error { return nil }
+
+func (s *TypedService) RequiredOutputs() []string { return []string{"claim.id"} }
+
+func TestWrapKeepsCapabilities(t *testing.T) {
+	injector := NewInjector(Config{})
+	recorder := orchestrationtest.NewRecorder()
+
+	if _, ok := Wrap(recorder.Fake("a"), injector).(orchestration.Verifier); ok {
+		t.Errorf("Expected a chaos wrapper of a Service that does not verify not to be a Verifier\n")
+	}
+	if _, ok := Wrap(recorder.Fake("a").Verifying(), injector).(orchestration.Verifier); !ok {
+		t.Errorf("Expected a chaos wrapper of a Verifier to be a Verifier\n")
+	}
+
+	service := Wrap(&TypedService{TypedResponder: orchestration.TypedResponder[int]{Response: 42}}, injector)
+	if consumer, ok := service.(orchestration.OutputConsumer); !ok || consumer.RequiredOutputs()[0] != "claim.id" {
+		t.Errorf("Expected the RequiredOutputs of the wrapped Service\n")
+	}
+	if result, ok := orchestration.ResultOf[int](service); !ok || result != 42 {
+		t.Errorf("Expected the typed result of the wrapped Service, got %v\n", result)
+	}
+
+	api := WrapApi(&PlainApi{}, "plain", injector)
+	if _, ok := api.(orchestration.ConditionalRestApi); ok {
+		t.Errorf("Expected a chaos wrapper of an unconditional RestApi not to be conditional\n")
+	}
+	if _, ok := api.(orchestration.Patcher); ok {
+		t.Errorf("Expected a chaos wrapper of a RestApi without PATCH not to be a Patcher\n")
+	}
+	api = WrapApi(&orchestration.HTTPRestApi{}, "http", injector)
+	_, isPatcher := api.(orchestration.Patcher)
+	if _, ok := api.(orchestration.ConditionalRestApi); !ok || !isPatcher {
+		t.Errorf("Expected a chaos wrapper of an HTTPRestApi to be a conditional Patcher\n")
+	}
+}
+
+func TestRuleLatencyIsADurationString(t *testing.T) {
+	rule := Rule{}
+	if err := json.Unmarshal([]byte(`{"fault": "LATENCY", "latency": "5s"}`), &rule); err != nil || rule.Latency != 5*time.Second || rule.Fault != FAULT_LATENCY {
+		t.Errorf("Expected a latency of 5s, got %v %v\n", rule.Latency, err)
+	}
+	if raw, err := json.Marshal(rule); err != nil || !strings.Contains(string(raw), `"latency":"5s"`) {
+		t.Errorf("Expected the latency to be written as a string, got %s %v\n", raw, err)
+	}
+	if err := json.Unmarshal([]byte(`{"latency": 5}`), &rule); err == nil {
+		t.Errorf("Expected an error for a latency that is not a string\n")
+	}
+}
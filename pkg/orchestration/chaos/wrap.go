@@ -0,0 +1,184 @@
+package chaos
+
+import (
+	"context"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration"
+)
+
+var _ orchestration.Service = &ChaosService{}
+var _ orchestration.ServiceWrapper = &ChaosService{}
+var _ orchestration.VerifyingWrapper = &ChaosService{}
+var _ orchestration.RestApi = &ChaosApi{}
+var _ orchestration.Patcher = &chaosPatcher{}
+var _ orchestration.ConditionalRestApi = &chaosConditionalApi{}
+
+// ChaosService injects faults into the actions of the wrapped Service, using the Service name and ServiceAction
+type ChaosService struct {
+	Wrapper  orchestration.Service
+	Injector *Injector
+}
+
+// ChaosApi injects faults into the calls of the wrapped RestApi, using ApiName and the RestApiAction
+type ChaosApi struct {
+	Api      orchestration.RestApi
+	ApiName  string
+	Injector *Injector
+}
+
+// chaosPatcher is a ChaosApi of a Patcher, it injects faults into PATCH as well
+type chaosPatcher struct {
+	*ChaosApi
+}
+
+// chaosConditionalApi is a ChaosApi of a ConditionalRestApi, it injects faults into the conditional PUT and DELETE
+type chaosConditionalApi struct {
+	*ChaosApi
+}
+
+// chaosConditionalPatcher is a ChaosApi of a Patcher that is a ConditionalRestApi as well
+type chaosConditionalPatcher struct {
+	chaosConditionalApi
+}
+
+// Wrap returns a ChaosService of service. The result implements Verifier and OutputConsumer only when service does,
+// and the rollback queue and ResultOf find the PersistentService and TypedService of service through it.
+func Wrap(service orchestration.Service, injector *Injector) orchestration.Service {
+	return orchestration.WrapPreserving(service, &ChaosService{Wrapper: service, Injector: injector})
+}
+
+// WrapApi returns a ChaosApi of api. The result implements Patcher and ConditionalRestApi only when api does, so a
+// conditional write never silently becomes an unconditional one.
+func WrapApi(api orchestration.RestApi, name string, injector *Injector) orchestration.RestApi {
+	wrapped := &ChaosApi{Api: api, ApiName: name, Injector: injector}
+	_, isPatcher := api.(orchestration.Patcher)
+	_, isConditional := api.(orchestration.ConditionalRestApi)
+	switch {
+	case isPatcher && isConditional:
+		return chaosConditionalPatcher{chaosConditionalApi{wrapped}}
+	case isPatcher:
+		return chaosPatcher{wrapped}
+	case isConditional:
+		return chaosConditionalApi{wrapped}
+	}
+	return wrapped
+}
+
+func (c *ChaosService) Name() string {
+	return c.Wrapper.Name()
+}
+
+func (c *ChaosService) inject(ctx context.Context, action orchestration.ServiceAction) error {
+	return c.Injector.Inject(ctx, c.Wrapper.Name(), string(action))
+}
+
+func (c *ChaosService) Check(ctx context.Context) error {
+	if err := c.inject(ctx, orchestration.SERVICE_CHECK); err != nil {
+		return err
+	}
+	return c.Wrapper.Check(ctx)
+}
+
+func (c *ChaosService) Recover(ctx context.Context) error {
+	if err := c.inject(ctx, orchestration.SERVICE_RECOVER); err != nil {
+		return err
+	}
+	return c.Wrapper.Recover(ctx)
+}
+
+func (c *ChaosService) Run(ctx context.Context) error {
+	if err := c.inject(ctx, orchestration.SERVICE_RUN); err != nil {
+		return err
+	}
+	return c.Wrapper.Run(ctx)
+}
+
+func (c *ChaosService) Rollback(ctx context.Context) error {
+	if err := c.inject(ctx, orchestration.SERVICE_ROLLBACK); err != nil {
+		return err
+	}
+	return c.Wrapper.Rollback(ctx)
+}
+
+// Verify injects faults into VERIFY, and verifies the wrapped Service when it is a Verifier
+func (c *ChaosService) Verify(ctx context.Context) error {
+	if err := c.inject(ctx, orchestration.SERVICE_VERIFY); err != nil {
+		return err
+	}
+	if verifier, ok := c.Wrapper.(orchestration.Verifier); ok {
+		return verifier.Verify(ctx)
+	}
+	return nil
+}
+
+func (c *ChaosService) GetResponse(err error) any {
+	return c.Wrapper.GetResponse(err)
+}
+
+func (c *ChaosService) Unwrap() orchestration.Service {
+	return c.Wrapper
+}
+
+func (c *ChaosApi) inject(ctx context.Context, action orchestration.RestApiAction) error {
+	return c.Injector.Inject(ctx, c.ApiName, string(action))
+}
+
+func (c *ChaosApi) Get(ctx context.Context, name string) (orchestration.Nameable, error) {
+	if err := c.inject(ctx, orchestration.REST_API_GET); err != nil {
+		return nil, err
+	}
+	return c.Api.Get(ctx, name)
+}
+
+func (c *ChaosApi) Post(ctx context.Context, obj orchestration.Nameable) (interface{}, error) {
+	if err := c.inject(ctx, orchestration.REST_API_POST); err != nil {
+		return nil, err
+	}
+	return c.Api.Post(ctx, obj)
+}
+
+func (c *ChaosApi) Put(ctx context.Context, obj orchestration.Nameable) (interface{}, error) {
+	if err := c.inject(ctx, orchestration.REST_API_PUT); err != nil {
+		return nil, err
+	}
+	return c.Api.Put(ctx, obj)
+}
+
+func (c *ChaosApi) Delete(ctx context.Context, name string) (interface{}, error) {
+	if err := c.inject(ctx, orchestration.REST_API_DELETE); err != nil {
+		return nil, err
+	}
+	return c.Api.Delete(ctx, name)
+}
+
+func (c chaosPatcher) Patch(ctx context.Context, name string, patchType orchestration.PatchType, patch []byte) (interface{}, error) {
+	if err := c.inject(ctx, orchestration.REST_API_PATCH); err != nil {
+		return nil, err
+	}
+	return c.Api.(orchestration.Patcher).Patch(ctx, name, patchType, patch)
+}
+
+func (c chaosConditionalPatcher) Patch(ctx context.Context, name string, patchType orchestration.PatchType, patch []byte) (interface{}, error) {
+	return chaosPatcher{c.ChaosApi}.Patch(ctx, name, patchType, patch)
+}
+
+func (c chaosConditionalApi) PutIfMatch(ctx context.Context, obj orchestration.Nameable, version string) (interface{}, error) {
+	if err := c.inject(ctx, orchestration.REST_API_PUT); err != nil {
+		return nil, err
+	}
+	return c.Api.(orchestration.ConditionalRestApi).PutIfMatch(ctx, obj, version)
+}
+
+func (c chaosConditionalApi) DeleteIfMatch(ctx context.Context, name, version string) (interface{}, error) {
+	if err := c.inject(ctx, orchestration.REST_API_DELETE); err != nil {
+		return nil, err
+	}
+	return c.Api.(orchestration.ConditionalRestApi).DeleteIfMatch(ctx, name, version)
+}
+
+func (c *ChaosApi) List(ctx context.Context) (interface{}, error) {
+	if err := c.inject(ctx, orchestration.REST_API_LIST); err != nil {
+		return nil, err
+	}
+	return c.Api.List(ctx)
+}
@@ -0,0 +1,398 @@
+// Package cli contains the orchestrate command-line runner for orchestration specs. Any binary that registers its
+// Service factories can embed it:
+//
+//	func main() {
+//	    spec.Register("memory-claim-create", ...)
+//	    cli.Main(spec.DefaultRegistry)
+//	}
+//
+// Usage:
+//
+//	orchestrate validate <spec>                 Validate the spec and its Services
+//	orchestrate plan [-o table|json] <spec>     Dry run: only Check all stages
+//	orchestrate apply [-o table|json] <spec>    Check, Run (and when needed Rollback) all stages
+//	orchestrate rollback [-o table|json] <spec> Rollback the stages of the last apply in reversed order
+//	orchestrate diagram [-o mermaid|dot] <spec> Render the stages as a diagram
+//
+// Apply saves a RunRecord next to the spec with the Services that ran, or of which the rollback failed. Rollback
+// rebuilds those Services from the same spec and rolls them back, the record is removed once all of them are.
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration"
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration/diagram"
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration/spec"
+	"github.com/ing-bank/orchestration-pkg/pkg/redact"
+	"github.com/ing-bank/orchestration-pkg/pkg/task"
+)
+
+// Exit codes, so scripts can tell the failed action apart
+const (
+	EXIT_OK              = 0
+	EXIT_USAGE           = 1 // Invalid arguments or spec
+	EXIT_CHECK_FAILED    = 2
+	EXIT_RUN_FAILED      = 3 // Includes failed verifications, rejected approvals and cancellation
+	EXIT_ROLLBACK_FAILED = 4
+)
+
+const usage = `Usage: orchestrate <command> [-o table|json|mermaid|dot] <spec>
+
+Commands:
+  validate  Validate the spec and its Services
+  plan      Dry run: only Check all stages
+  apply     Check, Run (and when needed Rollback) all stages, Ctrl+C cancels
+  rollback  Rollback the stages of the last apply in reversed order
+  diagram   Render the stages as a Mermaid (default) or DOT diagram
+`
+
+// Main runs the command in os.Args with registry, and exits with its exit code
+func Main(registry *spec.Registry) {
+	os.Exit(Run(os.Args[1:], registry, os.Stdin, os.Stdout, os.Stderr))
+}
+
+// Run runs the command in args and returns its exit code. The final Response is written to stdout, progress to
+// stderr.
+func Run(args []string, registry *spec.Registry, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return EXIT_USAGE
+	}
+
+	command := args[0]
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	defaultOutput, outputs := "table", []string{"table", "json"}
+	if command == "diagram" {
+		defaultOutput, outputs = "mermaid", []string{"mermaid", "dot"}
+	}
+	output := flags.String("o", defaultOutput, "output format: "+strings.Join(outputs, " or "))
+	if err := flags.Parse(args[1:]); err != nil {
+		return EXIT_USAGE
+	}
+	if flags.NArg() != 1 || (*output != outputs[0] && *output != outputs[1]) {
+		fmt.Fprint(stderr, usage)
+		return EXIT_USAGE
+	}
+
+	s, err := spec.Load(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return EXIT_USAGE
+	}
+	plan, err := s.Build(registry)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return EXIT_USAGE
+	}
+
+	stderr = &syncWriter{writer: stderr} // Also written by the RollbackErrorReporter, in the background
+	r := &runner{plan: plan, specPath: flags.Arg(0), stdin: stdin, stdout: stdout, stderr: stderr, output: *output}
+	switch command {
+	case "validate":
+		fmt.Fprintf(stdout, "Spec %s is valid: %d stage(s), %d service(s)\n", plan.Name, len(plan.Stages), countServices(plan.Stages))
+		return EXIT_OK
+	case "plan":
+		plan.DryRun = true
+		return r.apply()
+	case "apply":
+		return r.apply()
+	case "rollback":
+		return r.rollback()
+	case "diagram":
+		graph := diagram.FromStages(plan.Name, plan.Stages, nil)
+		for i, cluster := range graph.Clusters {
+			cluster.Label = plan.StageNames[i]
+		}
+		if *output == "dot" {
+			fmt.Fprint(stdout, graph.DOT())
+		} else {
+			fmt.Fprint(stdout, graph.Mermaid())
+		}
+		return EXIT_OK
+	default:
+		fmt.Fprint(stderr, usage)
+		return EXIT_USAGE
+	}
+}
+
+type runner struct {
+	plan     *spec.Plan
+	specPath string
+	stdin    io.Reader
+	stdout   io.Writer
+	stderr   io.Writer
+	output   string
+
+	lock            sync.Mutex
+	rollbackFailure bool
+	failedRollbacks map[string]bool // Names of the Services of which the rollback failed
+}
+
+func (r *runner) apply() int {
+	digest, err := digestOf(r.specPath)
+	if err != nil {
+		fmt.Fprintf(r.stderr, "%v\n", err)
+		return EXIT_USAGE
+	}
+	restore := r.installHooks()
+	defer restore()
+
+	opts := r.plan.Opts
+	stage := 0
+	opts.OnStageStart = func(_ context.Context, _ []orchestration.Service) {
+		fmt.Fprintf(r.stderr, "Stage %d/%d: %s\n", stage+1, len(r.plan.Stages), r.plan.StageNames[stage])
+		stage++
+	}
+	if len(opts.ApprovalStages) > 0 && !r.plan.DryRun {
+		opts.Approvals = r.terminalApprovals()
+	}
+
+	ctx, cancel := r.plan.Context(context.Background())
+	defer cancel()
+	call := orchestration.CallStagedServicesAsync(ctx, r.plan.Stages, opts)
+
+	// First Ctrl+C cancels and rolls back, the second abandons in-flight actions
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt)
+	defer signal.Stop(signals)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-signals:
+				if i == 0 {
+					fmt.Fprintf(r.stderr, "Cancelling, rolling back the stages that ran (Ctrl+C again to abandon)\n")
+					call.Cancel()
+				} else {
+					call.Abandon()
+				}
+			case <-call.Done():
+				return
+			}
+		}
+	}()
+
+	nStagesRun, errs, err := call.Wait()
+	orchestration.Wait() // RollbackErrorReporter calls
+	if !r.plan.DryRun {
+		record := r.recordOf(nStagesRun, errs, err)
+		record.Digest = digest
+		if err := saveRecord(r.specPath, record); err != nil {
+			fmt.Fprintf(r.stderr, "Could not save the run record, rollback will not know this apply: %v\n", err)
+		}
+	}
+	_, response := orchestration.GenerateStagedResponse(r.plan.Stages, nStagesRun, errs, err)
+	r.print(response)
+	return r.exitCode(err)
+}
+
+// recordOf returns the RunRecord of an apply: the Services that ran and were not rolled back, and the Services of
+// which the rollback failed
+func (r *runner) recordOf(nStagesRun int, errs []error, err error) *RunRecord {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	record := &RunRecord{Spec: r.specPath, StagesRun: nStagesRun}
+	rolledBack := err != nil && !r.plan.Opts.SkipRollback
+	ranFailedStage := errors.Is(err, orchestration.ErrRunFailed) || errors.Is(err, orchestration.ErrVerifyFailed)
+	for i, stage := range r.plan.Stages {
+		for j, service := range stage {
+			ran := i < nStagesRun || (i == nStagesRun && ranFailedStage && j < len(errs) && errs[j] == nil)
+			for _, leaf := range leaves([]orchestration.Service{service}) {
+				if r.failedRollbacks[leaf.Name()] {
+					record.Services = append(record.Services, ServiceRecord{Stage: i, Name: leaf.Name(), State: SERVICE_STATE_ROLLBACK_FAILED})
+				} else if ran && !rolledBack {
+					record.Services = append(record.Services, ServiceRecord{Stage: i, Name: leaf.Name(), State: SERVICE_STATE_RAN})
+				}
+			}
+		}
+	}
+	return record
+}
+
+// rollback rolls back the Services in the RunRecord of the spec, stage by stage in reversed order. The Services of
+// which the rollback fails stay in the record, so rollback can be repeated.
+func (r *runner) rollback() int {
+	record, err := loadRecord(r.specPath)
+	if err != nil {
+		fmt.Fprintf(r.stderr, "%v\n", err)
+		return EXIT_USAGE
+	}
+	services := map[string]orchestration.Service{}
+	for _, stage := range r.plan.Stages {
+		for _, service := range leaves(stage) {
+			services[service.Name()] = service
+		}
+	}
+	stages := make([][]orchestration.Service, len(r.plan.Stages))
+	for _, service := range record.Services {
+		if service.Stage < 0 || service.Stage >= len(stages) || services[service.Name] == nil {
+			fmt.Fprintf(r.stderr, "Service %s of the run record is not in stage %d of the spec\n", service.Name, service.Stage+1)
+			return EXIT_USAGE
+		}
+		stages[service.Stage] = append(stages[service.Stage], services[service.Name])
+	}
+
+	restore := r.installHooks()
+	defer restore()
+	ctx, cancel := r.plan.Context(context.Background())
+	defer cancel()
+
+	remaining := &RunRecord{Spec: record.Spec, Digest: record.Digest, StagesRun: record.StagesRun}
+	var rolledBack []orchestration.Service
+	var errs []error
+	for i := len(stages) - 1; i >= 0; i-- {
+		if len(stages[i]) == 0 {
+			continue
+		}
+		fmt.Fprintf(r.stderr, "Stage %d/%d: %s\n", i+1, len(stages), r.plan.StageNames[i])
+		stageErrs := orchestration.RunServiceAction(ctx, stages[i], orchestration.SERVICE_ROLLBACK)
+		for j, err := range stageErrs {
+			if err != nil {
+				remaining.Services = append(remaining.Services, ServiceRecord{Stage: i, Name: stages[i][j].Name(), State: SERVICE_STATE_ROLLBACK_FAILED})
+			}
+		}
+		rolledBack = append(rolledBack, stages[i]...)
+		errs = append(errs, stageErrs...)
+	}
+	orchestration.Wait() // RollbackErrorReporter calls
+
+	if err := saveRecord(r.specPath, remaining); err != nil {
+		fmt.Fprintf(r.stderr, "Could not save the run record: %v\n", err)
+	}
+	err = nil
+	if task.AnyError(errs) {
+		err = orchestration.ErrRollbackFailed
+	}
+	_, response := orchestration.GenerateResponse(rolledBack, errs, err)
+	r.print(response)
+	return r.exitCode(err)
+}
+
+// installHooks shows progress on stderr and records rollback failures, restore undoes this
+func (r *runner) installHooks() (restore func()) {
+	previousLogger, previousReporter := orchestration.ActionLogger, orchestration.RollbackErrorReporter
+	orchestration.ActionLogger = func(_ context.Context, services []orchestration.Service, action orchestration.ServiceAction) {
+		if len(services) > 0 {
+			names := orchestration.Services(services).GetNames()
+			fmt.Fprintf(r.stderr, "  %-8s %s\n", action, strings.Join(names, ", "))
+		}
+	}
+	orchestration.RollbackErrorReporter = func(ctx context.Context, services []orchestration.Service, errs []error) {
+		if task.AnyError(errs) {
+			r.lock.Lock()
+			r.rollbackFailure = true
+			if r.failedRollbacks == nil {
+				r.failedRollbacks = map[string]bool{}
+			}
+			for i, err := range errs {
+				if err != nil {
+					r.failedRollbacks[services[i].Name()] = true
+				}
+			}
+			r.lock.Unlock()
+			for i, err := range errs {
+				if err != nil {
+					fmt.Fprintf(r.stderr, "  Rollback failed for %s: %v\n", services[i].Name(), redact.Error(err))
+				}
+			}
+		}
+		if previousReporter != nil {
+			previousReporter(ctx, services, errs)
+		}
+	}
+	return func() {
+		orchestration.ActionLogger, orchestration.RollbackErrorReporter = previousLogger, previousReporter
+	}
+}
+
+// terminalApprovals asks for approvals on stdin
+func (r *runner) terminalApprovals() *orchestration.ApprovalGate {
+	gate := orchestration.NewApprovalGate(&orchestration.MemoryApprovalStore{})
+	input := bufio.NewReader(r.stdin)
+	gate.OnRequest = func(approval orchestration.Approval) {
+		go func() {
+			r.print(approval.Checks)
+			fmt.Fprintf(r.stderr, "Run stage %d (%s)? [y/N] ", approval.Stage+1, r.plan.StageNames[approval.Stage])
+			answer, _ := input.ReadString('\n')
+			if strings.ToLower(strings.TrimSpace(answer)) == "y" {
+				_ = gate.Approve(approval.ID, "terminal")
+			} else {
+				_ = gate.Reject(approval.ID, "terminal", "rejected in terminal")
+			}
+		}()
+	}
+	return gate
+}
+
+func (r *runner) exitCode(err error) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.rollbackFailure || errors.Is(err, orchestration.ErrRollbackFailed) {
+		return EXIT_ROLLBACK_FAILED
+	}
+	if errors.Is(err, orchestration.ErrCheckFailed) || errors.Is(err, orchestration.ErrRecoverFailed) {
+		return EXIT_CHECK_FAILED
+	}
+	if err != nil {
+		return EXIT_RUN_FAILED
+	}
+	return EXIT_OK
+}
+
+func (r *runner) print(response *orchestration.Response) {
+	if r.output == "json" {
+		raw, _ := json.MarshalIndent(response, "", "  ")
+		fmt.Fprintf(r.stdout, "%s\n", raw)
+		return
+	}
+
+	writer := tabwriter.NewWriter(r.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(writer, "STATUS: %s\n\nSERVICE\tDETAIL\n", response.Status)
+	for _, detail := range response.Details {
+		fmt.Fprintf(writer, "%s\t%s\n", detail.Name, formatDetail(detail.Detail))
+	}
+	_ = writer.Flush()
+}
+
+// syncWriter serializes the writes to writer
+type syncWriter struct {
+	lock   sync.Mutex
+	writer io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.writer.Write(p)
+}
+
+func formatDetail(detail any) string {
+	if text, ok := detail.(string); ok {
+		return text
+	}
+	raw, err := json.Marshal(detail)
+	if err != nil {
+		return fmt.Sprint(detail)
+	}
+	return string(raw)
+}
+
+func countServices(stages [][]orchestration.Service) int {
+	count := 0
+	for _, stage := range stages {
+		count += len(stage)
+	}
+	return count
+}
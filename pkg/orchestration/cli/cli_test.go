@@ -0,0 +1,206 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration"
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration/spec"
+)
+
+// Struct definition required to satisfy the Service interface. Runs in datacenter "DC_FAIL" fail, rollbacks in
+// datacenter "DC_STUCK" fail. Successful rollbacks are counted in rollbacks.
+type Claim struct {
+	orchestration.SimpleService
+	Datacenter string
+}
+
+var rollbacks sync.Map
+
+func (c *Claim) Name() string { return "Claim " + c.Datacenter }
+
+func (c *Claim) Run(_ context.Context) error {
+	if c.Datacenter == "DC_FAIL" {
+		return errors.New("failed")
+	}
+	return nil
+}
+
+func (c *Claim) Rollback(_ context.Context) error {
+	if c.Datacenter == "DC_STUCK" {
+		return errors.New("stuck")
+	}
+	count, _ := rollbacks.LoadOrStore(c.Datacenter, new(int32))
+	atomic.AddInt32(count.(*int32), 1)
+	return nil
+}
+
+// rollbacksOf returns the number of successful rollbacks in datacenter
+func rollbacksOf(datacenter string) int32 {
+	count, ok := rollbacks.Load(datacenter)
+	if !ok {
+		return 0
+	}
+	return atomic.LoadInt32(count.(*int32))
+}
+
+func resetRollbacks() {
+	rollbacks.Range(func(datacenter, _ any) bool {
+		rollbacks.Delete(datacenter)
+		return true
+	})
+}
+
+// writeSpec writes the spec to a file in a temporary directory, and returns its path
+func writeSpec(t *testing.T, specYaml string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "spec.yaml")
+	if err := os.WriteFile(path, []byte(specYaml), 0o600); err != nil {
+		t.Fatalf("Expected the spec to be written, got %v\n", err)
+	}
+	return path
+}
+
+// run writes the spec to a file and runs the command on it
+func run(t *testing.T, specYaml string, args ...string) (int, string, string) {
+	t.Helper()
+	return runOn(writeSpec(t, specYaml), args...)
+}
+
+// runOn runs the command on the spec at path
+func runOn(path string, args ...string) (int, string, string) {
+	registry := spec.NewRegistry()
+	registry.Register("claim", func(def spec.Definition) (orchestration.Service, error) {
+		return &Claim{Datacenter: def.Target}, nil
+	})
+
+	var stdout, stderr bytes.Buffer
+	code := Run(append(args, path), registry, strings.NewReader(""), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+const claims = `
+name: claims
+stages:
+  - name: claim
+    services:
+      - type: claim
+        targets: [DC1, DC2]
+`
+
+func TestApply(t *testing.T) {
+	code, stdout, stderr := run(t, claims, "apply", "-o", "json")
+	if code != EXIT_OK {
+		t.Fatalf("Expected exit code %d, got %d: %s\n", EXIT_OK, code, stderr)
+	}
+	response := orchestration.Response{}
+	if err := json.Unmarshal([]byte(stdout), &response); err != nil || response.Status != "ok" || len(response.Details) != 2 {
+		t.Errorf("Expected a JSON response with 2 details, got %s %v\n", stdout, err)
+	}
+	if !strings.Contains(stderr, "Stage 1/1: claim") {
+		t.Errorf("Expected the progress on stderr, got %s\n", stderr)
+	}
+}
+
+func TestApplyFailure(t *testing.T) {
+	code, stdout, _ := run(t, strings.Replace(claims, "DC2", "DC_FAIL", 1), "apply")
+	if code != EXIT_RUN_FAILED {
+		t.Errorf("Expected exit code %d, got %d\n", EXIT_RUN_FAILED, code)
+	}
+	if !strings.Contains(stdout, "Claim DC_FAIL") || !strings.Contains(stdout, "failed") {
+		t.Errorf("Expected a table with the failed Service, got %s\n", stdout)
+	}
+}
+
+func TestPlanDoesNotRun(t *testing.T) {
+	if code, _, stderr := run(t, strings.Replace(claims, "DC2", "DC_FAIL", 1), "plan"); code != EXIT_OK {
+		t.Errorf("Expected a dry run to only Check, got exit code %d: %s\n", code, stderr)
+	}
+}
+
+func TestValidateAndDiagram(t *testing.T) {
+	if code, stdout, _ := run(t, claims, "validate"); code != EXIT_OK || !strings.Contains(stdout, "1 stage(s), 2 service(s)") {
+		t.Errorf("Expected the spec to be valid, got %d %s\n", code, stdout)
+	}
+	if code, stdout, _ := run(t, claims, "diagram", "-o", "dot"); code != EXIT_OK || !strings.HasPrefix(stdout, "digraph") {
+		t.Errorf("Expected a DOT diagram, got %d %s\n", code, stdout)
+	}
+}
+
+const rollbackClaims = `
+name: claims
+stages:
+  - name: first
+    services:
+      - type: claim
+        targets: [DC_FIRST]
+  - name: second
+    services:
+      - type: claim
+        targets: [DC_SECOND, DC_STUCK]
+`
+
+func TestApplyThenRollback(t *testing.T) {
+	resetRollbacks()
+	path := writeSpec(t, strings.Replace(rollbackClaims, ", DC_STUCK", "", 1))
+	if code, _, stderr := runOn(path, "apply"); code != EXIT_OK {
+		t.Fatalf("Expected exit code %d, got %d: %s\n", EXIT_OK, code, stderr)
+	}
+	if _, err := os.Stat(recordPath(path)); err != nil {
+		t.Fatalf("Expected apply to save a run record, got %v\n", err)
+	}
+
+	code, stdout, stderr := runOn(path, "rollback", "-o", "json")
+	if code != EXIT_OK || rollbacksOf("DC_FIRST") != 1 || rollbacksOf("DC_SECOND") != 1 {
+		t.Errorf("Expected both stages to be rolled back once, got %d %s\n", code, stderr)
+	}
+	if !strings.Contains(stdout, `"status": "ok"`) || strings.Index(stderr, "Stage 2/2") > strings.Index(stderr, "Stage 1/2") {
+		t.Errorf("Expected the stages to be rolled back in reversed order, got %s %s\n", stdout, stderr)
+	}
+	if code, _, _ := runOn(path, "rollback"); code != EXIT_USAGE {
+		t.Errorf("Expected no second rollback after the run record was removed, got %d\n", code)
+	}
+}
+
+func TestRollbackKeepsFailedRollbacks(t *testing.T) {
+	path := writeSpec(t, rollbackClaims)
+	if code, _, stderr := runOn(path, "apply"); code != EXIT_OK {
+		t.Fatalf("Expected exit code %d, got %d: %s\n", EXIT_OK, code, stderr)
+	}
+	if code, _, _ := runOn(path, "rollback"); code != EXIT_ROLLBACK_FAILED {
+		t.Errorf("Expected exit code %d, got %d\n", EXIT_ROLLBACK_FAILED, code)
+	}
+	record, err := loadRecord(path)
+	if err != nil || len(record.Services) != 1 || record.Services[0].Name != "Claim DC_STUCK" || record.Services[0].State != SERVICE_STATE_ROLLBACK_FAILED {
+		t.Errorf("Expected only the failed rollback to stay in the run record, got %+v %v\n", record, err)
+	}
+
+	if err := os.WriteFile(path, []byte(strings.Replace(rollbackClaims, "first", "changed", 1)), 0o600); err != nil {
+		t.Fatalf("Expected the spec to be written, got %v\n", err)
+	}
+	if code, _, stderr := runOn(path, "rollback"); code != EXIT_USAGE || !strings.Contains(stderr, "spec changed") {
+		t.Errorf("Expected a rollback of a changed spec to be refused, got %d %s\n", code, stderr)
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	for _, args := range [][]string{{"apply", "-o", "yaml"}, {"unknown"}} {
+		if code, _, _ := run(t, claims, args...); code != EXIT_USAGE {
+			t.Errorf("Expected exit code %d for %v, got %d\n", EXIT_USAGE, args, code)
+		}
+	}
+	if code, _, _ := run(t, "name: claims\nstages: [{services: [{type: unknown}]}]\n", "validate"); code != EXIT_USAGE {
+		t.Errorf("Expected an invalid spec to be a usage error, got %d\n", code)
+	}
+	if code := Run(nil, spec.NewRegistry(), strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{}); code != EXIT_USAGE {
+		t.Errorf("Expected no arguments to be a usage error, got %d\n", code)
+	}
+}
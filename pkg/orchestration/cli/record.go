@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration"
+)
+
+type ServiceState string
+
+const (
+	SERVICE_STATE_RAN             ServiceState = "ran"            // Ran, and was not rolled back
+	SERVICE_STATE_ROLLBACK_FAILED ServiceState = "rollbackFailed" // Its rollback failed, and can be retried
+)
+
+// RunRecord is saved next to the spec by apply, so rollback knows which Services are still to be rolled back. It is
+// removed when nothing is left to roll back.
+type RunRecord struct {
+	Spec      string          `json:"spec"`
+	Digest    string          `json:"digest"` // Of the spec, a rollback of a changed spec is refused
+	StagesRun int             `json:"stages_run"`
+	Services  []ServiceRecord `json:"services"`
+}
+
+type ServiceRecord struct {
+	Stage int          `json:"stage"`
+	Name  string       `json:"name"`
+	State ServiceState `json:"state"`
+}
+
+var errNoRunRecord = errors.New("no run record, nothing to roll back")
+
+// recordPath returns the path of the RunRecord of the spec at specPath
+func recordPath(specPath string) string {
+	return specPath + ".run.json"
+}
+
+// digestOf returns the SHA-256 of the file at path
+func digestOf(path string) (string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func loadRecord(specPath string) (*RunRecord, error) {
+	raw, err := os.ReadFile(recordPath(specPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errNoRunRecord
+	} else if err != nil {
+		return nil, err
+	}
+	record := &RunRecord{}
+	if err := json.Unmarshal(raw, record); err != nil {
+		return nil, errors.New("invalid run record: " + err.Error())
+	}
+	digest, err := digestOf(specPath)
+	if err != nil {
+		return nil, err
+	}
+	if digest != record.Digest {
+		return nil, errors.New("the spec changed since it was applied, restore it to roll back")
+	}
+	return record, nil
+}
+
+// saveRecord replaces the RunRecord of the spec through a rename, or removes it when it has no Services
+func saveRecord(specPath string, record *RunRecord) error {
+	path := recordPath(specPath)
+	if len(record.Services) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	raw, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// leaves returns the Services of a stage, with groups replaced by the Services they contain. A group only rolls back
+// the Services it ran itself, so after a restart its Services are rolled back one by one.
+func leaves(services []orchestration.Service) []orchestration.Service {
+	var result []orchestration.Service
+	for _, service := range services {
+		switch group := service.(type) {
+		case *orchestration.ServiceGroup:
+			result = append(result, leaves(group.Services)...)
+		case *orchestration.RolloutGroup:
+			result = append(result, leaves(group.Services)...)
+		case *orchestration.StagedServiceGroup:
+			for _, stage := range group.Stages {
+				result = append(result, leaves(stage)...)
+			}
+		default:
+			result = append(result, service)
+		}
+	}
+	return result
+}
@@ -0,0 +1,173 @@
+package orchestration
+
+import (
+	"context"
+	"sync"
+)
+
+var _ Service = &ConditionalService{}
+var _ ServiceWrapper = &ConditionalService{}
+
+// StageResult is the outcome of a stage that completed during CallStagedServices
+type StageResult struct {
+	Index    int
+	Services []Service
+	Errs     []error
+}
+
+// Find returns the Service with name in this stage
+func (r StageResult) Find(name string) (Service, bool) {
+	for _, service := range r.Services {
+		if service.Name() == name {
+			return service, true
+		}
+	}
+	return nil, false
+}
+
+// ConditionalService wraps a Service that should only be called when Condition holds. The Condition is evaluated
+// by every Check, with the results of the stages that completed so far, so a dry run does not decide for a later
+// call. The Run, Verify and Rollback that follow use the outcome of that Check. A skipped Service is never checked,
+// run or rolled back, and responds with "skipped". For example, to only delete the old claim when the new claim
+// replaced it:
+//
+//	CallStagedServices(ctx, [][]Service{
+//	    {&CreateClaim{Datacenter: "DC1"}},
+//	    {When(func(ctx context.Context, previous []StageResult) bool {
+//	        created, _ := previous[0].Find("Claim DC1")
+//	        claim, _ := ResultOf[Claim](created)
+//	        return claim.Replaces != ""
+//	    }, &DeleteOldClaim{Datacenter: "DC1"})},
+//	}, CallServicesOpts{})
+type ConditionalService struct {
+	Wrapper   Service
+	Condition func(ctx context.Context, previous []StageResult) bool
+
+	lock      sync.Mutex
+	evaluated bool // Whether a Check evaluated the Condition, otherwise the next action does
+	skipped   bool
+}
+
+func When(condition func(ctx context.Context, previous []StageResult) bool, service Service) Service {
+	return &ConditionalService{Wrapper: service, Condition: condition}
+}
+
+// IsSkipped returns true when service is a ConditionalService that has been skipped
+func IsSkipped(service Service) bool {
+	conditional, ok := service.(*ConditionalService)
+	return ok && conditional.Skipped()
+}
+
+// PreviousStages returns the results of the stages that completed so far in the current CallStagedServices
+func PreviousStages(ctx context.Context) []StageResult {
+	history, ok := ctx.Value("stageHistory").(*stageHistory)
+	if !ok {
+		return nil
+	}
+	history.lock.Lock()
+	defer history.lock.Unlock()
+	return append([]StageResult{}, history.stages...)
+}
+
+// Skipped returns true when the Condition did not hold in the last evaluation
+func (c *ConditionalService) Skipped() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.skipped
+}
+
+// evaluate evaluates the Condition and returns whether the Service is skipped
+func (c *ConditionalService) evaluate(ctx context.Context) bool {
+	skipped := c.Condition != nil && !c.Condition(ctx, PreviousStages(ctx))
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.evaluated = true
+	c.skipped = skipped
+	return skipped
+}
+
+// isSkipped returns the outcome of the last evaluation, and evaluates the Condition when there was none
+func (c *ConditionalService) isSkipped(ctx context.Context) bool {
+	c.lock.Lock()
+	evaluated, skipped := c.evaluated, c.skipped
+	c.lock.Unlock()
+	if !evaluated {
+		return c.evaluate(ctx)
+	}
+	return skipped
+}
+
+func (c *ConditionalService) Name() string {
+	return c.Wrapper.Name()
+}
+
+func (c *ConditionalService) Check(ctx context.Context) error {
+	if c.evaluate(ctx) {
+		return nil
+	}
+	if consumer, ok := c.Wrapper.(OutputConsumer); ok {
+		if err := RequireOutputs(ctx, consumer.RequiredOutputs()...); err != nil {
+			return err
+		}
+	}
+	return c.Wrapper.Check(ctx)
+}
+
+func (c *ConditionalService) Recover(ctx context.Context) error {
+	if c.isSkipped(ctx) {
+		return nil
+	}
+	return c.Wrapper.Recover(ctx)
+}
+
+func (c *ConditionalService) Run(ctx context.Context) error {
+	if c.isSkipped(ctx) {
+		return nil
+	}
+	return c.Wrapper.Run(ctx)
+}
+
+func (c *ConditionalService) Verify(ctx context.Context) error {
+	if verifier, ok := c.Wrapper.(Verifier); ok && !c.isSkipped(ctx) {
+		return verifier.Verify(ctx)
+	}
+	return nil
+}
+
+func (c *ConditionalService) Rollback(ctx context.Context) error {
+	if c.isSkipped(ctx) {
+		return nil
+	}
+	return c.Wrapper.Rollback(ctx)
+}
+
+func (c *ConditionalService) GetResponse(err error) any {
+	if c.Skipped() {
+		return "skipped"
+	}
+	return c.Wrapper.GetResponse(err)
+}
+
+func (c *ConditionalService) Unwrap() Service {
+	return c.Wrapper
+}
+
+// stageHistory keeps the StageResult of every completed stage, it is stored in the context by CallStagedServices
+type stageHistory struct {
+	lock   sync.Mutex
+	stages []StageResult
+}
+
+func withStageHistory(ctx context.Context) context.Context {
+	return context.WithValue(ctx, "stageHistory", &stageHistory{})
+}
+
+func recordStage(ctx context.Context, index int, services []Service, errs []error) {
+	history, ok := ctx.Value("stageHistory").(*stageHistory)
+	if !ok {
+		return
+	}
+	history.lock.Lock()
+	defer history.lock.Unlock()
+	history.stages = append(history.stages, StageResult{Index: index, Services: services, Errs: errs})
+}
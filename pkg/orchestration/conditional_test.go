@@ -0,0 +1,62 @@
+package orchestration_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration"
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration/orchestrationtest"
+)
+
+func TestConditionalServiceSkipped(t *testing.T) {
+	recorder := orchestrationtest.NewRecorder()
+	never := func(_ context.Context, _ []orchestration.StageResult) bool { return false }
+	service := orchestration.When(never, recorder.Fake("a"))
+
+	status, response := orchestration.CallServicesAndReply(context.TODO(), []orchestration.Service{service}, orchestration.CallServicesOpts{})
+	if status != 200 || response.Details[0].Detail != "skipped" || !orchestration.IsSkipped(service) {
+		t.Errorf("Expected the Service to be skipped, got %d %+v\n", status, response)
+	}
+	orchestrationtest.AssertActions(t, recorder, "a")
+}
+
+func TestConditionalServiceEvaluatedPerCall(t *testing.T) {
+	recorder := orchestrationtest.NewRecorder()
+	enabled := false
+	service := orchestration.When(func(_ context.Context, _ []orchestration.StageResult) bool { return enabled }, recorder.Fake("a"))
+
+	// The dry run skips the Service, which does not decide for the call after it
+	if _, err := orchestration.CallServices(context.TODO(), []orchestration.Service{orchestration.MakeDryRun(service)}, orchestration.CallServicesOpts{}); err != nil {
+		t.Fatalf("Expected the dry run to succeed, got %v\n", err)
+	}
+	enabled = true
+	if _, err := orchestration.CallServices(context.TODO(), []orchestration.Service{service}, orchestration.CallServicesOpts{}); err != nil {
+		t.Fatalf("Expected the call to succeed, got %v\n", err)
+	}
+	if orchestration.IsSkipped(service) {
+		t.Errorf("Expected the Service not to be skipped anymore\n")
+	}
+	orchestrationtest.AssertActions(t, recorder, "a", orchestration.SERVICE_CHECK, orchestration.SERVICE_RUN)
+
+	// The Rollback follows the Check that preceded the Run, even when the Condition changed since
+	enabled = false
+	_ = service.Rollback(context.TODO())
+	orchestrationtest.AssertRolledBack(t, recorder, "a")
+}
+
+func TestConditionalServiceUsesPreviousStages(t *testing.T) {
+	recorder := orchestrationtest.NewRecorder()
+	previousSucceeded := func(_ context.Context, previous []orchestration.StageResult) bool {
+		_, found := previous[0].Find("a")
+		return found && previous[0].Errs[0] == nil
+	}
+	stages := [][]orchestration.Service{
+		{recorder.Fake("a")},
+		{orchestration.When(previousSucceeded, recorder.Fake("b"))},
+	}
+
+	if _, _, err := orchestration.CallStagedServices(context.TODO(), stages, orchestration.CallServicesOpts{}); err != nil {
+		t.Fatalf("Expected the stages to succeed, got %v\n", err)
+	}
+	orchestrationtest.AssertCalled(t, recorder, "b", orchestration.SERVICE_RUN)
+}
@@ -0,0 +1,225 @@
+// Package diagram renders orchestrations as Graphviz DOT and Mermaid diagrams, so reviewers can see at a glance which
+// Services run together and in what order. After a run every Service can be coloured by its Outcome, e.g. to attach
+// the diagram to an incident report.
+//
+// Take the following example:
+//
+//	nStagesRun, errs, err := orchestration.CallStagedServices(ctx, stages, opts)
+//	outcomes := diagram.OutcomesOf(ctx, stages, nStagesRun, errs, err, opts).Merge(rollbackOutcomes) // See Outcomes.Reporter
+//	graph := diagram.FromStages("Create claim", stages, outcomes)
+//	fmt.Println(graph.Mermaid()) // Or graph.DOT(), e.g. piped into `dot -Tsvg`
+//
+// Orchestrations that are described as a dependency graph instead of stages use AddNode and AddEdge directly. IDs
+// are used as-is in both formats, so keep them alphanumeric.
+package diagram
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Graph contains nodes, optionally grouped in (nested) clusters, and directed edges between nodes or clusters
+type Graph struct {
+	Name     string
+	Nodes    []*Node    // Nodes outside of clusters
+	Clusters []*Cluster // Top-level clusters
+	Edges    []Edge
+}
+
+type Node struct {
+	ID      string
+	Label   string
+	Outcome Outcome
+}
+
+// Cluster is a group of nodes, e.g. a stage or a ServiceGroup
+type Cluster struct {
+	ID       string
+	Label    string
+	Nodes    []*Node
+	Clusters []*Cluster
+}
+
+// Edge points from one node or cluster ID to another
+type Edge struct {
+	From string
+	To   string
+}
+
+func New(name string) *Graph {
+	return &Graph{Name: name}
+}
+
+// AddNode adds a node outside of clusters, and returns it
+func (g *Graph) AddNode(id, label string, outcome Outcome) *Node {
+	node := &Node{ID: id, Label: label, Outcome: outcome}
+	g.Nodes = append(g.Nodes, node)
+	return node
+}
+
+func (g *Graph) AddEdge(from, to string) {
+	g.Edges = append(g.Edges, Edge{From: from, To: to})
+}
+
+// DOT renders the graph in the Graphviz DOT language
+func (g *Graph) DOT() string {
+	builder := &strings.Builder{}
+	fmt.Fprintf(builder, "digraph %s {\n", dotQuote(g.Name))
+	builder.WriteString("  rankdir=LR;\n  compound=true;\n")
+	fmt.Fprintf(builder, "  label=%s;\n", dotQuote(g.Name))
+	builder.WriteString("  node [shape=box, style=\"rounded,filled\", fillcolor=\"#ffffff\"];\n")
+
+	for _, node := range g.Nodes {
+		writeDOTNode(builder, node, "  ")
+	}
+	for _, cluster := range g.Clusters {
+		writeDOTCluster(builder, cluster, "  ")
+	}
+
+	clusters := g.clustersByID()
+	for _, edge := range g.Edges {
+		from, to, attributes := edge.From, edge.To, []string{}
+		if cluster, ok := clusters[edge.From]; ok {
+			if from = firstNodeID(cluster); from == "" {
+				continue
+			}
+			attributes = append(attributes, "ltail=cluster_"+edge.From)
+		}
+		if cluster, ok := clusters[edge.To]; ok {
+			if to = firstNodeID(cluster); to == "" {
+				continue
+			}
+			attributes = append(attributes, "lhead=cluster_"+edge.To)
+		}
+		fmt.Fprintf(builder, "  %s -> %s", dotQuote(from), dotQuote(to))
+		if len(attributes) > 0 {
+			fmt.Fprintf(builder, " [%s]", strings.Join(attributes, ", "))
+		}
+		builder.WriteString(";\n")
+	}
+	builder.WriteString("}\n")
+	return builder.String()
+}
+
+// Mermaid renders the graph as a Mermaid flowchart
+func (g *Graph) Mermaid() string {
+	builder := &strings.Builder{}
+	fmt.Fprintf(builder, "---\ntitle: %s\n---\nflowchart LR\n", mermaidEscape(g.Name))
+
+	for _, node := range g.Nodes {
+		writeMermaidNode(builder, node, "  ")
+	}
+	for _, cluster := range g.Clusters {
+		writeMermaidCluster(builder, cluster, "  ")
+	}
+	for _, edge := range g.Edges {
+		fmt.Fprintf(builder, "  %s --> %s\n", edge.From, edge.To)
+	}
+
+	used := map[Outcome][]string{}
+	g.walk(func(node *Node) {
+		if node.Outcome != OUTCOME_NONE {
+			used[node.Outcome] = append(used[node.Outcome], node.ID)
+		}
+	})
+	for _, outcome := range outcomes {
+		if ids, ok := used[outcome]; ok {
+			style := outcomeStyles[outcome]
+			fmt.Fprintf(builder, "  classDef %s fill:%s,color:%s\n", outcome, style.fill, style.font)
+			fmt.Fprintf(builder, "  class %s %s\n", strings.Join(ids, ","), outcome)
+		}
+	}
+	return builder.String()
+}
+
+// walk calls f for all nodes, including those in clusters
+func (g *Graph) walk(f func(node *Node)) {
+	var walkCluster func(cluster *Cluster)
+	walkCluster = func(cluster *Cluster) {
+		for _, node := range cluster.Nodes {
+			f(node)
+		}
+		for _, child := range cluster.Clusters {
+			walkCluster(child)
+		}
+	}
+	for _, node := range g.Nodes {
+		f(node)
+	}
+	for _, cluster := range g.Clusters {
+		walkCluster(cluster)
+	}
+}
+
+func (g *Graph) clustersByID() map[string]*Cluster {
+	clusters := map[string]*Cluster{}
+	var add func(cluster *Cluster)
+	add = func(cluster *Cluster) {
+		clusters[cluster.ID] = cluster
+		for _, child := range cluster.Clusters {
+			add(child)
+		}
+	}
+	for _, cluster := range g.Clusters {
+		add(cluster)
+	}
+	return clusters
+}
+
+// firstNodeID returns a node in cluster, edges between clusters are drawn between such nodes in DOT
+func firstNodeID(cluster *Cluster) string {
+	if len(cluster.Nodes) > 0 {
+		return cluster.Nodes[0].ID
+	}
+	for _, child := range cluster.Clusters {
+		if id := firstNodeID(child); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+func writeDOTNode(builder *strings.Builder, node *Node, indent string) {
+	fmt.Fprintf(builder, "%s%s [label=%s", indent, dotQuote(node.ID), dotQuote(node.Label))
+	if node.Outcome != OUTCOME_NONE {
+		style := outcomeStyles[node.Outcome]
+		fmt.Fprintf(builder, ", fillcolor=%s, fontcolor=%s, tooltip=%s",
+			dotQuote(style.fill), dotQuote(style.font), dotQuote(string(node.Outcome)))
+	}
+	builder.WriteString("];\n")
+}
+
+func writeDOTCluster(builder *strings.Builder, cluster *Cluster, indent string) {
+	fmt.Fprintf(builder, "%ssubgraph %s {\n", indent, dotQuote("cluster_"+cluster.ID))
+	fmt.Fprintf(builder, "%s  label=%s;\n", indent, dotQuote(cluster.Label))
+	for _, node := range cluster.Nodes {
+		writeDOTNode(builder, node, indent+"  ")
+	}
+	for _, child := range cluster.Clusters {
+		writeDOTCluster(builder, child, indent+"  ")
+	}
+	fmt.Fprintf(builder, "%s}\n", indent)
+}
+
+func writeMermaidNode(builder *strings.Builder, node *Node, indent string) {
+	fmt.Fprintf(builder, "%s%s[\"%s\"]\n", indent, node.ID, mermaidEscape(node.Label))
+}
+
+func writeMermaidCluster(builder *strings.Builder, cluster *Cluster, indent string) {
+	fmt.Fprintf(builder, "%ssubgraph %s [\"%s\"]\n", indent, cluster.ID, mermaidEscape(cluster.Label))
+	for _, node := range cluster.Nodes {
+		writeMermaidNode(builder, node, indent+"  ")
+	}
+	for _, child := range cluster.Clusters {
+		writeMermaidCluster(builder, child, indent+"  ")
+	}
+	fmt.Fprintf(builder, "%send\n", indent)
+}
+
+func dotQuote(text string) string {
+	return "\"" + strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(text) + "\""
+}
+
+func mermaidEscape(text string) string {
+	return strings.NewReplacer("\"", "#quot;", "\n", " ").Replace(text)
+}
@@ -0,0 +1,120 @@
+package diagram
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration"
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration/orchestrationtest"
+)
+
+func TestFromStagesWithOutcomes(t *testing.T) {
+	recorder := orchestrationtest.NewRecorder()
+	c := recorder.Fake("c")
+	stages := [][]orchestration.Service{
+		{&orchestration.ServiceGroup{GroupName: "group", Services: []orchestration.Service{recorder.Fake("a1"), recorder.Fake("a2")}}},
+		{recorder.Fake("b").FailOn(orchestration.SERVICE_RUN, errors.New("boom")), c},
+		{recorder.Fake("d")},
+	}
+	opts := orchestration.CallServicesOpts{}
+	nStagesRun, errs, err := orchestration.CallStagedServices(context.TODO(), stages, opts)
+	orchestration.Wait()
+
+	rollbacks := NewOutcomes()
+	rollbacks.Set(c, OUTCOME_ROLLBACK_FAILED)
+	outcomes := OutcomesOf(context.TODO(), stages, nStagesRun, errs, err, opts).Merge(rollbacks)
+	graph := FromStages("Create \"claim\"", stages, outcomes)
+
+	dot := graph.DOT()
+	for _, expected := range []string{
+		`digraph "Create \"claim\""`,
+		`subgraph "cluster_g0"`,
+		`"n0" [label="a1", fillcolor="#ffe5b4"`,
+		`"n2" [label="b", fillcolor="#f8d7da"`,
+		`"n3" [label="c", fillcolor="#dc3545"`,
+		`"n4" [label="d"];`,
+		`"n0" -> "n2" [ltail=cluster_s0, lhead=cluster_s1];`,
+	} {
+		if !strings.Contains(dot, expected) {
+			t.Errorf("Expected DOT to contain %s, got:\n%s\n", expected, dot)
+		}
+	}
+
+	mermaid := graph.Mermaid()
+	for _, expected := range []string{
+		`subgraph g0 ["group"]`,
+		"s1 --> s2",
+		"class n0,n1 rolledBack",
+		"class n2 failed",
+		"class n3 rollbackFailed",
+	} {
+		if !strings.Contains(mermaid, expected) {
+			t.Errorf("Expected Mermaid to contain %s, got:\n%s\n", expected, mermaid)
+		}
+	}
+}
+
+func TestOutcomesOfServicesWithTheSameName(t *testing.T) {
+	recorder := orchestrationtest.NewRecorder()
+	first, second := recorder.Fake("a"), recorder.Fake("a")
+	stages := [][]orchestration.Service{{first}, {second.FailOn(orchestration.SERVICE_CHECK, errors.New("boom"))}}
+	opts := orchestration.CallServicesOpts{}
+	nStagesRun, errs, err := orchestration.CallStagedServices(context.TODO(), stages, opts)
+	orchestration.Wait()
+
+	outcomes := OutcomesOf(context.TODO(), stages, nStagesRun, errs, err, opts)
+	if outcome, _ := outcomes.Get(first); outcome != OUTCOME_ROLLED_BACK {
+		t.Errorf("Expected the first \"a\" to be rolled back, got %q\n", outcome)
+	}
+	if outcome, _ := outcomes.Get(second); outcome != OUTCOME_FAILED {
+		t.Errorf("Expected the second \"a\" to have failed, got %q\n", outcome)
+	}
+}
+
+func TestOutcomesOfServicesThatWereNotCalled(t *testing.T) {
+	recorder := orchestrationtest.NewRecorder()
+	stages := [][]orchestration.Service{{recorder.Fake("a")}, {recorder.Fake("b")}}
+	for _, err := range []error{orchestration.ErrCancelled, orchestration.ErrShuttingDown, &orchestration.AdmissionError{Reason: "full"}} {
+		outcomes := OutcomesOf(context.TODO(), stages, 1, []error{err}, err, orchestration.CallServicesOpts{})
+		if outcome, _ := outcomes.Get(stages[0][0]); outcome != OUTCOME_ROLLED_BACK {
+			t.Errorf("Expected \"a\" to be rolled back after %v, got %q\n", err, outcome)
+		}
+		if outcome, _ := outcomes.Get(stages[1][0]); outcome != OUTCOME_NONE {
+			t.Errorf("Expected \"b\" to have no outcome after %v, got %q\n", err, outcome)
+		}
+	}
+}
+
+func TestMergeNil(t *testing.T) {
+	recorder := orchestrationtest.NewRecorder()
+	service := recorder.Fake("a")
+	outcomes := NewOutcomes()
+	outcomes.Set(service, OUTCOME_RAN)
+	if outcome, _ := outcomes.Merge(nil).Merge(outcomes).Get(service); outcome != OUTCOME_RAN {
+		t.Errorf("Expected merging nil or itself to keep the outcomes, got %q\n", outcome)
+	}
+}
+
+func TestOutcomesOfDryRuns(t *testing.T) {
+	recorder := orchestrationtest.NewRecorder()
+	wrapped := orchestration.MakeDryRun(recorder.Fake("b"))
+	stages := [][]orchestration.Service{{recorder.Fake("a")}, {wrapped}}
+	ctx := context.WithValue(context.TODO(), "dryRun", true)
+	opts := orchestration.CallServicesOpts{}
+	nStagesRun, errs, err := orchestration.CallStagedServices(ctx, stages, opts)
+	orchestration.Wait()
+
+	outcomes := OutcomesOf(ctx, stages, nStagesRun, errs, err, opts)
+	if outcome, _ := outcomes.Get(stages[0][0]); err != nil || outcome != OUTCOME_CHECKED {
+		t.Errorf("Expected \"a\" to be checked in a dry run, got %q %v\n", outcome, err)
+	}
+	outcomes = OutcomesOf(context.TODO(), stages, len(stages), nil, nil, opts)
+	if outcome, _ := outcomes.Get(wrapped); outcome != OUTCOME_CHECKED {
+		t.Errorf("Expected the DryRunService to be checked, got %q\n", outcome)
+	}
+	if outcome, _ := outcomes.Get(stages[0][0]); outcome != OUTCOME_RAN {
+		t.Errorf("Expected \"a\" to have run, got %q\n", outcome)
+	}
+}
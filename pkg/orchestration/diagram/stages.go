@@ -0,0 +1,210 @@
+package diagram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration"
+)
+
+type Outcome string
+
+const (
+	OUTCOME_NONE            Outcome = ""               // Not called, or unknown
+	OUTCOME_CHECKED         Outcome = "checked"        // Passed its Check, but did not Run
+	OUTCOME_RAN             Outcome = "ran"            // Ran, and was not rolled back
+	OUTCOME_FAILED          Outcome = "failed"         // Failed its own Check, Run or Verify
+	OUTCOME_ROLLED_BACK     Outcome = "rolledBack"     // Ran, and was rolled back because of another failure
+	OUTCOME_ROLLBACK_FAILED Outcome = "rollbackFailed" // Its Rollback failed, see Outcomes.Reporter
+	OUTCOME_SKIPPED         Outcome = "skipped"        // Skipped ConditionalService
+)
+
+// outcomes in the order of their classDefs in Mermaid
+var outcomes = []Outcome{
+	OUTCOME_CHECKED, OUTCOME_RAN, OUTCOME_FAILED, OUTCOME_ROLLED_BACK, OUTCOME_ROLLBACK_FAILED, OUTCOME_SKIPPED,
+}
+
+var outcomeStyles = map[Outcome]struct{ fill, font string }{
+	OUTCOME_CHECKED:         {"#cfe2ff", "#000000"},
+	OUTCOME_RAN:             {"#d1e7dd", "#000000"},
+	OUTCOME_FAILED:          {"#f8d7da", "#000000"},
+	OUTCOME_ROLLED_BACK:     {"#ffe5b4", "#000000"},
+	OUTCOME_ROLLBACK_FAILED: {"#dc3545", "#ffffff"},
+	OUTCOME_SKIPPED:         {"#e2e3e5", "#6c757d"},
+}
+
+// Outcomes maps Services to their Outcome, it is safe for concurrent use. Services that are pointers are told apart
+// by identity, so Services with the same name in different stages or groups keep their own Outcome. Other Services
+// are identified by their name.
+type Outcomes struct {
+	lock     sync.Mutex
+	outcomes map[interface{}]Outcome
+}
+
+func NewOutcomes() *Outcomes {
+	return &Outcomes{outcomes: map[interface{}]Outcome{}}
+}
+
+// keyOf returns the key of service in Outcomes
+func keyOf(service orchestration.Service) interface{} {
+	if reflect.ValueOf(service).Kind() == reflect.Ptr {
+		return service
+	}
+	return service.Name()
+}
+
+func (o *Outcomes) Set(service orchestration.Service, outcome Outcome) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.outcomes[keyOf(service)] = outcome
+}
+
+// Get returns the Outcome of service, a nil Outcomes has no outcomes
+func (o *Outcomes) Get(service orchestration.Service) (Outcome, bool) {
+	if o == nil {
+		return OUTCOME_NONE, false
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	outcome, ok := o.outcomes[keyOf(service)]
+	return outcome, ok
+}
+
+// Reporter returns a RollbackErrorReporter that marks the Services with a failed Rollback, so they stand out in the
+// diagram. Chain it with an existing reporter when needed.
+func (o *Outcomes) Reporter() func(context.Context, []orchestration.Service, []error) {
+	return func(_ context.Context, services []orchestration.Service, errs []error) {
+		for i, err := range errs {
+			if err != nil && i < len(services) {
+				o.Set(services[i], OUTCOME_ROLLBACK_FAILED)
+			}
+		}
+	}
+}
+
+// OutcomesOf derives the Outcome of every Service from the results of CallStagedServices. Background rollbacks are
+// assumed to succeed, Merge the Outcomes of Reporter to show the failed ones. Services that were never called, because
+// the call was cancelled, shut down or not admitted before their stage, have no Outcome. Services of a dry run, with
+// the dryRun flag in ctx or wrapped in a DryRunService, only get as far as OUTCOME_CHECKED.
+func OutcomesOf(ctx context.Context, stages [][]orchestration.Service, nStagesRun int, errs []error, err error, opts orchestration.CallServicesOpts) *Outcomes {
+	outcomes := NewOutcomes()
+	dryRun := ctx.Value("dryRun") != nil
+	set := func(service orchestration.Service, outcome Outcome) {
+		_, isDryRun := service.(*orchestration.DryRunService)
+		if orchestration.IsSkipped(service) {
+			outcome = OUTCOME_SKIPPED
+		} else if (dryRun || isDryRun) && (outcome == OUTCOME_RAN || outcome == OUTCOME_ROLLED_BACK) {
+			outcome = OUTCOME_CHECKED
+		}
+		outcomes.Set(service, outcome)
+	}
+
+	ran := OUTCOME_RAN
+	if err != nil && !opts.SkipRollback {
+		ran = OUTCOME_ROLLED_BACK
+	}
+	for i, stage := range stages {
+		for j, service := range stage {
+			switch {
+			case i < nStagesRun || err == nil:
+				set(service, ran)
+			case i > nStagesRun:
+				set(service, OUTCOME_NONE)
+			case j < len(errs) && notCalled(errs[j]):
+				set(service, OUTCOME_NONE)
+			case j < len(errs) && errs[j] != nil:
+				set(service, OUTCOME_FAILED)
+			case errors.Is(err, orchestration.ErrRunFailed) || errors.Is(err, orchestration.ErrVerifyFailed):
+				set(service, ran)
+			default:
+				set(service, OUTCOME_CHECKED)
+			}
+		}
+	}
+	return outcomes
+}
+
+// notCalled tells if err is set for a Service that was not called at all
+func notCalled(err error) bool {
+	return errors.Is(err, orchestration.ErrCancelled) || errors.Is(err, orchestration.ErrShuttingDown) ||
+		errors.Is(err, orchestration.ErrAdmissionRejected) || errors.Is(err, orchestration.ErrNoApprovalGate)
+}
+
+// Merge sets all outcomes of other in o, replacing existing ones. Merging a nil Outcomes changes nothing.
+func (o *Outcomes) Merge(other *Outcomes) *Outcomes {
+	if other == nil || other == o {
+		return o
+	}
+	other.lock.Lock()
+	defer other.lock.Unlock()
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	for key, outcome := range other.outcomes {
+		o.outcomes[key] = outcome
+	}
+	return o
+}
+
+// FromStages returns a graph with a cluster per stage, and an edge between consecutive stages. Groups of Services,
+// like ServiceGroup, are nested clusters. The outcomes are optional, children of a group without an outcome get the
+// outcome of the group.
+func FromStages(name string, stages [][]orchestration.Service, outcomes *Outcomes) *Graph {
+	graph := New(name)
+	builder := &stagesBuilder{outcomes: outcomes}
+	for i, stage := range stages {
+		cluster := &Cluster{ID: fmt.Sprintf("s%d", i), Label: fmt.Sprintf("Stage %d", i+1)}
+		builder.add(cluster, stage, OUTCOME_NONE)
+		graph.Clusters = append(graph.Clusters, cluster)
+		if i > 0 {
+			graph.AddEdge(graph.Clusters[i-1].ID, cluster.ID)
+		}
+	}
+	return graph
+}
+
+type stagesBuilder struct {
+	outcomes *Outcomes
+	nodes    int
+	clusters int
+}
+
+// add adds services to cluster, with groups as nested clusters
+func (b *stagesBuilder) add(cluster *Cluster, services []orchestration.Service, inherited Outcome) {
+	for _, service := range services {
+		outcome, ok := b.outcomes.Get(service)
+		if !ok {
+			outcome = inherited
+		}
+
+		var children [][]orchestration.Service
+		switch group := service.(type) {
+		case *orchestration.ServiceGroup:
+			children = [][]orchestration.Service{group.Services}
+		case *orchestration.RolloutGroup:
+			children = [][]orchestration.Service{group.Services}
+		case *orchestration.StagedServiceGroup:
+			children = group.Stages
+		}
+		if children == nil {
+			cluster.Nodes = append(cluster.Nodes, &Node{ID: fmt.Sprintf("n%d", b.nodes), Label: service.Name(), Outcome: outcome})
+			b.nodes++
+			continue
+		}
+
+		groupCluster := &Cluster{ID: fmt.Sprintf("g%d", b.clusters), Label: service.Name()}
+		b.clusters++
+		for i, stage := range children {
+			target := groupCluster
+			if len(children) > 1 { // StagedServiceGroup, a nested cluster per stage
+				target = &Cluster{ID: fmt.Sprintf("g%d", b.clusters), Label: fmt.Sprintf("%s stage %d", service.Name(), i+1)}
+				b.clusters++
+				groupCluster.Clusters = append(groupCluster.Clusters, target)
+			}
+			b.add(target, stage, outcome)
+		}
+		cluster.Clusters = append(cluster.Clusters, groupCluster)
+	}
+}
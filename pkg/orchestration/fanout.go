@@ -0,0 +1,156 @@
+package orchestration
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/redact"
+)
+
+// Target is a RestApi that a FanOut sends the same request to, e.g. the API of a single datacenter
+type Target struct {
+	Name string
+	Api  RestApi
+}
+
+// FanOut builds one RestApiService per Target from a single request template. For example, to create the same
+// claim in every datacenter:
+//
+//	fanOut := FanOut{ApiName: "MyService Create", Action: REST_API_POST, Payload: &claim, Targets: []Target{
+//	    {Name: "DC1_BLUE", Api: &MyServiceApi{Datacenter: "DC1_BLUE"}},
+//	    {Name: "DC1_RED", Api: &MyServiceApi{Datacenter: "DC1_RED"}},
+//	}}
+//	services, err := fanOut.Services()
+//	...
+//	errs, err := CallServices(ctx, services, CallServicesOpts{})
+//	result := fanOut.Merge(services, errs)
+//
+// The copies of the Payload share nothing through exported fields, but unexported fields are copied as is: a Payload
+// with unexported pointers, maps or slices shares those between the Targets.
+type FanOut struct {
+	Targets     []Target
+	ApiName     string        // Services are named "<ApiName> <Target.Name>"
+	Action      RestApiAction // Always required
+	RequestName string        // Required when Action is GET, DELETE, PATCH
+	Payload     Nameable      // Required when Action is POST, PUT. Every Service gets a deep copy, see FanOut.
+	PatchType   PatchType     // Used when Action is PATCH
+	Patch       []byte        // Required when Action is PATCH
+
+	Equal func(want, got Nameable) bool // Optional, see RestApiService
+}
+
+// FanOutResult merges the responses of the Services of a FanOut, in the order of the Targets
+type FanOutResult struct {
+	Succeeded int            `json:"succeeded"`
+	Failed    int            `json:"failed"`
+	Targets   []TargetResult `json:"targets"`
+}
+
+type TargetResult struct {
+	Target   string `json:"target"`
+	Response any    `json:"response,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Services returns a RestApiService for every Target, each with its own copy of the Payload and Patch
+func (f FanOut) Services() ([]Service, error) {
+	if len(f.Targets) == 0 {
+		return nil, errors.New("fan out of " + f.ApiName + " has no targets")
+	}
+	services := make([]Service, len(f.Targets))
+	for i, target := range f.Targets {
+		if target.Api == nil {
+			return nil, errors.New("target " + target.Name + " of " + f.ApiName + " has no RestApi")
+		}
+		var payload Nameable
+		if f.Payload != nil {
+			payload = deepCopy(reflect.ValueOf(f.Payload)).Interface().(Nameable)
+		}
+		svc := RestApiAsService(target.Api, f.Action, f.ApiName+" "+target.Name, f.RequestName, payload).(*RestApiService)
+		svc.PatchType = f.PatchType
+		svc.Patch = append([]byte(nil), f.Patch...)
+		svc.Equal = f.Equal
+		services[i] = svc
+	}
+	return services, nil
+}
+
+// Merge returns the result of every Target, given the Services and errs of CallServices. The responses of Services
+// that were not built by Services are left empty.
+func (f FanOut) Merge(services []Service, errs []error) FanOutResult {
+	errs = alignErrors(errs, len(services))
+	result := FanOutResult{Targets: []TargetResult{}}
+	for i, service := range services {
+		targetResult := TargetResult{Target: service.Name()}
+		if i < len(f.Targets) {
+			targetResult.Target = f.Targets[i].Name
+		}
+		if errs[i] != nil {
+			targetResult.Error = redact.String(errs[i].Error())
+			result.Failed++
+		} else {
+			if svc, ok := service.(*RestApiService); ok {
+				targetResult.Response = redact.Value(svc.Response)
+			}
+			result.Succeeded++
+		}
+		result.Targets = append(result.Targets, targetResult)
+	}
+	return result
+}
+
+// deepCopy returns a copy of value that shares no pointers, maps or slices with it. Unexported fields are copied
+// shallowly.
+func deepCopy(value reflect.Value) reflect.Value {
+	switch value.Kind() {
+	case reflect.Pointer:
+		if value.IsNil() {
+			return value
+		}
+		copied := reflect.New(value.Type().Elem())
+		copied.Elem().Set(deepCopy(value.Elem()))
+		return copied
+	case reflect.Interface:
+		if value.IsNil() {
+			return value
+		}
+		copied := reflect.New(value.Type()).Elem()
+		copied.Set(deepCopy(value.Elem()))
+		return copied
+	case reflect.Struct:
+		copied := reflect.New(value.Type()).Elem()
+		copied.Set(value)
+		for i := 0; i < value.NumField(); i++ {
+			if value.Type().Field(i).IsExported() {
+				copied.Field(i).Set(deepCopy(value.Field(i)))
+			}
+		}
+		return copied
+	case reflect.Slice:
+		if value.IsNil() {
+			return value
+		}
+		copied := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+		for i := 0; i < value.Len(); i++ {
+			copied.Index(i).Set(deepCopy(value.Index(i)))
+		}
+		return copied
+	case reflect.Array:
+		copied := reflect.New(value.Type()).Elem()
+		for i := 0; i < value.Len(); i++ {
+			copied.Index(i).Set(deepCopy(value.Index(i)))
+		}
+		return copied
+	case reflect.Map:
+		if value.IsNil() {
+			return value
+		}
+		copied := reflect.MakeMapWithSize(value.Type(), value.Len())
+		iter := value.MapRange()
+		for iter.Next() {
+			copied.SetMapIndex(iter.Key(), deepCopy(iter.Value()))
+		}
+		return copied
+	}
+	return value
+}
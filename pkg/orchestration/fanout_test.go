@@ -0,0 +1,88 @@
+package orchestration
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type Quota struct {
+	QuotaName string         `json:"name"`
+	Limits    map[string]int `json:"limits"`
+	Tags      []string       `json:"tags"`
+}
+
+func (q *Quota) Name() string { return q.QuotaName }
+
+// GreedyApi modifies the payloads it receives, which should not leak into other targets
+type GreedyApi struct {
+	Err      error
+	received *Quota
+}
+
+func (a *GreedyApi) Get(_ context.Context, name string) (Nameable, error) {
+	if a.received == nil {
+		return nil, ErrNotFound
+	}
+	return a.received, nil
+}
+
+func (a *GreedyApi) Post(_ context.Context, obj Nameable) (interface{}, error) {
+	if a.Err != nil {
+		return nil, a.Err
+	}
+	quota := obj.(*Quota)
+	cpu := quota.Limits["cpu"]
+	quota.Limits["cpu"] *= 2
+	quota.Tags[0] = "modified"
+	a.received = quota
+	return map[string]int{"cpu": cpu}, nil
+}
+
+func (a *GreedyApi) Put(_ context.Context, _ Nameable) (interface{}, error) { return nil, nil }
+
+func (a *GreedyApi) Delete(_ context.Context, _ string) (interface{}, error) {
+	a.received = nil
+	return nil, nil
+}
+
+func (a *GreedyApi) List(_ context.Context) (interface{}, error) { return nil, nil }
+
+func TestFanOut(t *testing.T) {
+	template := &Quota{QuotaName: "team-a", Limits: map[string]int{"cpu": 4}, Tags: []string{"original"}}
+	fanOut := FanOut{
+		ApiName: "Create quota",
+		Action:  REST_API_POST,
+		Payload: template,
+		Targets: []Target{{Name: "DC1", Api: &GreedyApi{}}, {Name: "DC2", Api: &GreedyApi{}}},
+	}
+	services, err := fanOut.Services()
+	if err != nil || len(services) != 2 || services[0].Name() != "Create quota DC1" || services[1].Name() != "Create quota DC2" {
+		t.Fatalf("Expected a service per target, got %v %v\n", services, err)
+	}
+	errs, err := CallServices(context.TODO(), services, CallServicesOpts{})
+	if err != nil {
+		t.Fatalf("Expected the fan out to succeed, got %v %v\n", err, errs)
+	}
+
+	if !reflect.DeepEqual(template, &Quota{QuotaName: "team-a", Limits: map[string]int{"cpu": 4}, Tags: []string{"original"}}) {
+		t.Errorf("Expected the template to be untouched, got %+v\n", template)
+	}
+	result := fanOut.Merge(services, errs)
+	expected := FanOutResult{Succeeded: 2, Targets: []TargetResult{
+		{Target: "DC1", Response: map[string]int{"cpu": 4}},
+		{Target: "DC2", Response: map[string]int{"cpu": 4}},
+	}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected every target to receive its own copy, got %+v\n", result)
+	}
+
+	fanOut.Targets = []Target{{Name: "DC1", Api: &GreedyApi{}}, {Name: "DC2", Api: &GreedyApi{Err: errors.New("quota exceeded")}}}
+	services, _ = fanOut.Services()
+	errs, _ = CallServices(context.TODO(), services, CallServicesOpts{SkipRollback: true})
+	result = fanOut.Merge(services, errs)
+	if result.Succeeded != 1 || result.Failed != 1 || result.Targets[1].Error != "quota exceeded" {
+		t.Errorf("Expected DC2 to fail, got %+v\n", result)
+	}
+}
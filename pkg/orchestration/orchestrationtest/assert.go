@@ -0,0 +1,126 @@
+package orchestrationtest
+
+import (
+	"testing"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration"
+)
+
+// The assertions below inspect the events in a Recorder. Background rollbacks are not awaited, call
+// orchestration.Wait first when the orchestration can roll back.
+
+// AssertActions asserts the exact action sequence of service
+func AssertActions(t testing.TB, r *Recorder, service string, actions ...orchestration.ServiceAction) {
+	t.Helper()
+	got := r.Actions(service)
+	if len(got) != len(actions) {
+		t.Errorf("Expected actions %v for %q, got %v\n", actions, service, got)
+		return
+	}
+	for i := range got {
+		if got[i] != actions[i] {
+			t.Errorf("Expected actions %v for %q, got %v\n", actions, service, got)
+			return
+		}
+	}
+}
+
+func AssertCalled(t testing.TB, r *Recorder, service string, action orchestration.ServiceAction) {
+	t.Helper()
+	if !called(r, service, action) {
+		t.Errorf("Expected %s to be called for %q, got %v\n", action, service, r.Actions(service))
+	}
+}
+
+func AssertNotCalled(t testing.TB, r *Recorder, service string, action orchestration.ServiceAction) {
+	t.Helper()
+	if called(r, service, action) {
+		t.Errorf("Expected %s not to be called for %q, got %v\n", action, service, r.Actions(service))
+	}
+}
+
+// AssertRolledBack asserts that every service was rolled back
+func AssertRolledBack(t testing.TB, r *Recorder, services ...string) {
+	t.Helper()
+	for _, service := range services {
+		AssertCalled(t, r, service, orchestration.SERVICE_ROLLBACK)
+	}
+}
+
+// AssertNotRolledBack asserts that none of the services was rolled back
+func AssertNotRolledBack(t testing.TB, r *Recorder, services ...string) {
+	t.Helper()
+	for _, service := range services {
+		AssertNotCalled(t, r, service, orchestration.SERVICE_ROLLBACK)
+	}
+}
+
+// AssertNeverRanAfterFailedCheck asserts that no Service started a Run after a Check failed, unless the Service of
+// that Check recovered afterwards
+func AssertNeverRanAfterFailedCheck(t testing.TB, r *Recorder) {
+	t.Helper()
+	events := r.Events()
+	for _, check := range events {
+		if check.Action != orchestration.SERVICE_CHECK || check.Err == nil || recovered(events, check) {
+			continue
+		}
+		for _, run := range events {
+			if run.Action == orchestration.SERVICE_RUN && run.Start > check.End {
+				t.Errorf("Expected no runs after the failed check of %q, but %q ran:\n%s\n", check.Service, run.Service, r)
+				return
+			}
+		}
+	}
+}
+
+// AssertRolledBackInReverseStageOrder asserts that every Service of stages that ran was rolled back, and that all
+// rollbacks of a stage finished before any rollback of the previous stage started. The stages must contain the
+// recorded Services themselves, not groups of them.
+func AssertRolledBackInReverseStageOrder(t testing.TB, r *Recorder, stages [][]orchestration.Service) {
+	t.Helper()
+	stageOf := map[string]int{}
+	for i, stage := range stages {
+		for _, service := range stage {
+			stageOf[service.Name()] = i
+			if called(r, service.Name(), orchestration.SERVICE_RUN) && !called(r, service.Name(), orchestration.SERVICE_ROLLBACK) {
+				t.Errorf("Expected %q (stage %d) to be rolled back after it ran:\n%s\n", service.Name(), i+1, r)
+				return
+			}
+		}
+	}
+
+	var rollbacks []Event
+	for _, event := range r.Events() {
+		if _, ok := stageOf[event.Service]; ok && event.Action == orchestration.SERVICE_ROLLBACK {
+			rollbacks = append(rollbacks, event)
+		}
+	}
+	for _, earlier := range rollbacks {
+		for _, later := range rollbacks {
+			if stageOf[earlier.Service] < stageOf[later.Service] && (later.End == 0 || earlier.Start < later.End) {
+				t.Errorf("Expected %q (stage %d) to be rolled back after %q (stage %d):\n%s\n",
+					earlier.Service, stageOf[earlier.Service]+1, later.Service, stageOf[later.Service]+1, r)
+				return
+			}
+		}
+	}
+}
+
+func called(r *Recorder, service string, action orchestration.ServiceAction) bool {
+	for _, event := range r.EventsOf(service) {
+		if event.Action == action {
+			return true
+		}
+	}
+	return false
+}
+
+// recovered returns true when the Service of check recovered successfully after it
+func recovered(events []Event, check Event) bool {
+	for _, event := range events {
+		if event.Service == check.Service && event.Action == orchestration.SERVICE_RECOVER && event.Start > check.End && event.End > 0 && event.Err == nil {
+			return true
+		}
+	}
+	return false
+}
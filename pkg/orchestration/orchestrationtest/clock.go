@@ -0,0 +1,86 @@
+package orchestrationtest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration"
+)
+
+var _ orchestration.Clock = &FakeClock{}
+
+// FakeClock is an orchestration.Clock that only moves with Advance, e.g. to test a Scheduler without waiting:
+//
+//	clock := orchestrationtest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+//	scheduler := orchestration.NewScheduler(clock)
+//	...
+//	clock.BlockUntil(1)     // The Job is waiting for its next run
+//	clock.Advance(time.Hour) // Fires the run
+//	clock.BlockUntil(1)     // The run started, and the Job waits for the next one
+//	orchestration.Wait()     // The run finished
+type FakeClock struct {
+	lock    sync.Mutex
+	changed *sync.Cond
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at      time.Time
+	channel chan time.Time
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	clock := &FakeClock{now: now}
+	clock.changed = sync.NewCond(&clock.lock)
+	return clock
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// After returns a channel that receives the time once the clock has been advanced by d
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	channel := make(chan time.Time, 1)
+	if d <= 0 {
+		channel <- c.now
+		return channel
+	}
+	c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), channel: channel})
+	c.changed.Broadcast()
+	return channel
+}
+
+// Advance moves the clock forward by d, and fires all After channels that are due, earliest first
+func (c *FakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+
+	sort.SliceStable(c.waiters, func(i, j int) bool { return c.waiters[i].at.Before(c.waiters[j].at) })
+	remaining := c.waiters[:0]
+	for _, waiter := range c.waiters {
+		if waiter.at.After(c.now) {
+			remaining = append(remaining, waiter)
+		} else {
+			waiter.channel <- c.now
+		}
+	}
+	c.waiters = remaining
+	c.changed.Broadcast()
+}
+
+// BlockUntil blocks until at least n callers are waiting on After
+func (c *FakeClock) BlockUntil(n int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for len(c.waiters) < n {
+		c.changed.Wait()
+	}
+}
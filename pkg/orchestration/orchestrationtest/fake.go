@@ -0,0 +1,160 @@
+package orchestrationtest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration"
+)
+
+var _ orchestration.Service = &FakeService{}
+var _ orchestration.Verifier = &VerifyingFake{}
+
+// ErrPanicked is recorded as the error of an action that panicked
+var ErrPanicked = errors.New("panicked")
+
+// Behavior scripts a single action of a FakeService. Without a Behavior an action succeeds immediately.
+type Behavior struct {
+	Err   error         // Returned by the action
+	Panic any           // When set the action panics with this value, task.Run turns it into an error
+	Delay time.Duration // Wait before returning, or until the context is done
+	Hang  bool          // Wait until the context is done, then return its error
+	Block chan struct{} // Wait until Block is closed or the context is done
+	Times int           // Only apply to the first Times calls of the action, zero means every call
+}
+
+// FakeService is a Service of which every action can be scripted, and that records its actions in a Recorder. For
+// example, a Service of which the Rollback fails and the Run takes a second:
+//
+//	recorder.Fake("a").FailOn(orchestration.SERVICE_ROLLBACK, errors.New("boom")).DelayOn(orchestration.SERVICE_RUN, time.Second)
+type FakeService struct {
+	orchestration.Responder
+	ServiceName string
+	Recorder    *Recorder // Optional
+	Behaviors   map[orchestration.ServiceAction]Behavior
+
+	lock  sync.Mutex
+	calls map[orchestration.ServiceAction]int
+}
+
+// VerifyingFake is a FakeService that also implements orchestration.Verifier, see FakeService.Verifying
+type VerifyingFake struct {
+	*FakeService
+}
+
+// On sets the Behavior of action, and returns f for chaining
+func (f *FakeService) On(action orchestration.ServiceAction, behavior Behavior) *FakeService {
+	if f.Behaviors == nil {
+		f.Behaviors = map[orchestration.ServiceAction]Behavior{}
+	}
+	f.Behaviors[action] = behavior
+	return f
+}
+
+func (f *FakeService) FailOn(action orchestration.ServiceAction, err error) *FakeService {
+	return f.On(action, Behavior{Err: err})
+}
+
+func (f *FakeService) PanicOn(action orchestration.ServiceAction, value any) *FakeService {
+	return f.On(action, Behavior{Panic: value})
+}
+
+func (f *FakeService) DelayOn(action orchestration.ServiceAction, delay time.Duration) *FakeService {
+	return f.On(action, Behavior{Delay: delay})
+}
+
+// HangOn makes action block until its context is done, e.g. to test timeouts and cancellation
+func (f *FakeService) HangOn(action orchestration.ServiceAction) *FakeService {
+	return f.On(action, Behavior{Hang: true})
+}
+
+// Verifying returns f as a Service that implements orchestration.Verifier, so the Verify action is used
+func (f *FakeService) Verifying() *VerifyingFake {
+	return &VerifyingFake{FakeService: f}
+}
+
+// Calls returns the number of times action was called
+func (f *FakeService) Calls(action orchestration.ServiceAction) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.calls[action]
+}
+
+func (f *FakeService) Name() string {
+	return f.ServiceName
+}
+
+func (f *FakeService) Check(ctx context.Context) error {
+	return f.do(ctx, orchestration.SERVICE_CHECK)
+}
+
+func (f *FakeService) Recover(ctx context.Context) error {
+	return f.do(ctx, orchestration.SERVICE_RECOVER)
+}
+
+func (f *FakeService) Run(ctx context.Context) error {
+	return f.do(ctx, orchestration.SERVICE_RUN)
+}
+
+func (f *FakeService) Rollback(ctx context.Context) error {
+	return f.do(ctx, orchestration.SERVICE_ROLLBACK)
+}
+
+func (v *VerifyingFake) Verify(ctx context.Context) error {
+	return v.do(ctx, orchestration.SERVICE_VERIFY)
+}
+
+// do records and performs a single action according to its Behavior
+func (f *FakeService) do(ctx context.Context, action orchestration.ServiceAction) (err error) {
+	f.lock.Lock()
+	if f.calls == nil {
+		f.calls = map[orchestration.ServiceAction]int{}
+	}
+	f.calls[action]++
+	call := f.calls[action]
+	f.lock.Unlock()
+
+	if f.Recorder != nil {
+		end := f.Recorder.start(f.ServiceName, action)
+		defer func() {
+			value := recover()
+			if value != nil {
+				err = ErrPanicked // Recorded as error, the panic itself is handled by task.Run
+			}
+			end(err)
+			if value != nil {
+				panic(value)
+			}
+		}()
+	}
+
+	behavior, ok := f.Behaviors[action]
+	if !ok || (behavior.Times > 0 && call > behavior.Times) {
+		return nil
+	}
+
+	if behavior.Hang {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if behavior.Block != nil {
+		select {
+		case <-behavior.Block:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if behavior.Delay > 0 {
+		select {
+		case <-time.After(behavior.Delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if behavior.Panic != nil {
+		panic(behavior.Panic)
+	}
+	return behavior.Err
+}
@@ -0,0 +1,109 @@
+package orchestrationtest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration"
+)
+
+func TestStagedRollbackInReverseStageOrder(t *testing.T) {
+	recorder := NewRecorder()
+	stages := [][]orchestration.Service{
+		{recorder.Fake("a1"), recorder.Fake("a2")},
+		{recorder.Fake("b").DelayOn(orchestration.SERVICE_ROLLBACK, 10*time.Millisecond)},
+		{recorder.Fake("c").FailOn(orchestration.SERVICE_RUN, errors.New("boom"))},
+		{recorder.Fake("d")},
+	}
+
+	// A StagedCall rolls back the failed stage and the stages before it one after another
+	nStagesRun, _, err := orchestration.CallStagedServicesAsync(context.TODO(), stages, orchestration.CallServicesOpts{}).Wait()
+
+	if nStagesRun != 2 || !errors.Is(err, orchestration.ErrRunFailed) {
+		t.Errorf("Expected 2 stages run and ErrRunFailed, got %d and %v\n", nStagesRun, err)
+	}
+	AssertActions(t, recorder, "c", orchestration.SERVICE_CHECK, orchestration.SERVICE_RUN, orchestration.SERVICE_ROLLBACK)
+	AssertRolledBack(t, recorder, "a1", "a2", "b")
+	AssertActions(t, recorder, "d")
+	AssertRolledBackInReverseStageOrder(t, recorder, stages)
+}
+
+func TestNeverRanAfterFailedCheck(t *testing.T) {
+	recorder := NewRecorder()
+	services := []orchestration.Service{
+		recorder.Fake("a"),
+		recorder.Fake("b").PanicOn(orchestration.SERVICE_CHECK, "boom"),
+	}
+
+	_, err := orchestration.CallServices(context.TODO(), services, orchestration.CallServicesOpts{})
+	orchestration.Wait()
+
+	if !errors.Is(err, orchestration.ErrCheckFailed) {
+		t.Errorf("Expected ErrCheckFailed, got %v\n", err)
+	}
+	if events := recorder.EventsOf("b"); len(events) != 1 || events[0].Err != ErrPanicked {
+		t.Errorf("Expected the panic of \"b\" to be recorded, got %v\n", events)
+	}
+	AssertNeverRanAfterFailedCheck(t, recorder)
+	AssertNotRolledBack(t, recorder, "a", "b")
+}
+
+func TestHangingRunTimesOut(t *testing.T) {
+	recorder := NewRecorder()
+	services := []orchestration.Service{recorder.Fake("a"), recorder.Fake("b").HangOn(orchestration.SERVICE_RUN)}
+	ctx, cancel := context.WithTimeout(context.TODO(), 20*time.Millisecond)
+	defer cancel()
+
+	errs, err := orchestration.CallServices(ctx, services, orchestration.CallServicesOpts{})
+	orchestration.Wait()
+
+	if !errors.Is(err, orchestration.ErrRunFailed) || errs[0] != nil || errs[1] == nil {
+		t.Errorf("Expected only \"b\" to fail with ErrRunFailed, got %v and %v\n", errs, err)
+	}
+	AssertCalled(t, recorder, "b", orchestration.SERVICE_RUN)
+}
+
+func TestVerifyRetriesUntilHealthy(t *testing.T) {
+	interval := orchestration.VerifyInterval
+	orchestration.VerifyInterval = time.Millisecond
+	defer func() { orchestration.VerifyInterval = interval }()
+
+	recorder := NewRecorder()
+	fake := recorder.Fake("a").On(orchestration.SERVICE_VERIFY, Behavior{Err: errors.New("not yet"), Times: 2})
+
+	_, err := orchestration.CallServices(context.TODO(), []orchestration.Service{fake.Verifying()}, orchestration.CallServicesOpts{})
+	orchestration.Wait()
+
+	if err != nil || fake.Calls(orchestration.SERVICE_VERIFY) != 3 {
+		t.Errorf("Expected success after 3 verifications, got %v after %d\n", err, fake.Calls(orchestration.SERVICE_VERIFY))
+	}
+	AssertNotRolledBack(t, recorder, "a")
+}
+
+// failures records the failures of assertions that are expected to fail
+type failures struct {
+	testing.TB
+	count int
+}
+
+func (f *failures) Helper() {}
+
+func (f *failures) Errorf(_ string, _ ...any) {
+	f.count++
+}
+
+func TestRolledBackInReverseStageOrderRequiresRollbacks(t *testing.T) {
+	recorder := NewRecorder()
+	stages := [][]orchestration.Service{{recorder.Fake("a")}, {recorder.Fake("b")}}
+	if _, _, err := orchestration.CallStagedServices(context.TODO(), stages, orchestration.CallServicesOpts{}); err != nil {
+		t.Fatalf("Expected both stages to run, got %v\n", err)
+	}
+
+	failed := &failures{TB: t}
+	AssertRolledBackInReverseStageOrder(failed, recorder, stages)
+	if failed.count != 1 {
+		t.Errorf("Expected the assertion to fail when the stages that ran were not rolled back\n")
+	}
+}
@@ -0,0 +1,122 @@
+// Package orchestrationtest contains scriptable fake Services, a Recorder of the actions they perform, and
+// assertions on the recorded actions, to test orchestrations without ad-hoc fakes.
+//
+// Take the following example:
+//
+//	func TestCreate(t *testing.T) {
+//	    recorder := orchestrationtest.NewRecorder()
+//	    a := recorder.Fake("a")
+//	    b := recorder.Fake("b").FailOn(orchestration.SERVICE_RUN, errors.New("boom"))
+//	    stages := [][]orchestration.Service{{a}, {b}}
+//
+//	    _, _, err := orchestration.CallStagedServices(context.TODO(), stages, orchestration.CallServicesOpts{})
+//	    orchestration.Wait() // Background rollbacks, instead of a sleep
+//
+//	    orchestrationtest.AssertActions(t, recorder, "a", orchestration.SERVICE_CHECK, orchestration.SERVICE_RUN, orchestration.SERVICE_ROLLBACK)
+//	    orchestrationtest.AssertRolledBackInReverseStageOrder(t, recorder, stages)
+//	}
+package orchestrationtest
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration"
+)
+
+// Event is a single action of a Service. Start and End are positions in the sequence of all recorded starts and
+// ends, so the order of (concurrent) actions can be compared: an action happened before another when its End is
+// lower than the Start of the other.
+type Event struct {
+	Service string
+	Action  orchestration.ServiceAction
+	Start   int
+	End     int // Zero while the action is still running
+	Err     error
+}
+
+// Recorder records the actions of fake Services, it is safe for concurrent use
+type Recorder struct {
+	lock     sync.Mutex
+	sequence int
+	events   []*Event
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Fake returns a new FakeService with name that records its actions in r
+func (r *Recorder) Fake(name string) *FakeService {
+	return &FakeService{ServiceName: name, Recorder: r}
+}
+
+// start records the start of an action, the returned func records its end
+func (r *Recorder) start(service string, action orchestration.ServiceAction) (end func(err error)) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.sequence++
+	event := &Event{Service: service, Action: action, Start: r.sequence}
+	r.events = append(r.events, event)
+
+	return func(err error) {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		r.sequence++
+		event.End = r.sequence
+		event.Err = err
+	}
+}
+
+// Events returns a copy of all recorded events, in the order the actions started
+func (r *Recorder) Events() []Event {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	events := make([]Event, len(r.events))
+	for i, event := range r.events {
+		events[i] = *event
+	}
+	return events
+}
+
+// EventsOf returns the events of a single Service, in the order the actions started
+func (r *Recorder) EventsOf(service string) []Event {
+	var events []Event
+	for _, event := range r.Events() {
+		if event.Service == service {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// Actions returns the action sequence of a single Service
+func (r *Recorder) Actions(service string) []orchestration.ServiceAction {
+	var actions []orchestration.ServiceAction
+	for _, event := range r.EventsOf(service) {
+		actions = append(actions, event.Action)
+	}
+	return actions
+}
+
+// Reset removes all recorded events
+func (r *Recorder) Reset() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.sequence = 0
+	r.events = nil
+}
+
+// String returns all events, one per line, which is useful in failure messages
+func (r *Recorder) String() string {
+	var lines []string
+	for _, event := range r.Events() {
+		line := fmt.Sprintf("%d-%d %s %s", event.Start, event.End, event.Service, event.Action)
+		if event.Err != nil {
+			line += ": " + event.Err.Error()
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
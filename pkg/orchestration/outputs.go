@@ -0,0 +1,122 @@
+package orchestration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Outputs is a concurrency safe store scoped to one orchestration, which allows Services to pass values to
+// Services in later stages. CallServices and CallStagedServices add Outputs to the context, so Services can use
+// Publish and Lookup. For example:
+//
+//	func (svc *CreateClaim) Run(ctx context.Context) error {
+//	    id, err := svc.Api.Create(ctx, svc.Claim)
+//	    ...
+//	    return Publish(ctx, "claim.id", id)
+//	}
+//
+//	func (svc *RegisterClaim) RequiredOutputs() []string { return []string{"claim.id"} }
+//	func (svc *RegisterClaim) Run(ctx context.Context) error {
+//	    id, err := Lookup[string](ctx, "claim.id")
+//	    ...
+//	}
+type Outputs struct {
+	lock   sync.RWMutex
+	values map[string]any
+}
+
+// OutputConsumer can be implemented by a Service that reads Outputs. When one of the RequiredOutputs is missing,
+// the Check of the Service fails without calling the Check of the Service itself.
+type OutputConsumer interface {
+	RequiredOutputs() []string
+}
+
+// WithOutputs returns a context with empty Outputs, unless ctx has Outputs already
+func WithOutputs(ctx context.Context) context.Context {
+	if OutputsFromContext(ctx) != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, "outputs", &Outputs{values: map[string]any{}})
+}
+
+// OutputsFromContext returns the Outputs of ctx, or nil when there are none
+func OutputsFromContext(ctx context.Context) *Outputs {
+	outputs, _ := ctx.Value("outputs").(*Outputs)
+	return outputs
+}
+
+func (o *Outputs) Set(key string, value any) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.values[key] = value
+}
+
+func (o *Outputs) Get(key string) (any, bool) {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	value, ok := o.values[key]
+	return value, ok
+}
+
+// Keys returns all keys that have been published, sorted
+func (o *Outputs) Keys() []string {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	keys := []string{}
+	for key := range o.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Publish stores value under key in the Outputs of ctx, overwriting any earlier value
+func Publish[T any](ctx context.Context, key string, value T) error {
+	outputs := OutputsFromContext(ctx)
+	if outputs == nil {
+		return errors.New("cannot publish " + key + ": no outputs in context (did you use WithOutputs?)")
+	}
+	outputs.Set(key, value)
+	return nil
+}
+
+// Lookup returns the value stored under key in the Outputs of ctx, an error is returned when the key is missing
+// or when the value is not of type T
+func Lookup[T any](ctx context.Context, key string) (T, error) {
+	var result T
+	outputs := OutputsFromContext(ctx)
+	if outputs == nil {
+		return result, errors.New("cannot lookup " + key + ": no outputs in context (did you use WithOutputs?)")
+	}
+	value, ok := outputs.Get(key)
+	if !ok {
+		return result, errors.New("missing output: " + key)
+	}
+	result, ok = value.(T)
+	if !ok {
+		return result, fmt.Errorf("output %s has type %T, expected %s", key, value, reflect.TypeOf(&result).Elem())
+	}
+	return result, nil
+}
+
+// RequireOutputs returns an error listing every key that is missing in the Outputs of ctx
+func RequireOutputs(ctx context.Context, keys ...string) error {
+	outputs := OutputsFromContext(ctx)
+	var missing []string
+	for _, key := range keys {
+		if outputs == nil {
+			missing = append(missing, key)
+		} else if _, ok := outputs.Get(key); !ok {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return errors.New("missing outputs: " + strings.Join(missing, ", "))
+	}
+	return nil
+}
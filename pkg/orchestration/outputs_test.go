@@ -0,0 +1,71 @@
+package orchestration
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// Struct definition required to satisfy the Service interface. Run publishes Value under Key when set, and looks up
+// the RequiredOutputs otherwise.
+type OutputService struct {
+	SimpleService
+	Key      string
+	Value    any
+	Required []string
+	Found    string
+}
+
+func (o *OutputService) Name() string { return "Output " + o.Key }
+
+func (o *OutputService) RequiredOutputs() []string { return o.Required }
+
+func (o *OutputService) Run(ctx context.Context) error {
+	if o.Value != nil {
+		return Publish(ctx, o.Key, o.Value)
+	}
+	found, err := Lookup[string](ctx, o.Required[0])
+	o.Found = found
+	return err
+}
+
+func (o *OutputService) Rollback(_ context.Context) error { return nil }
+
+func TestOutputsBetweenStages(t *testing.T) {
+	producer := &OutputService{Key: "claim.id", Value: "claim-1"}
+	consumer := &OutputService{Required: []string{"claim.id"}}
+	if _, errs, err := CallStagedServices(context.TODO(), [][]Service{{producer}, {consumer}}, CallServicesOpts{}); err != nil {
+		t.Fatalf("Expected the output to be passed to the next stage, got %v %v\n", err, errs)
+	}
+	if consumer.Found != "claim-1" {
+		t.Errorf("Expected claim-1, got %q\n", consumer.Found)
+	}
+
+	// A missing output fails the Check, before anything runs
+	missing := &OutputService{Required: []string{"claim.id", "claim.owner"}}
+	_, errs, err := CallStagedServices(context.TODO(), [][]Service{{missing}}, CallServicesOpts{})
+	if err != ErrCheckFailed || errs[0] == nil || errs[0].Error() != "missing outputs: claim.id, claim.owner" {
+		t.Errorf("Expected the Check to fail with the missing outputs, got %v %v\n", err, errs)
+	}
+}
+
+func TestLookupTypeMismatch(t *testing.T) {
+	ctx := WithOutputs(context.TODO())
+	_ = Publish(ctx, "count", 3)
+
+	if count, err := Lookup[int](ctx, "count"); err != nil || count != 3 {
+		t.Errorf("Expected 3, got %v %v\n", count, err)
+	}
+	if _, err := Lookup[string](ctx, "count"); err == nil || err.Error() != "output count has type int, expected string" {
+		t.Errorf("Expected a type mismatch, got %v\n", err)
+	}
+	if _, err := Lookup[fmt.Stringer](ctx, "count"); err == nil || err.Error() != "output count has type int, expected fmt.Stringer" {
+		t.Errorf("Expected a type mismatch with an interface, got %v\n", err)
+	}
+	if _, err := Lookup[int](ctx, "missing"); err == nil || err.Error() != "missing output: missing" {
+		t.Errorf("Expected a missing output, got %v\n", err)
+	}
+	if err := Publish(context.TODO(), "count", 3); err == nil {
+		t.Errorf("Expected Publish to fail without Outputs in the context\n")
+	}
+}
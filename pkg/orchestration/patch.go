@@ -0,0 +1,156 @@
+package orchestration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+)
+
+type PatchType string
+
+const (
+	PATCH_MERGE PatchType = "application/merge-patch+json" // JSON Merge Patch (RFC 7396)
+	PATCH_JSON  PatchType = "application/json-patch+json"  // JSON Patch (RFC 6902)
+)
+
+// Patcher can be implemented by a RestApi that supports partial updates, which is required for REST_API_PATCH
+type Patcher interface {
+	Patch(ctx context.Context, name string, patchType PatchType, patch []byte) (interface{}, error)
+}
+
+// RestApiPatchAsService converts a partial update of the object with name to a Service. Check stores a backup of the
+// object, Verify checks that the patch is applied (merge patches only), and Rollback applies the reverse patch.
+func RestApiPatchAsService(api RestApi, apiName, name string, patchType PatchType, patch []byte) Service {
+	svc := RestApiAsService(api, REST_API_PATCH, apiName, name, nil).(*RestApiService)
+	svc.PatchType = patchType
+	svc.Patch = patch
+	return svc
+}
+
+// reversePatch returns a patch of patchType that restores the members of backup that are touched by patch, given
+// the current state of the object. It returns nil when there is nothing to restore.
+func reversePatch(patchType PatchType, patch []byte, backup, current Nameable) ([]byte, error) {
+	backupDoc, err := toJSONObject(backup)
+	if err != nil {
+		return nil, err
+	}
+
+	if patchType == PATCH_JSON {
+		currentDoc, err := toJSONObject(current)
+		if err != nil {
+			return nil, err
+		}
+		var operations []struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+			From string `json:"from"`
+		}
+		if err := json.Unmarshal(patch, &operations); err != nil {
+			return nil, err
+		}
+		reverse := []map[string]any{}
+		restored := map[string]bool{}
+		for _, operation := range operations {
+			touched := []string{operation.Path}
+			switch operation.Op {
+			case "test":
+				continue // Does not modify the object
+			case "move":
+				touched = append(touched, operation.From)
+			}
+			for _, path := range touched {
+				member, ok := topLevelMember(path)
+				if !ok || restored[member] {
+					continue
+				}
+				restored[member] = true
+				// Restoring whole top-level members keeps the reverse patch valid regardless of array positions
+				pointer := "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(member)
+				want, inBackup := backupDoc[member]
+				got, inCurrent := currentDoc[member]
+				if inBackup && (!inCurrent || !reflect.DeepEqual(want, got)) {
+					reverse = append(reverse, map[string]any{"op": "add", "path": pointer, "value": want})
+				} else if !inBackup && inCurrent {
+					reverse = append(reverse, map[string]any{"op": "remove", "path": pointer})
+				}
+			}
+		}
+		if len(reverse) == 0 {
+			return nil, nil
+		}
+		return json.Marshal(reverse)
+	}
+
+	var mergePatch map[string]any
+	if err := json.Unmarshal(patch, &mergePatch); err != nil {
+		return nil, errors.New("merge patch must be a JSON object: " + err.Error())
+	}
+	return json.Marshal(reverseMergePatch(mergePatch, backupDoc))
+}
+
+// reverseMergePatch returns the merge patch that restores the values of backup for every member in patch
+func reverseMergePatch(patch, backup map[string]any) map[string]any {
+	reverse := map[string]any{}
+	for key, value := range patch {
+		old, ok := backup[key]
+		if !ok {
+			reverse[key] = nil // Removes a member that was added by the patch
+			continue
+		}
+		patchObject, isObject := value.(map[string]any)
+		oldObject, wasObject := old.(map[string]any)
+		if isObject && wasObject {
+			reverse[key] = reverseMergePatch(patchObject, oldObject)
+		} else {
+			reverse[key] = old
+		}
+	}
+	return reverse
+}
+
+// applyMergePatch applies a JSON Merge Patch to target, as described by RFC 7396
+func applyMergePatch(target, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = map[string]any{}
+	}
+	result := map[string]any{}
+	for key, value := range targetObject {
+		result[key] = value
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(result, key)
+		} else {
+			result[key] = applyMergePatch(result[key], value)
+		}
+	}
+	return result
+}
+
+// topLevelMember returns the unescaped first reference token of a JSON Pointer
+func topLevelMember(pointer string) (string, bool) {
+	if !strings.HasPrefix(pointer, "/") {
+		return "", false
+	}
+	member := strings.SplitN(pointer[1:], "/", 2)[0]
+	return strings.NewReplacer("~1", "/", "~0", "~").Replace(member), true
+}
+
+func toJSONObject(obj Nameable) (map[string]any, error) {
+	object := map[string]any{}
+	if obj == nil {
+		return object, nil
+	}
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	return object, json.Unmarshal(raw, &object)
+}
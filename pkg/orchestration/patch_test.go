@@ -0,0 +1,66 @@
+package orchestration
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type Document map[string]any
+
+func (d Document) Name() string { return "document" }
+
+func TestPatchRollback(t *testing.T) {
+	server := newClaimServer()
+	defer server.Close()
+	api := &HTTPRestApi{BaseURL: server.URL, Resource: "claims", NewObject: func() Nameable { return &Claim{} }}
+	ctx := context.TODO()
+	_, _ = api.Post(ctx, Claim{ClaimName: "a", MemoryInMb: 128})
+
+	svc := RestApiPatchAsService(api, "Resize a", "a", PATCH_MERGE, []byte(`{"memory_in_mb": 512}`))
+	if errs, err := CallServices(ctx, []Service{svc}, CallServicesOpts{}); err != nil {
+		t.Fatalf("Expected the patch to succeed, got %v %v\n", err, errs)
+	}
+	if got, _ := api.Get(ctx, "a"); *got.(*Claim) != (Claim{ClaimName: "a", MemoryInMb: 512}) {
+		t.Errorf("Expected the claim to be patched, got %v\n", got)
+	}
+
+	if err := svc.Rollback(ctx); err != nil {
+		t.Fatalf("Expected the rollback to succeed, got %v\n", err)
+	}
+	if got, _ := api.Get(ctx, "a"); *got.(*Claim) != (Claim{ClaimName: "a", MemoryInMb: 128}) {
+		t.Errorf("Expected the claim to be restored, got %v\n", got)
+	}
+}
+
+func TestReversePatch(t *testing.T) {
+	backup := Document{"size": 1.0, "labels": map[string]any{"team": "a", "env": "prd"}, "tags": []any{"x"}}
+	current := Document{"size": 2.0, "labels": map[string]any{"team": "b", "env": "prd"}, "tags": []any{"x", "y"}, "new": true}
+
+	cases := []struct {
+		patchType PatchType
+		patch     string
+		expected  string
+	}{
+		{PATCH_MERGE, `{"size": 2, "labels": {"team": "b"}, "new": true}`,
+			`{"labels": {"team": "a"}, "new": null, "size": 1}`},
+		{PATCH_JSON, `[{"op": "replace", "path": "/labels/team", "value": "b"}, {"op": "add", "path": "/tags/-", "value": "y"}, {"op": "add", "path": "/new", "value": true}, {"op": "test", "path": "/size", "value": 2}]`,
+			`[{"op": "add", "path": "/labels", "value": {"team": "a", "env": "prd"}}, {"op": "add", "path": "/tags", "value": ["x"]}, {"op": "remove", "path": "/new"}]`},
+		{PATCH_JSON, `[{"op": "move", "from": "/size", "path": "/moved"}, {"op": "copy", "from": "/tags", "path": "/copied"}]`,
+			`[{"op": "add", "path": "/size", "value": 1}]`},
+	}
+	for _, c := range cases {
+		reverse, err := reversePatch(c.patchType, []byte(c.patch), backup, current)
+		var got, expected any
+		_ = json.Unmarshal(reverse, &got)
+		_ = json.Unmarshal([]byte(c.expected), &expected)
+		if err != nil || !reflect.DeepEqual(got, expected) {
+			t.Errorf("Expected the reverse of %s to be %s, got %s %v\n", c.patch, c.expected, reverse, err)
+		}
+	}
+
+	if reverse, err := reversePatch(PATCH_JSON, []byte(`[{"op": "add", "path": "/size", "value": 1}]`), backup, backup); reverse != nil || err != nil {
+		t.Errorf("Expected no reverse patch without changes, got %s %v\n", reverse, err)
+	}
+}
@@ -1,12 +1,18 @@
 package orchestration
 
 import (
+	"errors"
 	"net/http"
+	"time"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/redact"
 )
 
 type Response struct {
 	Status  string           `json:"status"`
 	Details []ResponseDetail `json:"details"`
+
+	RetryAfter time.Duration `json:"-"` // Set when an AdmissionError rejected the call, see SetRetryAfter
 }
 
 type ResponseDetail struct {
@@ -33,13 +39,24 @@ func generateResponseContainer(err error) (int, *Response) {
 	status := http.StatusOK
 
 	if err != nil {
-		response.Status = err.Error()
+		response.Status = redact.String(err.Error())
 		status = http.StatusInternalServerError
+		var statusCoder StatusCoder
+		var admissionErr *AdmissionError
+		if errors.As(err, &admissionErr) {
+			response.RetryAfter = admissionErr.RetryAfter
+		}
+		if errors.Is(err, ErrShuttingDown) {
+			status = http.StatusServiceUnavailable
+		} else if errors.As(err, &statusCoder) {
+			status = statusCoder.StatusCode()
+		}
 	}
 
 	return status, response
 }
 
+// GenerateResponse returns the responses of all services, redacted with redact.Default since they leave the process
 func GenerateResponse(services []Service, errs []error, err error) (int, *Response) {
 	status, response := generateResponseContainer(err)
 
@@ -48,7 +65,7 @@ func GenerateResponse(services []Service, errs []error, err error) (int, *Respon
 		if detail != nil {
 			response.Details = append(response.Details, ResponseDetail{
 				Name:   service.Name(),
-				Detail: detail,
+				Detail: redact.Value(detail),
 			})
 		}
 	}
@@ -56,13 +73,14 @@ func GenerateResponse(services []Service, errs []error, err error) (int, *Respon
 	return status, response
 }
 
+// GenerateStagedResponse returns the responses of the failed stage, or of all stages when all of them ran. The latter
+// is the case for a StagedCall that was cancelled during its last stage.
 func GenerateStagedResponse(stages [][]Service, failedStageIndex int, errs []error, err error) (int, *Response) {
-	if err != nil {
+	if err != nil && failedStageIndex < len(stages) {
 		return GenerateResponse(stages[failedStageIndex], errs, err)
 	}
 
-	response := &Response{Status: "ok"}
-	status := http.StatusOK
+	status, response := generateResponseContainer(err)
 	for _, stage := range stages {
 		_, stageResponse := GenerateResponse(stage, make([]error, len(stage)), nil)
 		response.Details = append(response.Details, stageResponse.Details...)
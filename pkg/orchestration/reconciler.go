@@ -0,0 +1,323 @@
+package orchestration
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/redact"
+)
+
+type RepairPolicy string
+
+const (
+	REPAIR_RECOVER RepairPolicy = "RECOVER" // Drift is repaired with Recover
+	REPAIR_RUN     RepairPolicy = "RUN"     // Drift is repaired with Run
+	REPAIR_NONE    RepairPolicy = "NONE"    // Drift is only reported
+)
+
+type ResourceState string
+
+const (
+	RESOURCE_PENDING ResourceState = "PENDING" // Not checked yet
+	RESOURCE_IN_SYNC ResourceState = "IN_SYNC"
+	RESOURCE_DRIFTED ResourceState = "DRIFTED" // Check failed, and the drift is not repaired (yet)
+)
+
+type ReconcileEventType string
+
+const (
+	EVENT_DRIFT_DETECTED ReconcileEventType = "DRIFT_DETECTED"
+	EVENT_REPAIRED       ReconcileEventType = "REPAIRED"
+	EVENT_REPAIR_FAILED  ReconcileEventType = "REPAIR_FAILED"
+	EVENT_RESOLVED       ReconcileEventType = "RESOLVED" // The drift disappeared without a repair
+)
+
+// ResourceStatus is the last known state of a Service that is reconciled
+type ResourceStatus struct {
+	Name         string        `json:"name"`
+	Policy       RepairPolicy  `json:"policy"`
+	State        ResourceState `json:"state"`
+	LastChecked  time.Time     `json:"lastChecked"`
+	LastRepaired time.Time     `json:"lastRepaired,omitempty"`
+	NextCheck    time.Time     `json:"nextCheck"`
+	Attempts     int           `json:"attempts"` // Failed repairs since the resource was last in sync
+	LastError    string        `json:"lastError,omitempty"`
+}
+
+// ReconcileEvent is emitted when the state of a resource changes, or a repair is attempted
+type ReconcileEvent struct {
+	Time     time.Time          `json:"time"`
+	Resource string             `json:"resource"`
+	Type     ReconcileEventType `json:"type"`
+	Message  string             `json:"message,omitempty"`
+}
+
+// Reconciler repeatedly runs Check on desired-state Services, and repairs the ones that drifted with Recover or Run,
+// depending on their RepairPolicy. The Check of such a Service returns nil when the resource is in its desired state,
+// and an error that describes the drift otherwise. A repair is followed by another Check, failed repairs are retried
+// with Backoff. For example, to restore quotas that were changed by hand:
+//
+//	reconciler := NewReconciler(nil)
+//	_ = reconciler.Add(quotaService, REPAIR_RUN)
+//	reconciler.OnEvent = func(event ReconcileEvent) { ... }
+//	go reconciler.Start(ctx)
+//
+// The same Service is used for every reconcile, so its Check, Recover and Run must be repeatable.
+type Reconciler struct {
+	Clock      Clock
+	Interval   time.Duration                   // Time between Checks of a resource that is in sync, default one minute
+	Backoff    func(attempt int) time.Duration // Delay before the next repair, given the failed repairs so far
+	EventsSize int                             // Events kept, default 100
+	OnEvent    func(event ReconcileEvent)      // Optional, called synchronously for every event, without holding a lock
+
+	reconciling sync.Mutex // Serializes ReconcileOnce, so a resource is never repaired concurrently
+	lock        sync.Mutex
+	resources   map[string]*reconciledResource
+	events      []ReconcileEvent
+	wake        chan struct{}
+}
+
+type reconciledResource struct {
+	service Service
+	status  ResourceStatus
+}
+
+var ErrResourceExists = errors.New("resource already exists")
+
+// NewReconciler returns a Reconciler with clock, nil uses the system time
+func NewReconciler(clock Clock) *Reconciler {
+	if clock == nil {
+		clock = realClock{}
+	}
+	return &Reconciler{
+		Clock:     clock,
+		Interval:  time.Minute,
+		Backoff:   ExponentialBackoff(5*time.Second, 5*time.Minute),
+		resources: map[string]*reconciledResource{},
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// Add registers service to be reconciled with policy, it is checked as soon as possible
+func (r *Reconciler) Add(service Service, policy RepairPolicy) error {
+	if policy == "" {
+		policy = REPAIR_RECOVER
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	name := service.Name()
+	if _, ok := r.resources[name]; ok {
+		return ErrResourceExists
+	}
+	r.resources[name] = &reconciledResource{
+		service: service,
+		status:  ResourceStatus{Name: name, Policy: policy, State: RESOURCE_PENDING, NextCheck: r.Clock.Now()},
+	}
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Remove stops reconciling the resource with name
+func (r *Reconciler) Remove(name string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.resources, name)
+}
+
+// Status returns the status of all resources, sorted by name
+func (r *Reconciler) Status() []ResourceStatus {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	statuses := []ResourceStatus{}
+	for _, resource := range r.resources {
+		statuses = append(statuses, resource.status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// Events returns the most recent events, oldest first
+func (r *Reconciler) Events() []ReconcileEvent {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]ReconcileEvent{}, r.events...)
+}
+
+// Start reconciles every resource when its NextCheck is due, until ctx is done
+func (r *Reconciler) Start(ctx context.Context) {
+	for {
+		r.ReconcileOnce(ctx)
+
+		wait := r.interval()
+		now := r.Clock.Now()
+		r.lock.Lock()
+		for _, resource := range r.resources {
+			if until := resource.status.NextCheck.Sub(now); until < wait {
+				wait = until
+			}
+		}
+		r.lock.Unlock()
+
+		select {
+		case <-r.Clock.After(wait):
+		case <-r.wake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// ReconcileOnce checks all resources of which the NextCheck is due, and repairs the ones that drifted. Checks and
+// repairs of different resources run concurrently.
+func (r *Reconciler) ReconcileOnce(ctx context.Context) {
+	r.reconciling.Lock()
+	defer r.reconciling.Unlock()
+	ctx = WithOutputs(ctx)
+	now := r.Clock.Now()
+	r.lock.Lock()
+	due := []*reconciledResource{}
+	for _, resource := range r.resources {
+		if !resource.status.NextCheck.After(now) {
+			due = append(due, resource)
+		}
+	}
+	r.lock.Unlock()
+	if len(due) == 0 {
+		return
+	}
+
+	errs := RunServiceAction(ctx, servicesOf(due), SERVICE_CHECK)
+	drifted := map[RepairPolicy][]*reconciledResource{}
+	for i, resource := range due {
+		if errs[i] == nil {
+			r.inSync(resource, false)
+			continue
+		}
+		r.drifted(resource, errs[i])
+		drifted[resource.status.Policy] = append(drifted[resource.status.Policy], resource)
+	}
+
+	repaired := []*reconciledResource{}
+	for policy, resources := range drifted {
+		if policy == REPAIR_NONE {
+			continue
+		}
+		action := SERVICE_RECOVER
+		if policy == REPAIR_RUN {
+			action = SERVICE_RUN
+		}
+		errs := RunServiceAction(ctx, servicesOf(resources), action)
+		for i, resource := range resources {
+			if errs[i] != nil {
+				r.repairFailed(resource, errs[i])
+			} else {
+				repaired = append(repaired, resource)
+			}
+		}
+	}
+	if len(repaired) == 0 {
+		return
+	}
+
+	errs = RunServiceAction(ctx, servicesOf(repaired), SERVICE_CHECK)
+	for i, resource := range repaired {
+		if errs[i] != nil {
+			r.repairFailed(resource, errors.New("still drifted after repair: "+errs[i].Error()))
+		} else {
+			r.inSync(resource, true)
+		}
+	}
+}
+
+// inSync updates the status of a resource of which the Check passed
+func (r *Reconciler) inSync(resource *reconciledResource, repaired bool) {
+	r.update(func(now time.Time) []ReconcileEvent {
+		status := &resource.status
+		var events []ReconcileEvent
+		if repaired {
+			status.LastRepaired = now
+			events = append(events, ReconcileEvent{Time: now, Resource: status.Name, Type: EVENT_REPAIRED})
+		} else if status.State == RESOURCE_DRIFTED {
+			events = append(events, ReconcileEvent{Time: now, Resource: status.Name, Type: EVENT_RESOLVED})
+		}
+		status.State = RESOURCE_IN_SYNC
+		status.LastChecked = now
+		status.NextCheck = now.Add(r.interval())
+		status.Attempts = 0
+		status.LastError = ""
+		return events
+	})
+}
+
+// drifted updates the status of a resource of which the Check failed, before it is repaired
+func (r *Reconciler) drifted(resource *reconciledResource, err error) {
+	r.update(func(now time.Time) []ReconcileEvent {
+		status := &resource.status
+		message := redact.String(err.Error())
+		var events []ReconcileEvent
+		if status.State != RESOURCE_DRIFTED {
+			events = append(events, ReconcileEvent{Time: now, Resource: status.Name, Type: EVENT_DRIFT_DETECTED, Message: message})
+		}
+		status.State = RESOURCE_DRIFTED
+		status.LastChecked = now
+		status.NextCheck = now.Add(r.interval())
+		status.LastError = message
+		return events
+	})
+}
+
+// repairFailed updates the status of a resource of which the repair failed, and schedules the next one
+func (r *Reconciler) repairFailed(resource *reconciledResource, err error) {
+	r.update(func(now time.Time) []ReconcileEvent {
+		status := &resource.status
+		status.Attempts++
+		status.LastError = redact.String(err.Error())
+		status.NextCheck = now.Add(r.Backoff(status.Attempts))
+		redact.Logf("[Reconciler]: Repair of %s failed (attempt %d): %s\n", status.Name, status.Attempts, status.LastError)
+		return []ReconcileEvent{{Time: now, Resource: status.Name, Type: EVENT_REPAIR_FAILED, Message: status.LastError}}
+	})
+}
+
+// update calls change with the lock held, adds the events it returns to the history, and calls OnEvent for them after
+// the lock is released, so OnEvent can use Status and Events
+func (r *Reconciler) update(change func(now time.Time) []ReconcileEvent) {
+	r.lock.Lock()
+	events := change(r.Clock.Now())
+	size := r.EventsSize
+	if size <= 0 {
+		size = 100
+	}
+	r.events = append(r.events, events...)
+	if len(r.events) > size {
+		r.events = r.events[len(r.events)-size:]
+	}
+	r.lock.Unlock()
+
+	if r.OnEvent != nil {
+		for _, event := range events {
+			r.OnEvent(event)
+		}
+	}
+}
+
+// interval returns the Interval, or one minute when it is not set
+func (r *Reconciler) interval() time.Duration {
+	if r.Interval <= 0 {
+		return time.Minute
+	}
+	return r.Interval
+}
+
+func servicesOf(resources []*reconciledResource) []Service {
+	services := make([]Service, len(resources))
+	for i, resource := range resources {
+		services[i] = resource.service
+	}
+	return services
+}
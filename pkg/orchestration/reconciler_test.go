@@ -0,0 +1,105 @@
+package orchestration_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration"
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration/orchestrationtest"
+)
+
+func TestReconcilerRepairsDrift(t *testing.T) {
+	clock := orchestrationtest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	recorder := orchestrationtest.NewRecorder()
+	drift := errors.New("quota changed by hand")
+
+	inSync := recorder.Fake("in sync")
+	repairable := recorder.Fake("repairable").On(orchestration.SERVICE_CHECK, orchestrationtest.Behavior{Err: drift, Times: 1})
+	broken := recorder.Fake("broken").FailOn(orchestration.SERVICE_CHECK, drift).
+		FailOn(orchestration.SERVICE_RECOVER, errors.New("no access"))
+	reported := recorder.Fake("reported").FailOn(orchestration.SERVICE_CHECK, drift)
+
+	reconciler := orchestration.NewReconciler(clock)
+	reconciler.Backoff = orchestration.ExponentialBackoff(5*time.Second, time.Minute)
+	_ = reconciler.Add(inSync, orchestration.REPAIR_RUN)
+	_ = reconciler.Add(repairable, orchestration.REPAIR_RUN)
+	_ = reconciler.Add(broken, orchestration.REPAIR_RECOVER)
+	_ = reconciler.Add(reported, orchestration.REPAIR_NONE)
+	if err := reconciler.Add(inSync, orchestration.REPAIR_RUN); !errors.Is(err, orchestration.ErrResourceExists) {
+		t.Errorf("Expected ErrResourceExists, got %v\n", err)
+	}
+
+	reconciler.ReconcileOnce(context.TODO())
+	orchestrationtest.AssertActions(t, recorder, "in sync", orchestration.SERVICE_CHECK)
+	orchestrationtest.AssertActions(t, recorder, "repairable", orchestration.SERVICE_CHECK, orchestration.SERVICE_RUN, orchestration.SERVICE_CHECK)
+	orchestrationtest.AssertActions(t, recorder, "broken", orchestration.SERVICE_CHECK, orchestration.SERVICE_RECOVER)
+	orchestrationtest.AssertActions(t, recorder, "reported", orchestration.SERVICE_CHECK)
+
+	expected := map[string]orchestration.ResourceState{
+		"in sync":    orchestration.RESOURCE_IN_SYNC,
+		"repairable": orchestration.RESOURCE_IN_SYNC,
+		"broken":     orchestration.RESOURCE_DRIFTED,
+		"reported":   orchestration.RESOURCE_DRIFTED,
+	}
+	for _, status := range reconciler.Status() {
+		if status.State != expected[status.Name] {
+			t.Errorf("Expected %s to be %s, got %+v\n", status.Name, expected[status.Name], status)
+		}
+	}
+
+	types := map[string][]orchestration.ReconcileEventType{}
+	for _, event := range reconciler.Events() {
+		types[event.Resource] = append(types[event.Resource], event.Type)
+	}
+	if len(types["in sync"]) != 0 ||
+		len(types["repairable"]) != 2 || types["repairable"][1] != orchestration.EVENT_REPAIRED ||
+		len(types["broken"]) != 2 || types["broken"][1] != orchestration.EVENT_REPAIR_FAILED ||
+		len(types["reported"]) != 1 || types["reported"][0] != orchestration.EVENT_DRIFT_DETECTED {
+		t.Errorf("Unexpected events: %v\n", types)
+	}
+
+	// Only the failed repair is due after its backoff, and backs off further
+	recorder.Reset()
+	clock.Advance(5 * time.Second)
+	reconciler.ReconcileOnce(context.TODO())
+	if events := recorder.Events(); len(events) != 2 {
+		t.Errorf("Expected only the broken resource to be retried, got %v\n", recorder)
+	}
+	for _, status := range reconciler.Status() {
+		if status.Name == "broken" && (status.Attempts != 2 || status.NextCheck != clock.Now().Add(10*time.Second)) {
+			t.Errorf("Expected a second attempt with backoff, got %+v\n", status)
+		}
+	}
+}
+
+func TestReconcilerOnEventCanReadStatus(t *testing.T) {
+	clock := orchestrationtest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	recorder := orchestrationtest.NewRecorder()
+	reconciler := orchestration.NewReconciler(clock)
+	reconciler.Interval = 0
+	_ = reconciler.Add(recorder.Fake("drifted").FailOn(orchestration.SERVICE_CHECK, errors.New("drift")), orchestration.REPAIR_NONE)
+
+	states := []orchestration.ResourceState{}
+	reconciler.OnEvent = func(event orchestration.ReconcileEvent) {
+		states = append(states, reconciler.Status()[0].State)
+		_ = reconciler.Events()
+	}
+	done := make(chan struct{})
+	go func() {
+		reconciler.ReconcileOnce(context.TODO())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected OnEvent to be able to call Status and Events\n")
+	}
+	if len(states) != 1 || states[0] != orchestration.RESOURCE_DRIFTED {
+		t.Errorf("Expected the status to be updated before OnEvent, got %v\n", states)
+	}
+	if status := reconciler.Status()[0]; status.NextCheck != clock.Now().Add(time.Minute) {
+		t.Errorf("Expected the default interval when Interval is 0, got %+v\n", status)
+	}
+}
@@ -0,0 +1,472 @@
+package orchestration
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/redact"
+	"github.com/ing-bank/orchestration-pkg/pkg/task"
+)
+
+// PersistentService is a Service that can be stored in a RollbackStore. The ServiceType is used to look up the
+// ServiceFactory that rebuilds the Service from the data returned by MarshalService.
+type PersistentService interface {
+	Service
+
+	ServiceType() string
+	MarshalService() ([]byte, error)
+}
+
+// ServiceFactory rebuilds a Service of serviceType from data produced by PersistentService.MarshalService. It is
+// implemented by spec.Registry, so the factories of a spec also rebuild queued Services (see spec.MarshalDefinition).
+type ServiceFactory interface {
+	NewService(serviceType string, data []byte) (Service, error)
+}
+
+// RollbackEntry is a failed rollback that is waiting to be retried
+type RollbackEntry struct {
+	ID          string    `json:"id"`
+	ServiceName string    `json:"service_name"`
+	ServiceType string    `json:"service_type"`
+	ServiceData []byte    `json:"service_data"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"last_error"`
+	CreatedAt   time.Time `json:"created_at"`
+	NextAttempt time.Time `json:"next_attempt"`
+	DeadLetter  bool      `json:"dead_letter"`
+}
+
+// RollbackStore persists RollbackEntry items, Save is expected to insert or overwrite by ID
+type RollbackStore interface {
+	Save(entry RollbackEntry) error
+	Delete(id string) error
+	List() ([]RollbackEntry, error)
+}
+
+var _ RollbackStore = &MemoryRollbackStore{}
+var _ RollbackStore = &FileRollbackStore{}
+
+// MemoryRollbackStore keeps entries in memory, mainly useful for testing since entries are lost on restart
+type MemoryRollbackStore struct {
+	lock    sync.Mutex
+	entries map[string]RollbackEntry
+}
+
+func (s *MemoryRollbackStore) Save(entry RollbackEntry) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.entries == nil {
+		s.entries = map[string]RollbackEntry{}
+	}
+	s.entries[entry.ID] = entry
+	return nil
+}
+
+func (s *MemoryRollbackStore) Delete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.entries, id)
+	return nil
+}
+
+func (s *MemoryRollbackStore) List() ([]RollbackEntry, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	entries := []RollbackEntry{}
+	for _, entry := range s.entries {
+		entries = append(entries, entry)
+	}
+	sortRollbackEntries(entries)
+	return entries, nil
+}
+
+// FileRollbackStore keeps all entries in a single JSON file. Every change rewrites the file atomically, which is
+// fine for the small amount of failed rollbacks we expect.
+type FileRollbackStore struct {
+	Path string
+
+	lock sync.Mutex
+}
+
+func (s *FileRollbackStore) Save(entry RollbackEntry) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	entries, err := s.load()
+	if err != nil {
+		return err
+	}
+	entries[entry.ID] = entry
+	return writeJSONFile(s.Path, entries)
+}
+
+func (s *FileRollbackStore) Delete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	entries, err := s.load()
+	if err != nil {
+		return err
+	}
+	delete(entries, id)
+	return writeJSONFile(s.Path, entries)
+}
+
+func (s *FileRollbackStore) List() ([]RollbackEntry, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	stored, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	entries := []RollbackEntry{}
+	for _, entry := range stored {
+		entries = append(entries, entry)
+	}
+	sortRollbackEntries(entries)
+	return entries, nil
+}
+
+func (s *FileRollbackStore) load() (map[string]RollbackEntry, error) {
+	entries := map[string]RollbackEntry{}
+	if err := readJSONFile(s.Path, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// RollbackQueue retries failed rollbacks in the background. Entries are moved to the dead-letter list after
+// MaxAttempts, where they stay until they are retried or dropped by hand. To feed the queue, use the Reporter as
+// RollbackErrorReporter:
+//
+//	queue := NewRollbackQueue(&FileRollbackStore{Path: "/var/lib/orchestration/rollbacks.json"})
+//	queue.Factory = spec.DefaultRegistry
+//	RollbackErrorReporter = queue.Reporter()
+//	go queue.Start(ctx)
+type RollbackQueue struct {
+	Store          RollbackStore
+	MaxAttempts    int                             // Attempts before an entry becomes a dead letter, including the original rollback
+	Backoff        func(attempt int) time.Duration // Delay before the next attempt, given the number of attempts so far
+	PollInterval   time.Duration                   // How often Start looks for due entries
+	AttemptTimeout time.Duration                   // Maximum duration of a single retry, default one minute
+	Factory        ServiceFactory                  // Rebuilds the Services of the entries, e.g. a spec.Registry
+
+	lock    sync.Mutex      // Guards claimed, it is not held while a rollback runs
+	claimed map[string]bool // IDs of entries that are being retried or dropped, so that never happens concurrently
+}
+
+var errRollbackClaimed = errors.New("rollback entry is already being retried")
+
+func NewRollbackQueue(store RollbackStore) *RollbackQueue {
+	return &RollbackQueue{
+		Store:          store,
+		MaxAttempts:    5,
+		Backoff:        ExponentialBackoff(time.Second, 5*time.Minute),
+		PollInterval:   10 * time.Second,
+		AttemptTimeout: time.Minute,
+	}
+}
+
+// ExponentialBackoff doubles the base delay for every attempt, up to max
+func ExponentialBackoff(base, max time.Duration) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		delay := base
+		for i := 1; i < attempt && delay < max; i++ {
+			delay *= 2
+		}
+		if delay > max {
+			return max
+		}
+		return delay
+	}
+}
+
+// Enqueue stores a failed rollback, the service must be a PersistentService or wrap one. The rollback of a wrapped
+// Service is retried without the wrapper, e.g. without the faults of a chaos.ChaosService.
+func (q *RollbackQueue) Enqueue(service Service, rollbackErr error) error {
+	persistent, ok := persistentOf(service)
+	if !ok {
+		return errors.New("cannot queue rollback of " + service.Name() + ": not a PersistentService")
+	}
+	data, err := persistent.MarshalService()
+	if err != nil {
+		return err
+	}
+
+	entry := RollbackEntry{
+		ID:          newID(),
+		ServiceName: persistent.Name(),
+		ServiceType: persistent.ServiceType(),
+		ServiceData: data,
+		Attempts:    1, // The original rollback
+		CreatedAt:   time.Now(),
+	}
+	q.fail(&entry, rollbackErr)
+	return q.Store.Save(entry)
+}
+
+// Reporter returns a function that can be assigned to RollbackErrorReporter, which queues every failed rollback
+func (q *RollbackQueue) Reporter() func(context.Context, []Service, []error) {
+	return func(_ context.Context, services []Service, errs []error) {
+		for i := 0; i < len(services); i++ {
+			if errs[i] == nil {
+				continue
+			}
+			if err := q.Enqueue(services[i], errs[i]); err != nil {
+				redact.Logf("[RollbackQueue]: Rollback failed for Service %s and was not queued: %v (%v)\n",
+					services[i].Name(), errs[i], err)
+			}
+		}
+	}
+}
+
+// Start processes due entries every PollInterval until ctx is done
+func (q *RollbackQueue) Start(ctx context.Context) {
+	ticker := time.NewTicker(q.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := q.ProcessDue(ctx); err != nil {
+			redact.Logf("[RollbackQueue]: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessDue retries every entry for which the backoff has passed, entries that are already being retried are skipped.
+// A store error of one entry does not stop the others from being retried, all of them are returned together.
+func (q *RollbackQueue) ProcessDue(ctx context.Context) error {
+	entries, err := q.Store.List()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var storeErrs []string
+	for _, listed := range entries {
+		if listed.DeadLetter || listed.NextAttempt.After(now) || q.claim(listed.ID) != nil {
+			continue
+		}
+		// Reloaded after the claim, since it may have been retried or dropped after it was listed
+		entry, found, err := q.find(listed.ID)
+		if err == nil && found && !entry.DeadLetter && !entry.NextAttempt.After(now) {
+			err = q.retry(ctx, entry)
+		}
+		q.release(listed.ID)
+		if err != nil {
+			storeErrs = append(storeErrs, listed.ServiceName+": "+err.Error())
+		}
+	}
+	if len(storeErrs) > 0 {
+		return errors.New("storing the retries failed for:\n  " + strings.Join(storeErrs, "\n  "))
+	}
+	return nil
+}
+
+// List returns all entries that are waiting for a retry
+func (q *RollbackQueue) List() ([]RollbackEntry, error) {
+	return q.filter(false)
+}
+
+// DeadLetters returns all entries that have run out of attempts
+func (q *RollbackQueue) DeadLetters() ([]RollbackEntry, error) {
+	return q.filter(true)
+}
+
+// Retry immediately retries the entry with id, regardless of its backoff. Dead letters get one more attempt.
+// The returned error is the rollback error, if any.
+func (q *RollbackQueue) Retry(ctx context.Context, id string) error {
+	if err := q.claim(id); err != nil {
+		return err
+	}
+	defer q.release(id)
+
+	entry, err := q.get(id)
+	if err != nil {
+		return err
+	}
+	if err := q.retry(ctx, entry); err != nil {
+		return err
+	}
+	if _, err := q.get(id); err == nil {
+		return errors.New("rollback of " + entry.ServiceName + " failed again")
+	}
+	return nil
+}
+
+// Drop removes an entry without retrying it, which fails while the entry is being retried
+func (q *RollbackQueue) Drop(id string) error {
+	if err := q.claim(id); err != nil {
+		return err
+	}
+	defer q.release(id)
+
+	if _, err := q.get(id); err != nil {
+		return err
+	}
+	return q.Store.Delete(id)
+}
+
+// retry runs the rollback of a claimed entry once, within the AttemptTimeout, and either deletes or updates it. Only
+// store errors are returned.
+func (q *RollbackQueue) retry(ctx context.Context, entry RollbackEntry) error {
+	var rollbackErr error
+	if q.Factory == nil {
+		rollbackErr = errors.New("no ServiceFactory to rebuild type " + entry.ServiceType)
+	} else if service, err := q.Factory.NewService(entry.ServiceType, entry.ServiceData); err != nil {
+		rollbackErr = err
+	} else {
+		attemptCtx, cancel := context.WithTimeout(ctx, q.attemptTimeout())
+		rollbackErr = task.Run([]task.Runnable{ProtoService{service: service, action: SERVICE_ROLLBACK}}, attemptCtx)[0]
+		cancel()
+	}
+
+	if rollbackErr == nil {
+		return q.Store.Delete(entry.ID)
+	}
+	entry.Attempts++
+	q.fail(&entry, rollbackErr)
+	return q.Store.Save(entry)
+}
+
+func (q *RollbackQueue) fail(entry *RollbackEntry, err error) {
+	entry.LastError = redact.String(err.Error())
+	entry.NextAttempt = time.Now().Add(q.Backoff(entry.Attempts))
+	entry.DeadLetter = entry.Attempts >= q.MaxAttempts
+	if entry.DeadLetter {
+		redact.Logf("[RollbackQueue]: Rollback of %s moved to dead letters after %d attempts: %s\n",
+			entry.ServiceName, entry.Attempts, entry.LastError)
+	}
+}
+
+// claim marks the entry with id as in use, it returns errRollbackClaimed when it already is
+func (q *RollbackQueue) claim(id string) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.claimed[id] {
+		return errRollbackClaimed
+	}
+	if q.claimed == nil {
+		q.claimed = map[string]bool{}
+	}
+	q.claimed[id] = true
+	return nil
+}
+
+func (q *RollbackQueue) release(id string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	delete(q.claimed, id)
+}
+
+func (q *RollbackQueue) attemptTimeout() time.Duration {
+	if q.AttemptTimeout > 0 {
+		return q.AttemptTimeout
+	}
+	return time.Minute
+}
+
+func (q *RollbackQueue) get(id string) (RollbackEntry, error) {
+	entry, found, err := q.find(id)
+	if err == nil && !found {
+		err = errors.New("rollback entry " + id + " not found")
+	}
+	return entry, err
+}
+
+func (q *RollbackQueue) find(id string) (RollbackEntry, bool, error) {
+	entries, err := q.Store.List()
+	if err != nil {
+		return RollbackEntry{}, false, err
+	}
+	for _, entry := range entries {
+		if entry.ID == id {
+			return entry, true, nil
+		}
+	}
+	return RollbackEntry{}, false, nil
+}
+
+func (q *RollbackQueue) filter(deadLetter bool) ([]RollbackEntry, error) {
+	entries, err := q.Store.List()
+	if err != nil {
+		return nil, err
+	}
+	filtered := []RollbackEntry{}
+	for _, entry := range entries {
+		if entry.DeadLetter == deadLetter {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered, nil
+}
+
+func sortRollbackEntries(entries []RollbackEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+}
+
+// newID returns a random identifier, used for entries that are stored or referred to by an API
+func newID() string {
+	raw := make([]byte, 8)
+	_, _ = rand.Read(raw)
+	return hex.EncodeToString(raw)
+}
+
+// persistentOf returns service as a PersistentService, or the first Service it wraps that is one
+func persistentOf(service Service) (PersistentService, bool) {
+	for service != nil {
+		if persistent, ok := service.(PersistentService); ok {
+			return persistent, true
+		}
+		wrapper, ok := service.(ServiceWrapper)
+		if !ok {
+			break
+		}
+		service = wrapper.Unwrap()
+	}
+	return nil, false
+}
+
+// readJSONFile unmarshals the file at path into v, a missing file leaves v untouched
+func readJSONFile(path string, v any) error {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// writeJSONFile replaces the file at path with the JSON representation of v, through a rename so readers never
+// see a partially written file
+func writeJSONFile(path string, v any) error {
+	raw, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,179 @@
+package orchestration
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Struct definition required to satisfy the PersistentService interface. Rollback fails
+// until Failures reaches zero, and blocks until ctx is done when Hang is set.
+type FlakyRollbackService struct {
+	SimpleService
+	Id       string
+	Failures *int // Shared by the Services that the queue rebuilds
+	Hang     bool
+}
+
+func (f *FlakyRollbackService) Name() string { return "Flaky " + f.Id }
+
+func (f *FlakyRollbackService) Run(_ context.Context) error { return nil }
+
+func (f *FlakyRollbackService) Rollback(ctx context.Context) error {
+	if f.Hang {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if *f.Failures > 0 {
+		*f.Failures--
+		return errors.New("still failing")
+	}
+	return nil
+}
+
+func (f *FlakyRollbackService) ServiceType() string { return "flaky" }
+
+func (f *FlakyRollbackService) MarshalService() ([]byte, error) { return []byte(f.Id), nil }
+
+// flakyFactory rebuilds FlakyRollbackServices that share the number of Failures
+type flakyFactory struct {
+	failures *int
+}
+
+func (f flakyFactory) NewService(serviceType string, data []byte) (Service, error) {
+	if serviceType != "flaky" {
+		return nil, errors.New("unknown type " + serviceType)
+	}
+	return &FlakyRollbackService{Id: string(data), Failures: f.failures, Hang: string(data) == "hang"}, nil
+}
+
+// newFlakyQueue returns a queue of which the rebuilt Services fail failures times in total, and hang when their Id is
+// "hang"
+func newFlakyQueue(store RollbackStore, failures int) *RollbackQueue {
+	queue := NewRollbackQueue(store)
+	queue.MaxAttempts = 3
+	queue.Backoff = func(_ int) time.Duration { return 0 }
+	queue.Factory = flakyFactory{failures: &failures}
+	return queue
+}
+
+func TestRollbackQueueRetriesUntilSuccess(t *testing.T) {
+	queue := newFlakyQueue(&FileRollbackStore{Path: filepath.Join(t.TempDir(), "rollbacks.json")}, 1)
+	queue.Reporter()(context.TODO(), []Service{&FlakyRollbackService{Id: "a"}}, []error{errors.New("failed")})
+
+	if entries, _ := queue.List(); len(entries) != 1 || entries[0].ServiceName != "Flaky a" {
+		t.Fatalf("Expected one queued entry for \"Flaky a\", got %v\n", entries)
+	}
+	if err := queue.ProcessDue(context.TODO()); err != nil {
+		t.Fatalf("Expected no store errors, got %v\n", err)
+	}
+	if entries, _ := queue.List(); len(entries) != 1 || entries[0].Attempts != 2 {
+		t.Fatalf("Expected entry to be retried once and kept, got %v\n", entries)
+	}
+	_ = queue.ProcessDue(context.TODO())
+	if entries, _ := queue.List(); len(entries) != 0 {
+		t.Errorf("Expected queue to be empty after successful retry, got %v\n", entries)
+	}
+}
+
+func TestRollbackQueueDeadLetters(t *testing.T) {
+	queue := newFlakyQueue(&MemoryRollbackStore{}, 2)
+	_ = queue.Enqueue(&FlakyRollbackService{Id: "b"}, errors.New("failed"))
+	_ = queue.ProcessDue(context.TODO())
+	_ = queue.ProcessDue(context.TODO())
+	_ = queue.ProcessDue(context.TODO()) // Dead letters are no longer processed
+
+	dead, _ := queue.DeadLetters()
+	if len(dead) != 1 || dead[0].Attempts != 3 {
+		t.Fatalf("Expected one dead letter after 3 attempts, got %v\n", dead)
+	}
+
+	if err := queue.Retry(context.TODO(), dead[0].ID); err != nil {
+		t.Errorf("Expected manual retry to succeed, got %v\n", err)
+	}
+	if err := queue.Drop(dead[0].ID); err == nil {
+		t.Errorf("Expected drop of a finished entry to fail\n")
+	}
+}
+
+func TestRollbackQueueTimesOutAttempts(t *testing.T) {
+	queue := newFlakyQueue(&MemoryRollbackStore{}, 0)
+	queue.AttemptTimeout = 10 * time.Millisecond
+	_ = queue.Enqueue(&FlakyRollbackService{Id: "hang"}, errors.New("failed"))
+	entries, _ := queue.List()
+
+	done := make(chan error)
+	go func() { done <- queue.ProcessDue(context.TODO()) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Expected no store errors, got %v\n", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the attempt to time out\n")
+	}
+	if entries, _ := queue.List(); len(entries) != 1 || entries[0].Attempts != 2 {
+		t.Errorf("Expected the timed out attempt to count, got %v\n", entries)
+	}
+
+	// An entry that is being retried cannot be retried or dropped concurrently
+	_ = queue.claim(entries[0].ID)
+	if err := queue.Drop(entries[0].ID); !errors.Is(err, errRollbackClaimed) {
+		t.Errorf("Expected the drop to fail during a retry, got %v\n", err)
+	}
+	queue.release(entries[0].ID)
+	if err := queue.Drop(entries[0].ID); err != nil {
+		t.Errorf("Expected the drop to succeed, got %v\n", err)
+	}
+}
+
+func TestRollbackQueueRejectsNonPersistentServices(t *testing.T) {
+	queue := newFlakyQueue(&MemoryRollbackStore{}, 0)
+	if err := queue.Enqueue(&DryRunService{Wrapper: &OutputService{}}, errors.New("failed")); err == nil {
+		t.Errorf("Expected an error when queueing a Service that cannot be persisted\n")
+	}
+}
+
+func TestRollbackQueueUnwrapsServices(t *testing.T) {
+	queue := newFlakyQueue(&MemoryRollbackStore{}, 0)
+	if err := queue.Enqueue(When(nil, &FlakyRollbackService{Id: "c"}), errors.New("failed")); err != nil {
+		t.Fatalf("Expected the wrapped PersistentService to be queued, got %v\n", err)
+	}
+	if entries, _ := queue.List(); len(entries) != 1 || entries[0].ServiceName != "Flaky c" || entries[0].ServiceType != "flaky" {
+		t.Errorf("Expected an entry of the wrapped Service, got %v\n", entries)
+	}
+}
+
+// Struct definition required to satisfy the RollbackStore interface, Delete fails for the entries of Service
+// "Flaky broken"
+type BrokenDeleteStore struct {
+	MemoryRollbackStore
+}
+
+func (s *BrokenDeleteStore) Delete(id string) error {
+	entries, _ := s.List()
+	for _, entry := range entries {
+		if entry.ID == id && entry.ServiceName == "Flaky broken" {
+			return errors.New("disk full")
+		}
+	}
+	return s.MemoryRollbackStore.Delete(id)
+}
+
+func TestRollbackQueueContinuesAfterStoreErrors(t *testing.T) {
+	queue := newFlakyQueue(&BrokenDeleteStore{}, 0)
+	for _, id := range []string{"broken", "d", "e"} {
+		_ = queue.Enqueue(&FlakyRollbackService{Id: id}, errors.New("failed"))
+	}
+
+	err := queue.ProcessDue(context.TODO())
+	if err == nil || !strings.Contains(err.Error(), "Flaky broken: disk full") {
+		t.Errorf("Expected the store error of the broken entry, got %v\n", err)
+	}
+	if entries, _ := queue.List(); len(entries) != 1 || entries[0].ServiceName != "Flaky broken" {
+		t.Errorf("Expected the other entries to be retried, got %v\n", entries)
+	}
+}
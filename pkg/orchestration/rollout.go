@@ -0,0 +1,173 @@
+package orchestration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/task"
+)
+
+var _ Service = &RolloutGroup{}
+
+var ErrNotRolledOut = errors.New("not rolled out, an earlier wave failed")
+
+// RolloutStrategy describes how the Services of a RolloutGroup are run: optionally a canary first, then in waves.
+// Without WaveSize or WavePercent all remaining Services are run in a single wave.
+type RolloutStrategy struct {
+	Canary      bool                                           // Run the first Service on its own before the waves
+	WaveSize    int                                            // Services per wave
+	WavePercent int                                            // Alternative to WaveSize, percentage of all Services per wave (rounded up)
+	Pause       time.Duration                                  // Wait between waves
+	HealthCheck func(ctx context.Context, ran []Service) error // Optional, called after every wave with all Services that ran
+}
+
+// RolloutGroup is a Service that rolls out its Services according to a RolloutStrategy, so a bad payload does not
+// break every target at once. All Services are checked together, but the Runs are done wave by wave. When a wave
+// fails, or the HealthCheck after it fails, all waves that have run are rolled back in reversed order and the
+// remaining waves are not run. The same happens when ctx is done during a Pause. For example, a stage with a canary
+// followed by waves of 25%:
+//
+//	stage := []Service{
+//	    Rollout("MyService Create", RolloutStrategy{Canary: true, WavePercent: 25, Pause: time.Minute}, services),
+//	}
+type RolloutGroup struct {
+	GroupName string
+	Services  []Service
+	Strategy  RolloutStrategy
+
+	lock  sync.Mutex  // Guards the fields below, not held during the actions of the Services
+	errs  []error     // Errors of the Services in the last action, used by GetResponse
+	waves [][]Service // Waves that ran, and thus need a Rollback
+}
+
+func Rollout(name string, strategy RolloutStrategy, services []Service) Service {
+	return &RolloutGroup{GroupName: name, Services: services, Strategy: strategy}
+}
+
+func (g *RolloutGroup) Name() string {
+	return g.GroupName
+}
+
+func (g *RolloutGroup) Check(ctx context.Context) error {
+	errs := RunServiceAction(ctx, g.Services, SERVICE_CHECK)
+	g.lock.Lock()
+	g.errs = errs
+	g.lock.Unlock()
+	if task.AnyError(errs) {
+		return ErrCheckFailed
+	}
+	return nil
+}
+
+// Recover calls Recover on the Services that failed their Check
+func (g *RolloutGroup) Recover(ctx context.Context) error {
+	g.lock.Lock()
+	errs := g.errs
+	g.lock.Unlock()
+	return recoverFailed(ctx, g.Services, errs)
+}
+
+func (g *RolloutGroup) Run(ctx context.Context) error {
+	errs := fillErrors(ErrNotRolledOut, len(g.Services))
+	g.lock.Lock()
+	g.errs = errs
+	g.waves = nil
+	g.lock.Unlock()
+	rollbackCtx := detachedContext{ctx} // The waves that ran are rolled back, also when ctx is done
+
+	start := 0
+	for i, size := range g.Strategy.waveSizes(len(g.Services)) {
+		if i > 0 && g.Strategy.Pause > 0 {
+			select {
+			case <-time.After(g.Strategy.Pause):
+			case <-ctx.Done():
+				g.rollbackWaves(rollbackCtx)
+				return fmt.Errorf("rollout interrupted before wave %d: %w", i+1, ctx.Err())
+			}
+		}
+
+		wave := g.Services[start : start+size]
+		waveErrs := RunServiceAction(ctx, wave, SERVICE_RUN)
+		g.lock.Lock()
+		errs = append(append(append([]error{}, errs[:start]...), waveErrs...), errs[start+size:]...)
+		g.errs = errs
+		g.waves = append(g.waves, wave)
+		g.lock.Unlock()
+		start += size
+
+		if task.AnyError(errs[:start]) {
+			g.rollbackWaves(rollbackCtx)
+			return ErrRunFailed
+		}
+		if g.Strategy.HealthCheck != nil {
+			if err := g.Strategy.HealthCheck(ctx, g.Services[:start]); err != nil {
+				g.rollbackWaves(rollbackCtx)
+				return fmt.Errorf("health check failed after wave %d: %w", i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Verify verifies the Services that implement Verifier
+func (g *RolloutGroup) Verify(ctx context.Context) error {
+	return verifyAll(ctx, g.Services)
+}
+
+func (g *RolloutGroup) Rollback(ctx context.Context) error {
+	if task.AnyError(g.rollbackWaves(ctx)) {
+		return ErrRollbackFailed
+	}
+	return nil
+}
+
+func (g *RolloutGroup) GetResponse(err error) any {
+	g.lock.Lock()
+	errs := g.errs
+	g.lock.Unlock()
+	_, response := GenerateResponse(g.Services, alignErrors(errs, len(g.Services)), err)
+	return response
+}
+
+// rollbackWaves rolls back all waves that ran in reversed order, and returns all rollback errors
+func (g *RolloutGroup) rollbackWaves(ctx context.Context) []error {
+	g.lock.Lock()
+	waves := g.waves
+	g.waves = nil // Claimed by this rollback, a concurrent Rollback has nothing left to undo
+	g.lock.Unlock()
+
+	var errs []error
+	for j := len(waves) - 1; j >= 0; j-- {
+		errs = append(errs, RunServiceAction(ctx, waves[j], SERVICE_ROLLBACK)...)
+	}
+	return errs
+}
+
+// waveSizes returns the number of Services in each wave, for n Services in total
+func (s RolloutStrategy) waveSizes(n int) []int {
+	var sizes []int
+	remaining := n
+	if s.Canary && remaining > 0 {
+		sizes = append(sizes, 1)
+		remaining--
+	}
+
+	size := s.WaveSize
+	if s.WavePercent > 0 {
+		size = (n*s.WavePercent + 99) / 100
+	}
+	if size <= 0 {
+		size = remaining
+	}
+	for remaining > 0 {
+		if size > remaining {
+			size = remaining
+		}
+		sizes = append(sizes, size)
+		remaining -= size
+	}
+	return sizes
+}
@@ -0,0 +1,49 @@
+package orchestration_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration"
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration/orchestrationtest"
+)
+
+func TestRolloutRollsBackWavesWhenAWaveFails(t *testing.T) {
+	recorder := orchestrationtest.NewRecorder()
+	a, b, c, d := recorder.Fake("a"), recorder.Fake("b"), recorder.Fake("c").FailOn(orchestration.SERVICE_RUN, errors.New("boom")), recorder.Fake("d")
+	group := orchestration.Rollout("rollout", orchestration.RolloutStrategy{WaveSize: 1}, []orchestration.Service{a, b, c, d})
+
+	_, err := orchestration.CallServices(context.TODO(), []orchestration.Service{group}, orchestration.CallServicesOpts{})
+	orchestration.Wait()
+
+	if !errors.Is(err, orchestration.ErrRunFailed) {
+		t.Errorf("Expected ErrRunFailed, got %v\n", err)
+	}
+	orchestrationtest.AssertRolledBack(t, recorder, "a", "b", "c")
+	orchestrationtest.AssertActions(t, recorder, "d", orchestration.SERVICE_CHECK)
+	orchestrationtest.AssertRolledBackInReverseStageOrder(t, recorder, [][]orchestration.Service{{a}, {b}, {c}, {d}})
+}
+
+func TestRolloutRollsBackWavesWhenTheHealthCheckFails(t *testing.T) {
+	recorder := orchestrationtest.NewRecorder()
+	a, b, c := recorder.Fake("a"), recorder.Fake("b"), recorder.Fake("c")
+	errUnhealthy := errors.New("error rate too high")
+	strategy := orchestration.RolloutStrategy{Canary: true, WaveSize: 1, HealthCheck: func(_ context.Context, ran []orchestration.Service) error {
+		if len(ran) == 2 {
+			return errUnhealthy
+		}
+		return nil
+	}}
+	group := orchestration.Rollout("rollout", strategy, []orchestration.Service{a, b, c})
+
+	errs, err := orchestration.CallServices(context.TODO(), []orchestration.Service{group}, orchestration.CallServicesOpts{})
+	orchestration.Wait()
+
+	if !errors.Is(err, orchestration.ErrRunFailed) || !errors.Is(errs[0], errUnhealthy) {
+		t.Errorf("Expected the health check error of the group, got %v %v\n", errs, err)
+	}
+	orchestrationtest.AssertRolledBack(t, recorder, "a", "b")
+	orchestrationtest.AssertActions(t, recorder, "c", orchestration.SERVICE_CHECK)
+	orchestrationtest.AssertRolledBackInReverseStageOrder(t, recorder, [][]orchestration.Service{{a}, {b}, {c}})
+}
@@ -0,0 +1,64 @@
+package orchestration
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Struct definition required to satisfy the Service interface, Rollback records the error of its context
+type RolloutService struct {
+	SimpleService
+	Id          string
+	RolledBack  bool
+	RollbackErr error
+}
+
+func (r *RolloutService) Name() string { return "Rollout " + r.Id }
+
+func (r *RolloutService) Run(_ context.Context) error { return nil }
+
+func (r *RolloutService) Rollback(ctx context.Context) error {
+	r.RolledBack = true
+	r.RollbackErr = ctx.Err()
+	return r.RollbackErr
+}
+
+func TestWaveSizes(t *testing.T) {
+	for _, test := range []struct {
+		strategy RolloutStrategy
+		n        int
+		expected []int
+	}{
+		{RolloutStrategy{}, 5, []int{5}},
+		{RolloutStrategy{WaveSize: 2}, 5, []int{2, 2, 1}},
+		{RolloutStrategy{WavePercent: 25}, 10, []int{3, 3, 3, 1}}, // 2.5 is rounded up
+		{RolloutStrategy{WavePercent: 50}, 3, []int{2, 1}},
+		{RolloutStrategy{Canary: true, WavePercent: 50}, 4, []int{1, 2, 1}},
+		{RolloutStrategy{Canary: true}, 1, []int{1}},
+		{RolloutStrategy{Canary: true, WaveSize: 2}, 0, nil},
+	} {
+		if sizes := test.strategy.waveSizes(test.n); !reflect.DeepEqual(sizes, test.expected) {
+			t.Errorf("Expected %v for %d Services with %+v, got %v\n", test.expected, test.n, test.strategy, sizes)
+		}
+	}
+}
+
+func TestRolloutRollsBackAfterCancel(t *testing.T) {
+	canary, other := &RolloutService{Id: "canary"}, &RolloutService{Id: "other"}
+	group := &RolloutGroup{GroupName: "rollout", Services: []Service{canary, other}, Strategy: RolloutStrategy{Canary: true, Pause: time.Hour}}
+	ctx, cancel := context.WithTimeout(context.TODO(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := group.Run(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the rollout to be interrupted by the deadline, got %v\n", err)
+	}
+	if !canary.RolledBack || canary.RollbackErr != nil {
+		t.Errorf("Expected the canary to be rolled back with a live context, got %v\n", canary.RollbackErr)
+	}
+	if other.RolledBack {
+		t.Errorf("Expected the Service that did not run not to be rolled back\n")
+	}
+}
@@ -0,0 +1,300 @@
+package orchestration
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/redact"
+)
+
+// Clock is the source of time of the Scheduler, replace it in tests (see orchestrationtest.FakeClock)
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+type MissedRunPolicy string
+
+const (
+	MISSED_SKIP     MissedRunPolicy = "SKIP"     // Missed runs are skipped, and recorded in the history
+	MISSED_RUN_ONCE MissedRunPolicy = "RUN_ONCE" // Missed runs are coalesced into a single run, as soon as possible
+)
+
+// StageBuilder builds the stages of a single run of a Job. Services keep state, so a new set is needed for every run.
+type StageBuilder func(ctx context.Context) ([][]Service, error)
+
+// ServiceBuilder returns a StageBuilder for a Job with a single stage
+func ServiceBuilder(build func(ctx context.Context) ([]Service, error)) StageBuilder {
+	return func(ctx context.Context) ([][]Service, error) {
+		services, err := build(ctx)
+		return [][]Service{services}, err
+	}
+}
+
+// Job is an orchestration that runs on a Schedule. A Job never overlaps with itself: a run that is due while the
+// previous one is still running, or that is more than MissedAfter late, is missed and handled by the MissedRuns
+// policy.
+type Job struct {
+	Name        string
+	Schedule    Schedule // See Every and ParseCron
+	Build       StageBuilder
+	Opts        CallServicesOpts
+	Timeout     time.Duration   // Optional maximum duration of a run
+	Jitter      time.Duration   // Optional random delay up to Jitter, so Jobs on the same Schedule do not start at once
+	MissedRuns  MissedRunPolicy // Default MISSED_SKIP
+	MissedAfter time.Duration   // Lateness after which a run is missed, default one minute
+}
+
+// JobRun is the outcome of a single run of a Job
+type JobRun struct {
+	Job        string    `json:"job"`
+	Scheduled  time.Time `json:"scheduled"`
+	Started    time.Time `json:"started"`
+	Finished   time.Time `json:"finished"`
+	NStagesRun int       `json:"nStagesRun"`
+	Error      string    `json:"error,omitempty"`    // Redacted with redact.Default
+	Response   *Response `json:"response,omitempty"` // Of a failed run, with the failing Services (see GenerateStagedResponse)
+	Skipped    string    `json:"skipped,omitempty"`  // Reason the run was skipped
+}
+
+// Scheduler runs Jobs on their Schedule, and keeps a history of their runs. For example, a nightly cleanup:
+//
+//	scheduler := NewScheduler(nil)
+//	schedule, _ := ParseCron("0 3 * * *")
+//	_ = scheduler.Add(Job{Name: "quota cleanup", Schedule: schedule, Build: buildCleanupStages, Jitter: time.Minute})
+//	go scheduler.Start(ctx)
+type Scheduler struct {
+	Clock       Clock
+	HistorySize int // Runs kept per Job, default 100
+
+	lock    sync.Mutex
+	jobs    map[string]*jobState
+	started context.Context // Set by Start, Jobs added afterwards start immediately
+	random  *rand.Rand
+}
+
+type jobState struct {
+	job     Job
+	cancel  context.CancelFunc
+	running bool
+	pending *time.Time // Scheduled time of a missed run that runs after the current one, for MISSED_RUN_ONCE
+	history []JobRun
+}
+
+var ErrJobExists = errors.New("job already exists")
+
+// NewScheduler returns a Scheduler with clock, nil uses the system time
+func NewScheduler(clock Clock) *Scheduler {
+	if clock == nil {
+		clock = realClock{}
+	}
+	return &Scheduler{
+		Clock:  clock,
+		jobs:   map[string]*jobState{},
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Add registers job, it is started immediately when the Scheduler was started
+func (s *Scheduler) Add(job Job) error {
+	if job.Name == "" || job.Schedule == nil || job.Build == nil {
+		return errors.New("job requires a Name, Schedule and Build")
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.jobs[job.Name]; ok {
+		return ErrJobExists
+	}
+	state := &jobState{job: job}
+	s.jobs[job.Name] = state
+	if s.started != nil {
+		s.startJob(s.started, state)
+	}
+	return nil
+}
+
+// Remove stops scheduling the Job with name, a running run is not interrupted
+func (s *Scheduler) Remove(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if state, ok := s.jobs[name]; ok {
+		if state.cancel != nil {
+			state.cancel()
+		}
+		delete(s.jobs, name)
+	}
+}
+
+// Start schedules all Jobs until ctx is done, and then returns. Runs that are still running are not interrupted, use
+// Wait or Shutdown to wait for them.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.lock.Lock()
+	s.started = ctx
+	for _, state := range s.jobs {
+		s.startJob(ctx, state)
+	}
+	s.lock.Unlock()
+
+	<-ctx.Done()
+	s.lock.Lock()
+	s.started = nil
+	s.lock.Unlock()
+}
+
+// RunNow starts a run of the Job with name, unless it is already running
+func (s *Scheduler) RunNow(ctx context.Context, name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	state, ok := s.jobs[name]
+	if !ok {
+		return errors.New("unknown job: " + name)
+	}
+	if state.running {
+		return errors.New("job is already running: " + name)
+	}
+	s.run(ctx, state, s.Clock.Now())
+	return nil
+}
+
+// History returns the runs of the Job with name, oldest first
+func (s *Scheduler) History(name string) []JobRun {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if state, ok := s.jobs[name]; ok {
+		return append([]JobRun{}, state.history...)
+	}
+	return nil
+}
+
+// startJob starts the loop of a single Job, the lock must be held
+func (s *Scheduler) startJob(ctx context.Context, state *jobState) {
+	ctx, state.cancel = context.WithCancel(ctx)
+	go s.loop(ctx, state)
+}
+
+func (s *Scheduler) loop(ctx context.Context, state *jobState) {
+	job := state.job
+	next := job.Schedule.Next(s.Clock.Now())
+	for !next.IsZero() {
+		s.lock.Lock()
+		wait := next.Sub(s.Clock.Now())
+		if job.Jitter > 0 {
+			wait += time.Duration(s.random.Int63n(int64(job.Jitter)))
+		}
+		s.lock.Unlock()
+
+		select {
+		case <-s.Clock.After(wait):
+		case <-ctx.Done():
+			return
+		}
+
+		// Every slot up to now is due, including slots that passed while waiting, e.g. after the clock jumped. The next
+		// slot follows from the scheduled time rather than from now, so Jitter and late wake-ups do not shift it.
+		now := s.Clock.Now()
+		for !next.IsZero() && !next.After(now) {
+			s.due(ctx, state, next, now)
+			next = job.Schedule.Next(next)
+		}
+		s.lock.Lock()
+		s.runPending(ctx, state)
+		s.lock.Unlock()
+	}
+}
+
+// due handles a run that was scheduled at scheduled, and is due at now
+func (s *Scheduler) due(ctx context.Context, state *jobState, scheduled, now time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	reason := ""
+	if state.running {
+		reason = "previous run still running"
+	} else if now.Sub(scheduled) > state.job.missedAfter()+state.job.Jitter {
+		reason = "started too late"
+	}
+	if reason == "" {
+		state.pending = nil // A run that is on time also covers the runs missed before it
+		s.run(ctx, state, scheduled)
+		return
+	}
+
+	if state.job.MissedRuns == MISSED_RUN_ONCE {
+		state.pending = &scheduled // Coalesced with earlier missed runs, started by runPending
+		return
+	}
+	s.record(state, JobRun{Job: state.job.Name, Scheduled: scheduled, Skipped: reason})
+}
+
+// runPending starts the coalesced missed run, unless a run is still running. The lock must be held.
+func (s *Scheduler) runPending(ctx context.Context, state *jobState) {
+	if pending := state.pending; pending != nil && !state.running && ctx.Err() == nil {
+		state.pending = nil
+		s.run(ctx, state, *pending)
+	}
+}
+
+// run starts a run in the background, the lock must be held
+func (s *Scheduler) run(ctx context.Context, state *jobState, scheduled time.Time) {
+	state.running = true
+	job := state.job
+	goBackground(func() {
+		run := JobRun{Job: job.Name, Scheduled: scheduled, Started: s.Clock.Now()}
+		var runCtx context.Context = detachedContext{ctx} // Stopping the Scheduler does not interrupt the run
+		cancel := context.CancelFunc(func() {})
+		if job.Timeout > 0 {
+			runCtx, cancel = context.WithTimeout(runCtx, job.Timeout)
+		}
+		stages, err := job.Build(runCtx)
+		if err == nil {
+			var errs []error
+			run.NStagesRun, errs, err = CallStagedServices(runCtx, stages, job.Opts)
+			if err != nil {
+				_, run.Response = GenerateStagedResponse(stages, run.NStagesRun, errs, err)
+			}
+		}
+		cancel()
+		if err != nil {
+			run.Error = redact.String(err.Error())
+		}
+		run.Finished = s.Clock.Now()
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.record(state, run)
+		state.running = false
+		s.runPending(ctx, state)
+	})
+}
+
+// record adds run to the history, the lock must be held
+func (s *Scheduler) record(state *jobState, run JobRun) {
+	size := s.HistorySize
+	if size <= 0 {
+		size = 100
+	}
+	state.history = append(state.history, run)
+	if len(state.history) > size {
+		state.history = state.history[len(state.history)-size:]
+	}
+}
+
+func (j Job) missedAfter() time.Duration {
+	if j.MissedAfter > 0 {
+		return j.MissedAfter
+	}
+	return time.Minute
+}
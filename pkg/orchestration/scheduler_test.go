@@ -0,0 +1,173 @@
+package orchestration_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration"
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration/orchestrationtest"
+)
+
+func TestSchedulerPreventsOverlappingRuns(t *testing.T) {
+	for _, policy := range []orchestration.MissedRunPolicy{orchestration.MISSED_SKIP, orchestration.MISSED_RUN_ONCE} {
+		start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		clock := orchestrationtest.NewFakeClock(start)
+		recorder := orchestrationtest.NewRecorder()
+		release := make(chan struct{})
+		builds := 0
+
+		scheduler := orchestration.NewScheduler(clock)
+		_ = scheduler.Add(orchestration.Job{
+			Name:       "cleanup",
+			Schedule:   orchestration.Every(time.Hour),
+			MissedRuns: policy,
+			Build: orchestration.ServiceBuilder(func(_ context.Context) ([]orchestration.Service, error) {
+				builds++
+				fake := recorder.Fake(fmt.Sprintf("cleanup %d", builds)).On(orchestration.SERVICE_RUN, orchestrationtest.Behavior{Block: release})
+				return []orchestration.Service{fake}, nil
+			}),
+		})
+		ctx, cancel := context.WithCancel(context.TODO())
+		go scheduler.Start(ctx)
+
+		clock.BlockUntil(1)
+		clock.Advance(time.Hour) // First run starts, and blocks
+		clock.BlockUntil(1)
+		clock.Advance(time.Hour) // Due while the first run is still running
+		clock.BlockUntil(1)
+		close(release)
+		orchestration.Wait()
+		cancel()
+
+		history := scheduler.History("cleanup")
+		if len(history) != 2 {
+			t.Fatalf("Expected two entries in the history with %s, got %+v\n", policy, history)
+		}
+		switch policy {
+		case orchestration.MISSED_SKIP:
+			if history[0].Skipped != "previous run still running" || history[1].Error != "" || builds != 1 {
+				t.Errorf("Expected the second run to be skipped, got %+v\n", history)
+			}
+		case orchestration.MISSED_RUN_ONCE:
+			if history[0].Skipped != "" || history[1].Skipped != "" || history[1].Scheduled != start.Add(2*time.Hour) || builds != 2 {
+				t.Errorf("Expected the second run after the first, got %+v\n", history)
+			}
+		}
+		orchestrationtest.AssertActions(t, recorder, "cleanup 1", orchestration.SERVICE_CHECK, orchestration.SERVICE_RUN)
+	}
+}
+
+func TestSchedulerKeepsToTheSchedule(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := orchestrationtest.NewFakeClock(start)
+	recorder := orchestrationtest.NewRecorder()
+	scheduler := orchestration.NewScheduler(clock)
+	_ = scheduler.Add(orchestration.Job{
+		Name:     "cleanup",
+		Schedule: orchestration.Every(time.Hour),
+		Jitter:   10 * time.Minute,
+		Build: orchestration.ServiceBuilder(func(_ context.Context) ([]orchestration.Service, error) {
+			return []orchestration.Service{recorder.Fake("cleanup")}, nil
+		}),
+	})
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	go scheduler.Start(ctx)
+
+	clock.BlockUntil(1)
+	clock.Advance(70 * time.Minute) // Past the first slot and its jitter
+	clock.BlockUntil(1)
+	orchestration.Wait()
+	clock.Advance(time.Hour) // The second slot is scheduled from the first slot, not from the jittered wake-up
+	clock.BlockUntil(1)
+	orchestration.Wait()
+	clock.Advance(3 * time.Hour) // The clock jumps past three slots
+	clock.BlockUntil(1)
+	orchestration.Wait()
+
+	expected := []string{"1h0m0s ran", "2h0m0s ran", "3h0m0s started too late", "4h0m0s started too late", "5h0m0s ran"}
+	history := scheduler.History("cleanup")
+	got := []string{}
+	for _, run := range history {
+		outcome := "ran"
+		if run.Skipped != "" {
+			outcome = run.Skipped
+		}
+		got = append(got, run.Scheduled.Sub(start).String()+" "+outcome)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("Expected the runs %v, got %v\n", expected, got)
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	from := time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC) // A Monday
+	cases := map[string]time.Time{
+		"*/15 * * * *":    time.Date(2024, 1, 1, 10, 45, 0, 0, time.UTC),
+		"0 3 * * *":       time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC),
+		"0 9 * * MON-FRI": time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC),
+		"0 0 1 FEB *":     time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		"0 12 13 * 5":     time.Date(2024, 1, 5, 12, 0, 0, 0, time.UTC), // Day of month or day of week
+		"@hourly":         time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC),
+		"@every 90m":      time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+	for expression, expected := range cases {
+		schedule, err := orchestration.ParseCron(expression)
+		if err != nil {
+			t.Errorf("Expected %q to parse, got %v\n", expression, err)
+			continue
+		}
+		if next := schedule.Next(from); !next.Equal(expected) {
+			t.Errorf("Expected %q to run next at %v, got %v\n", expression, expected, next)
+		}
+	}
+
+	for _, invalid := range []string{"* * * *", "60 * * * *", "* * * FOO *", "*/0 * * * *"} {
+		if _, err := orchestration.ParseCron(invalid); err == nil {
+			t.Errorf("Expected %q to be invalid\n", invalid)
+		}
+	}
+}
+
+func TestSchedulerCoalescesMissedRunsIntoOne(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := orchestrationtest.NewFakeClock(start)
+	recorder := orchestrationtest.NewRecorder()
+	scheduler := orchestration.NewScheduler(clock)
+	_ = scheduler.Add(orchestration.Job{
+		Name:       "cleanup",
+		Schedule:   orchestration.Every(time.Hour),
+		MissedRuns: orchestration.MISSED_RUN_ONCE,
+		Build: orchestration.ServiceBuilder(func(_ context.Context) ([]orchestration.Service, error) {
+			return []orchestration.Service{recorder.Fake("cleanup").FailOn(orchestration.SERVICE_RUN, errors.New("dial db password=hunter2"))}, nil
+		}),
+	})
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	go scheduler.Start(ctx)
+
+	clock.BlockUntil(1)
+	clock.Advance(3*time.Hour + 30*time.Minute) // The clock jumps past three slots, all of them too late
+	clock.BlockUntil(1)
+	orchestration.Wait()
+
+	history := scheduler.History("cleanup")
+	if len(history) != 1 || history[0].Skipped != "" || history[0].Scheduled != start.Add(3*time.Hour) {
+		t.Fatalf("Expected a single run for the last missed slot, got %+v\n", history)
+	}
+	if history[0].Error == "" || strings.Contains(history[0].Error, "hunter2") {
+		t.Errorf("Expected a redacted error, got %q\n", history[0].Error)
+	}
+	response := history[0].Response
+	if response == nil || len(response.Details) != 1 || response.Details[0].Name != "cleanup" {
+		t.Fatalf("Expected the response with the failing Service, got %+v\n", response)
+	}
+	if raw, _ := json.Marshal(response); strings.Contains(string(raw), "hunter2") {
+		t.Errorf("Expected a redacted response, got %s\n", raw)
+	}
+}
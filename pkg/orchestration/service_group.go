@@ -0,0 +1,289 @@
+package orchestration
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/task"
+)
+
+var _ Service = &ServiceGroup{}
+var _ Service = &StagedServiceGroup{}
+
+// ServiceGroup presents a list of Services as one Service, so a group (e.g. quota, network and RBAC for a namespace)
+// can be part of a bigger stage. The children go through each action concurrently. When one of the children fails
+// its Run, the group rolls back its children before returning, so a Rollback of the group only undoes a successful
+// Run. GetResponse returns a nested *Response with the details of every child.
+//
+// When Quorum is set, the group succeeds when at least Quorum children succeed, e.g. for a claim in 3 out of 4
+// datacenters. Children that fail their Check are not run, and children that fail their Run are rolled back on their
+// own. Verify and Rollback only concern the children that ran.
+type ServiceGroup struct {
+	GroupName string
+	Services  []Service
+	Quorum    int // Minimum number of children that must succeed, 0 means all children
+
+	lock      sync.Mutex // Guards the fields below, not held during the actions of the children
+	errs      []error    // Errors of the children in the last action, used by GetResponse
+	checkErrs []error    // Errors of the children in the Check, to skip failed children when there is a Quorum
+	ran       []Service  // Children that ran successfully, and thus need a Rollback
+}
+
+// StagedServiceGroup is the staged counterpart of ServiceGroup, its Run calls the stages one after another. Only the
+// first stage is checked during Check, later stages are checked right before they run since they may depend on the
+// earlier stages.
+type StagedServiceGroup struct {
+	GroupName string
+	Stages    [][]Service
+
+	lock        sync.Mutex // Guards the fields below, not held during the actions of the children
+	errs        []error    // Errors of the children in failedStage, used by GetResponse
+	failedStage int
+	stagesRun   int // Number of stages that ran successfully, and thus need a Rollback
+}
+
+func (g *ServiceGroup) Name() string {
+	return g.GroupName
+}
+
+func (g *ServiceGroup) Check(ctx context.Context) error {
+	errs := RunServiceAction(ctx, g.Services, SERVICE_CHECK)
+	g.lock.Lock()
+	g.errs = errs
+	g.checkErrs = errs
+	g.lock.Unlock()
+	if !g.hasQuorum(errs) {
+		return ErrCheckFailed
+	}
+	return nil
+}
+
+// Recover calls Recover on the children that failed their Check
+func (g *ServiceGroup) Recover(ctx context.Context) error {
+	g.lock.Lock()
+	errs := g.errs
+	g.lock.Unlock()
+	return recoverFailed(ctx, g.Services, errs)
+}
+
+func (g *ServiceGroup) Run(ctx context.Context) error {
+	// Without a Quorum all children passed their Check, otherwise only run the children that did
+	var checked []Service
+	var checkedIndexes []int
+	g.lock.Lock()
+	errs := append([]error{}, alignErrors(g.checkErrs, len(g.Services))...)
+	g.lock.Unlock()
+	for i, service := range g.Services {
+		if g.Quorum == 0 || errs[i] == nil {
+			checked = append(checked, service)
+			checkedIndexes = append(checkedIndexes, i)
+		}
+	}
+
+	var ran, failed []Service
+	for j, err := range RunServiceAction(ctx, checked, SERVICE_RUN) {
+		errs[checkedIndexes[j]] = err
+		if err == nil {
+			ran = append(ran, checked[j])
+		} else {
+			failed = append(failed, checked[j])
+		}
+	}
+
+	g.lock.Lock()
+	g.errs = errs
+	g.lock.Unlock()
+	if !g.hasQuorum(errs) {
+		RunServiceAction(ctx, checked, SERVICE_ROLLBACK) // Errors are handled by the RollbackErrorReporter
+		return ErrRunFailed
+	}
+	if len(failed) > 0 {
+		RunServiceAction(ctx, failed, SERVICE_ROLLBACK) // Quorum reached, only undo the failed children
+	}
+	g.lock.Lock()
+	g.ran = ran
+	g.lock.Unlock()
+	return nil
+}
+
+// Verify verifies the children that implement Verifier. With a Quorum, only the children that ran are verified.
+func (g *ServiceGroup) Verify(ctx context.Context) error {
+	if g.Quorum == 0 {
+		return verifyAll(ctx, g.Services)
+	}
+	g.lock.Lock()
+	ran := g.ran
+	g.lock.Unlock()
+	return verifyAll(ctx, ran)
+}
+
+func (g *ServiceGroup) Rollback(ctx context.Context) error {
+	g.lock.Lock()
+	ran := g.ran
+	g.ran = nil // Claimed by this Rollback, a concurrent Rollback has nothing left to undo
+	g.lock.Unlock()
+	if len(ran) == 0 {
+		return nil // Nothing ran, or the children were rolled back by Run already
+	}
+	if task.AnyError(RunServiceAction(ctx, ran, SERVICE_ROLLBACK)) {
+		return ErrRollbackFailed
+	}
+	return nil
+}
+
+func (g *ServiceGroup) GetResponse(err error) any {
+	g.lock.Lock()
+	errs := g.errs
+	g.lock.Unlock()
+	_, response := GenerateResponse(g.Services, alignErrors(errs, len(g.Services)), err)
+	return response
+}
+
+// hasQuorum returns whether enough children succeeded according to errs
+func (g *ServiceGroup) hasQuorum(errs []error) bool {
+	if g.Quorum == 0 {
+		return !task.AnyError(errs)
+	}
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+		}
+	}
+	return succeeded >= g.Quorum
+}
+
+func (g *StagedServiceGroup) Name() string {
+	return g.GroupName
+}
+
+func (g *StagedServiceGroup) Check(ctx context.Context) error {
+	if len(g.Stages) == 0 {
+		return nil
+	}
+	errs := RunServiceAction(ctx, g.Stages[0], SERVICE_CHECK)
+	g.record(0, errs)
+	if task.AnyError(errs) {
+		return ErrCheckFailed
+	}
+	return nil
+}
+
+// Recover calls Recover on the children of the first stage that failed their Check
+func (g *StagedServiceGroup) Recover(ctx context.Context) error {
+	if len(g.Stages) == 0 {
+		return nil
+	}
+	g.lock.Lock()
+	errs := g.errs
+	g.lock.Unlock()
+	return recoverFailed(ctx, g.Stages[0], errs)
+}
+
+func (g *StagedServiceGroup) Run(ctx context.Context) error {
+	for i := 0; i < len(g.Stages); i++ {
+		if i > 0 { // The first stage was checked by Check
+			errs := RunServiceAction(ctx, g.Stages[i], SERVICE_CHECK)
+			g.record(i, errs)
+			if task.AnyError(errs) {
+				g.rollbackStages(ctx)
+				return ErrCheckFailed
+			}
+		}
+
+		errs := RunServiceAction(ctx, g.Stages[i], SERVICE_RUN)
+		g.record(i, errs)
+		g.lock.Lock()
+		g.stagesRun = i + 1 // On failure, the failed stage is rolled back as well
+		g.lock.Unlock()
+		if task.AnyError(errs) {
+			g.rollbackStages(ctx)
+			return ErrRunFailed
+		}
+	}
+	return nil
+}
+
+// record keeps the errors of the last action of stage for GetResponse
+func (g *StagedServiceGroup) record(stage int, errs []error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.failedStage = stage
+	g.errs = errs
+}
+
+// Verify verifies the children of all stages that implement Verifier
+func (g *StagedServiceGroup) Verify(ctx context.Context) error {
+	var services []Service
+	for _, stage := range g.Stages {
+		services = append(services, stage...)
+	}
+	return verifyAll(ctx, services)
+}
+
+func (g *StagedServiceGroup) Rollback(ctx context.Context) error {
+	if task.AnyError(g.rollbackStages(ctx)) {
+		return ErrRollbackFailed
+	}
+	return nil
+}
+
+func (g *StagedServiceGroup) GetResponse(err error) any {
+	g.lock.Lock()
+	errs, failedStage := g.errs, g.failedStage
+	g.lock.Unlock()
+	if err != nil && len(g.Stages) > 0 {
+		stage := g.Stages[failedStage]
+		_, response := GenerateResponse(stage, alignErrors(errs, len(stage)), err)
+		return response
+	}
+	_, response := GenerateStagedResponse(g.Stages, failedStage, nil, nil)
+	return response
+}
+
+// rollbackStages rolls back all stages that ran in reversed order, and returns all rollback errors
+func (g *StagedServiceGroup) rollbackStages(ctx context.Context) []error {
+	g.lock.Lock()
+	stagesRun := g.stagesRun
+	g.stagesRun = 0 // Claimed by this rollback, a concurrent Rollback has nothing left to undo
+	g.lock.Unlock()
+
+	var errs []error
+	for j := stagesRun - 1; j >= 0; j-- {
+		errs = append(errs, RunServiceAction(ctx, g.Stages[j], SERVICE_ROLLBACK)...)
+	}
+	return errs
+}
+
+// recoverFailed calls Recover on the services that have an error in errs
+func recoverFailed(ctx context.Context, services []Service, errs []error) error {
+	var failed []Service
+	for i, err := range alignErrors(errs, len(services)) {
+		if err != nil {
+			failed = append(failed, services[i])
+		}
+	}
+	if task.AnyError(RunServiceAction(ctx, failed, SERVICE_RECOVER)) {
+		return ErrRecoverFailed
+	}
+	return nil
+}
+
+// verifyAll verifies the services that implement Verifier concurrently
+func verifyAll(ctx context.Context, services []Service) error {
+	if !anyVerifier(services) {
+		return nil
+	}
+	if task.AnyError(RunServiceAction(ctx, services, SERVICE_VERIFY)) {
+		return ErrVerifyFailed
+	}
+	return nil
+}
+
+// alignErrors makes sure errs has an entry for each of n services, e.g. when an action has not been called yet
+func alignErrors(errs []error, n int) []error {
+	if len(errs) == n {
+		return errs
+	}
+	return make([]error, n)
+}
@@ -0,0 +1,136 @@
+package orchestration_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration"
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration/orchestrationtest"
+)
+
+func TestServiceGroupQuorum(t *testing.T) {
+	recorder := orchestrationtest.NewRecorder()
+	group := &orchestration.ServiceGroup{GroupName: "group", Quorum: 2, Services: []orchestration.Service{
+		recorder.Fake("a").Verifying(),
+		recorder.Fake("b"),
+		recorder.Fake("c").FailOn(orchestration.SERVICE_RUN, errors.New("boom")).Verifying(),
+		recorder.Fake("d").FailOn(orchestration.SERVICE_CHECK, errors.New("boom")),
+	}}
+
+	if _, err := orchestration.CallServices(context.TODO(), []orchestration.Service{group}, orchestration.CallServicesOpts{}); err != nil {
+		t.Fatalf("Expected a quorum of 2 out of 4 to succeed, got %v\n", err)
+	}
+	orchestration.Wait()
+	orchestrationtest.AssertActions(t, recorder, "a", orchestration.SERVICE_CHECK, orchestration.SERVICE_RUN, orchestration.SERVICE_VERIFY)
+	orchestrationtest.AssertActions(t, recorder, "c", orchestration.SERVICE_CHECK, orchestration.SERVICE_RUN, orchestration.SERVICE_ROLLBACK)
+	orchestrationtest.AssertActions(t, recorder, "d", orchestration.SERVICE_CHECK)
+
+	recorder.Reset()
+	if err := group.Rollback(context.TODO()); err != nil {
+		t.Fatalf("Expected the rollback to succeed, got %v\n", err)
+	}
+	orchestrationtest.AssertRolledBack(t, recorder, "a", "b")
+	orchestrationtest.AssertNotRolledBack(t, recorder, "c", "d")
+}
+
+func TestServiceGroupQuorumNotReached(t *testing.T) {
+	recorder := orchestrationtest.NewRecorder()
+	group := &orchestration.ServiceGroup{GroupName: "group", Quorum: 2, Services: []orchestration.Service{
+		recorder.Fake("a"),
+		recorder.Fake("b").FailOn(orchestration.SERVICE_RUN, errors.New("boom")),
+		recorder.Fake("c").FailOn(orchestration.SERVICE_CHECK, errors.New("boom")),
+	}}
+
+	_, err := orchestration.CallServices(context.TODO(), []orchestration.Service{group}, orchestration.CallServicesOpts{})
+	orchestration.Wait()
+	if !errors.Is(err, orchestration.ErrRunFailed) {
+		t.Fatalf("Expected ErrRunFailed, got %v\n", err)
+	}
+	orchestrationtest.AssertRolledBack(t, recorder, "a", "b")
+	orchestrationtest.AssertNotRolledBack(t, recorder, "c")
+}
+
+func TestServiceGroupPartialFailure(t *testing.T) {
+	recorder := orchestrationtest.NewRecorder()
+	group := &orchestration.ServiceGroup{GroupName: "group", Services: []orchestration.Service{
+		recorder.Fake("a"),
+		recorder.Fake("b").FailOn(orchestration.SERVICE_RUN, errors.New("boom")),
+	}}
+
+	_, err := orchestration.CallServices(context.TODO(), []orchestration.Service{group}, orchestration.CallServicesOpts{})
+	orchestration.Wait()
+	if !errors.Is(err, orchestration.ErrRunFailed) {
+		t.Fatalf("Expected ErrRunFailed, got %v\n", err)
+	}
+	// The group rolled back its children in Run, the Rollback of the group has nothing left to undo
+	orchestrationtest.AssertActions(t, recorder, "a", orchestration.SERVICE_CHECK, orchestration.SERVICE_RUN, orchestration.SERVICE_ROLLBACK)
+	orchestrationtest.AssertActions(t, recorder, "b", orchestration.SERVICE_CHECK, orchestration.SERVICE_RUN, orchestration.SERVICE_ROLLBACK)
+
+	response := group.GetResponse(err).(*orchestration.Response)
+	if len(response.Details) != 2 || response.Details[1].Detail != "boom" {
+		t.Errorf("Expected the error of \"b\" in the response, got %+v\n", response)
+	}
+}
+
+func TestServiceGroupRollbackOnce(t *testing.T) {
+	recorder := orchestrationtest.NewRecorder()
+	group := &orchestration.ServiceGroup{GroupName: "group", Services: []orchestration.Service{recorder.Fake("a"), recorder.Fake("b")}}
+	if _, err := orchestration.CallServices(context.TODO(), []orchestration.Service{group}, orchestration.CallServicesOpts{}); err != nil {
+		t.Fatalf("Expected the group to succeed, got %v\n", err)
+	}
+
+	// Concurrent Rollbacks undo the children once
+	done := make(chan error)
+	for i := 0; i < 2; i++ {
+		go func() { done <- group.Rollback(context.TODO()) }()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Errorf("Expected the rollback to succeed, got %v\n", err)
+		}
+	}
+	_ = group.GetResponse(nil)
+	orchestrationtest.AssertActions(t, recorder, "a", orchestration.SERVICE_CHECK, orchestration.SERVICE_RUN, orchestration.SERVICE_ROLLBACK)
+}
+
+func TestStagedServiceGroupPartialFailure(t *testing.T) {
+	recorder := orchestrationtest.NewRecorder()
+	stages := [][]orchestration.Service{
+		{recorder.Fake("a1"), recorder.Fake("a2")},
+		{recorder.Fake("b").FailOn(orchestration.SERVICE_RUN, errors.New("boom"))},
+		{recorder.Fake("c")},
+	}
+	group := &orchestration.StagedServiceGroup{GroupName: "group", Stages: stages}
+
+	_, err := orchestration.CallServices(context.TODO(), []orchestration.Service{group}, orchestration.CallServicesOpts{})
+	orchestration.Wait()
+	if !errors.Is(err, orchestration.ErrRunFailed) {
+		t.Fatalf("Expected ErrRunFailed, got %v\n", err)
+	}
+	orchestrationtest.AssertActions(t, recorder, "a1", orchestration.SERVICE_CHECK, orchestration.SERVICE_RUN, orchestration.SERVICE_ROLLBACK)
+	orchestrationtest.AssertActions(t, recorder, "b", orchestration.SERVICE_CHECK, orchestration.SERVICE_RUN, orchestration.SERVICE_ROLLBACK)
+	orchestrationtest.AssertActions(t, recorder, "c")
+	orchestrationtest.AssertRolledBackInReverseStageOrder(t, recorder, stages)
+
+	response := group.GetResponse(err).(*orchestration.Response)
+	if len(response.Details) != 1 || response.Details[0].Name != "b" {
+		t.Errorf("Expected the response of the failed stage, got %+v\n", response)
+	}
+}
+
+func TestStagedServiceGroupRollback(t *testing.T) {
+	recorder := orchestrationtest.NewRecorder()
+	stages := [][]orchestration.Service{{recorder.Fake("a")}, {recorder.Fake("b")}}
+	group := &orchestration.StagedServiceGroup{GroupName: "group", Stages: stages}
+	if _, err := orchestration.CallServices(context.TODO(), []orchestration.Service{group}, orchestration.CallServicesOpts{}); err != nil {
+		t.Fatalf("Expected the group to succeed, got %v\n", err)
+	}
+
+	if err := group.Rollback(context.TODO()); err != nil {
+		t.Fatalf("Expected the rollback to succeed, got %v\n", err)
+	}
+	_ = group.Rollback(context.TODO()) // Nothing left to undo
+	orchestrationtest.AssertActions(t, recorder, "a", orchestration.SERVICE_CHECK, orchestration.SERVICE_RUN, orchestration.SERVICE_ROLLBACK)
+	orchestrationtest.AssertRolledBackInReverseStageOrder(t, recorder, stages)
+}
@@ -0,0 +1,90 @@
+package orchestration
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrShuttingDown = errors.New("shutting down")
+
+var (
+	shuttingDown   atomic.Bool
+	backgroundLock sync.Mutex
+	backgroundIdle = sync.NewCond(&backgroundLock)
+	backgroundJobs int
+)
+
+// Shutdown stops accepting new calls to CallServices and CallStagedServices, they return ErrShuttingDown. It then
+// waits until all background rollbacks, RollbackErrorReporter calls and Scheduler runs are done, or until ctx is
+// done. Calls that were already running are allowed to finish. For example:
+//
+//	<-signals // e.g. SIGTERM on redeploy
+//	_ = server.Shutdown(ctx) // Stop accepting requests, and wait for running requests
+//	_ = orchestration.Shutdown(ctx) // Wait for the compensations of those requests
+func Shutdown(ctx context.Context) error {
+	shuttingDown.Store(true)
+
+	// Wakes the wait below when ctx is done, and stops as soon as Shutdown returns
+	returned := make(chan struct{})
+	defer close(returned)
+	go func() {
+		select {
+		case <-ctx.Done():
+			backgroundLock.Lock()
+			backgroundIdle.Broadcast()
+			backgroundLock.Unlock()
+		case <-returned:
+		}
+	}()
+
+	backgroundLock.Lock()
+	defer backgroundLock.Unlock()
+	for backgroundJobs > 0 {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		backgroundIdle.Wait()
+	}
+	return nil
+}
+
+// Resume accepts new calls again after Shutdown, e.g. when the process keeps running after a Shutdown timed out
+func Resume() {
+	shuttingDown.Store(false)
+}
+
+// IsShuttingDown returns true after Shutdown has been called, until Resume is called
+func IsShuttingDown() bool {
+	return shuttingDown.Load()
+}
+
+// Wait blocks until there are no background rollbacks, RollbackErrorReporter calls and Scheduler runs, without
+// shutting down
+func Wait() {
+	backgroundLock.Lock()
+	defer backgroundLock.Unlock()
+	for backgroundJobs > 0 {
+		backgroundIdle.Wait()
+	}
+}
+
+// goBackground runs f in a goroutine that is tracked by Wait and Shutdown
+func goBackground(f func()) {
+	backgroundLock.Lock()
+	backgroundJobs++
+	backgroundLock.Unlock()
+
+	go func() {
+		defer func() {
+			backgroundLock.Lock()
+			backgroundJobs--
+			if backgroundJobs == 0 {
+				backgroundIdle.Broadcast()
+			}
+			backgroundLock.Unlock()
+		}()
+		f()
+	}()
+}
@@ -0,0 +1,50 @@
+package orchestration
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestShutdownTimesOutWhileWaitIsPending(t *testing.T) {
+	release := make(chan struct{})
+	goBackground(func() { <-release })
+	waited := make(chan struct{})
+	go func() {
+		Wait()
+		close(waited)
+	}()
+	goroutines := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+	if err := Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected Shutdown to time out, got %v\n", err)
+	}
+	if _, err := CallServices(context.TODO(), []Service{&FlakyRollbackService{Id: "a"}}, CallServicesOpts{}); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("Expected new calls to be rejected, got %v\n", err)
+	}
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > goroutines && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if runtime.NumGoroutine() > goroutines {
+		t.Errorf("Expected Shutdown to leave no goroutines behind, got %d instead of %d\n", runtime.NumGoroutine(), goroutines)
+	}
+
+	Resume()
+	close(release)
+	select {
+	case <-waited:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the pending Wait to return after the background job\n")
+	}
+	if err := Shutdown(context.TODO()); err != nil {
+		t.Errorf("Expected Shutdown to succeed without background jobs, got %v\n", err)
+	}
+	Resume()
+	if _, err := CallServices(context.TODO(), []Service{&FlakyRollbackService{Id: "a"}}, CallServicesOpts{}); err != nil {
+		t.Errorf("Expected calls to be accepted after Resume, got %v\n", err)
+	}
+}
@@ -0,0 +1,99 @@
+package spec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration"
+)
+
+var _ orchestration.ServiceFactory = &Registry{}
+
+// Definition is a single Service of a spec, with Targets expanded: a Service with three targets results in three
+// Definitions. Target is empty for a Service without targets.
+type Definition struct {
+	Type   string          `json:"type"`
+	Name   string          `json:"name,omitempty"`
+	Target string          `json:"target,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// Factory builds a Service from its Definition
+type Factory func(def Definition) (orchestration.Service, error)
+
+// Registry maps the type names used in a spec to the Factory of that type
+type Registry struct {
+	lock      sync.RWMutex
+	factories map[string]Factory
+}
+
+// DefaultRegistry is used by Register, and is a convenient Registry for binaries with a single set of Services
+var DefaultRegistry = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{factories: map[string]Factory{}}
+}
+
+// Register adds factory to the DefaultRegistry
+func Register(typeName string, factory Factory) {
+	DefaultRegistry.Register(typeName, factory)
+}
+
+// Register adds the factory for typeName, replacing an existing one
+func (r *Registry) Register(typeName string, factory Factory) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.factories[typeName] = factory
+}
+
+func (r *Registry) Lookup(typeName string) (Factory, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	factory, ok := r.factories[typeName]
+	return factory, ok
+}
+
+// NewService rebuilds a Service from data returned by MarshalDefinition, with the Factory of typeName. This makes the
+// Registry the orchestration.ServiceFactory of a RollbackQueue, so queued rollbacks use the same factories as specs.
+func (r *Registry) NewService(typeName string, data []byte) (orchestration.Service, error) {
+	factory, ok := r.Lookup(typeName)
+	if !ok {
+		return nil, errors.New("unknown type: " + typeName)
+	}
+	def := Definition{}
+	if err := json.Unmarshal(data, &def); err != nil {
+		return nil, errors.New("invalid definition of type " + typeName + ": " + err.Error())
+	}
+	def.Type = typeName
+	return factory(def)
+}
+
+// MarshalDefinition returns the data for PersistentService.MarshalService of a Service built from def
+func MarshalDefinition(def Definition) ([]byte, error) {
+	return json.Marshal(def)
+}
+
+// Types returns all registered type names, sorted
+func (r *Registry) Types() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	types := []string{}
+	for typeName := range r.factories {
+		types = append(types, typeName)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// DecodeParams decodes the Params into v, unknown fields are an error so typos in a spec are caught early
+func (d Definition) DecodeParams(v any) error {
+	if len(d.Params) == 0 {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(d.Params))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
@@ -0,0 +1,291 @@
+// Package spec contains a declarative format for orchestrations, so an orchestration can be described in a YAML or
+// JSON file instead of hand-built []orchestration.Service stages. A spec refers to Services by a type name, which
+// is mapped to a Factory in a Registry.
+//
+// Take the following example:
+//
+//	name: create-memory-claim
+//	options:
+//	  timeout: 1m
+//	stages:
+//	  - name: claim
+//	    quorum: 3 # Succeeds when at least 3 of the 4 targets succeed
+//	    services:
+//	      - type: memory-claim-create
+//	        targets: [DC1_BLUE, DC1_RED, DC2_BLUE, DC2_RED]
+//	        params: {name: example-memory-claim, memory_in_mb: 100}
+//
+//	func example() {
+//	    spec.Register("memory-claim-create", func(def spec.Definition) (orchestration.Service, error) { ... })
+//	    s, err := spec.Load("create-memory-claim.yaml")
+//	    plan, err := s.Build(spec.DefaultRegistry) // Validates the spec, and builds ready-to-run stages
+//	    ctx, cancel := plan.Context(context.TODO())
+//	    defer cancel()
+//	    nStagesRun, errs, err := orchestration.CallStagedServices(ctx, plan.Stages, plan.Opts)
+//	}
+package spec
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration"
+	"github.com/ing-bank/orchestration-pkg/pkg/task"
+	"gopkg.in/yaml.v3"
+)
+
+type Spec struct {
+	Name    string      `json:"name"`
+	Options Options     `json:"options"`
+	Stages  []StageSpec `json:"stages"`
+}
+
+type Options struct {
+	DryRun        bool     `json:"dryRun"`        // Only Check all Services, see the "dryRun" context value
+	SkipRollback  bool     `json:"skipRollback"`  // See CallServicesOpts.SkipRollback
+	Timeout       Duration `json:"timeout"`       // Maximum duration of the whole orchestration
+	VerifyTimeout Duration `json:"verifyTimeout"` // See CallServicesOpts.VerifyTimeout
+}
+
+type StageSpec struct {
+	Name     string        `json:"name"`
+	Quorum   int           `json:"quorum"`   // When set, the stage succeeds when at least Quorum Services succeed
+	Approval bool          `json:"approval"` // Requires an ApprovalGate in the Plan Opts, see CallServicesOpts.ApprovalStages
+	Services []ServiceSpec `json:"services"`
+}
+
+type ServiceSpec struct {
+	Type    string          `json:"type"`
+	Name    string          `json:"name"`
+	Targets []string        `json:"targets"` // One Service is built per target
+	Params  json.RawMessage `json:"params"`
+	DryRun  bool            `json:"dryRun"`  // Wrap the Service with MakeDryRun
+	Timeout Duration        `json:"timeout"` // Maximum duration of each action of the Service
+}
+
+// Duration is a time.Duration that is written as a string in a spec, e.g. "1m30s"
+type Duration time.Duration
+
+// Plan contains the ready-to-run stages of a Spec
+type Plan struct {
+	Name       string
+	StageNames []string // Aligned with Stages
+	Stages     [][]orchestration.Service
+	Opts       orchestration.CallServicesOpts
+
+	DryRun  bool
+	Timeout time.Duration
+}
+
+// Load reads and parses the spec at path
+func Load(path string) (*Spec, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(raw)
+}
+
+// Parse parses a YAML or JSON spec. YAML is converted to JSON first, so both formats use the json tags of Spec.
+func Parse(data []byte) (*Spec, error) {
+	var generic any
+	if err := yaml.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(generic)
+	if err != nil {
+		return nil, errors.New("spec contains a value that cannot be converted to JSON: " + err.Error())
+	}
+
+	spec := &Spec{}
+	decoder := json.NewDecoder(strings.NewReader(string(raw)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(spec); err != nil {
+		return nil, errors.New("invalid spec: " + err.Error())
+	}
+	return spec, nil
+}
+
+// Validate checks the spec against registry, including building every Service. All problems are reported at once.
+func (s *Spec) Validate(registry *Registry) error {
+	_, err := s.Build(registry)
+	return err
+}
+
+// Build validates the spec and builds the stages
+func (s *Spec) Build(registry *Registry) (*Plan, error) {
+	var problems []string
+	problem := func(format string, params ...any) {
+		problems = append(problems, fmt.Sprintf(format, params...))
+	}
+
+	if s.Name == "" {
+		problem("spec has no name")
+	}
+	if len(s.Stages) == 0 {
+		problem("spec has no stages")
+	}
+	if s.Options.Timeout < 0 || s.Options.VerifyTimeout < 0 {
+		problem("spec has a negative timeout")
+	}
+
+	plan := &Plan{
+		Name:    s.Name,
+		DryRun:  s.Options.DryRun,
+		Timeout: time.Duration(s.Options.Timeout),
+		Opts: orchestration.CallServicesOpts{
+			SkipRollback:  s.Options.SkipRollback,
+			VerifyTimeout: time.Duration(s.Options.VerifyTimeout),
+		},
+	}
+	names := map[string]bool{}
+
+	for i, stageSpec := range s.Stages {
+		stageName := stageSpec.Name
+		if stageName == "" {
+			stageName = fmt.Sprintf("stage %d", i+1)
+		}
+		if len(stageSpec.Services) == 0 {
+			problem("%s has no services", stageName)
+		}
+
+		var stage []orchestration.Service
+		for j, serviceSpec := range stageSpec.Services {
+			where := fmt.Sprintf("%s service %d (%s)", stageName, j+1, serviceSpec.Type)
+			factory, ok := registry.Lookup(serviceSpec.Type)
+			if !ok {
+				problem("%s: unknown type, registered types are: %s", where, strings.Join(registry.Types(), ", "))
+				continue
+			}
+			if serviceSpec.Timeout < 0 {
+				problem("%s: negative timeout", where)
+			}
+
+			targets := serviceSpec.Targets
+			if len(targets) == 0 {
+				targets = []string{""}
+			}
+			for _, target := range targets {
+				service, err := factory(Definition{
+					Type:   serviceSpec.Type,
+					Name:   serviceSpec.Name,
+					Target: target,
+					Params: serviceSpec.Params,
+				})
+				if err != nil {
+					problem("%s: %v", where, err)
+					continue
+				}
+				if names[service.Name()] {
+					problem("%s: duplicate service name %q", where, service.Name())
+				}
+				names[service.Name()] = true
+
+				if serviceSpec.Timeout > 0 {
+					service = withTimeout(service, time.Duration(serviceSpec.Timeout))
+				}
+				if serviceSpec.DryRun {
+					service = orchestration.MakeDryRun(service)
+				}
+				stage = append(stage, service)
+			}
+		}
+
+		if stageSpec.Quorum < 0 || stageSpec.Quorum > len(stage) {
+			problem("%s: quorum %d is not between 0 and the number of services (%d)", stageName, stageSpec.Quorum, len(stage))
+		} else if stageSpec.Quorum > 0 {
+			stage = []orchestration.Service{
+				&orchestration.ServiceGroup{GroupName: stageName, Services: stage, Quorum: stageSpec.Quorum},
+			}
+		}
+		if stageSpec.Approval {
+			plan.Opts.ApprovalStages = append(plan.Opts.ApprovalStages, i)
+		}
+		plan.StageNames = append(plan.StageNames, stageName)
+		plan.Stages = append(plan.Stages, stage)
+	}
+
+	if len(problems) > 0 {
+		return nil, errors.New("invalid spec:\n  " + strings.Join(problems, "\n  "))
+	}
+	return plan, nil
+}
+
+// Context returns ctx with the dry run flag and timeout of the plan applied
+func (p *Plan) Context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.DryRun {
+		ctx = context.WithValue(ctx, "dryRun", true)
+	}
+	if p.Timeout > 0 {
+		return context.WithTimeout(ctx, p.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(raw []byte) error {
+	var text string
+	if err := json.Unmarshal(raw, &text); err != nil {
+		return errors.New("duration must be a string like \"30s\"")
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// timeoutService limits the duration of every action of the wrapped Service, an action that takes too long fails
+// with "timeout" like in task.Run
+type timeoutService struct {
+	orchestration.Service
+	timeout time.Duration
+}
+
+// withTimeout wraps service in a timeoutService that implements the same optional interfaces as service
+func withTimeout(service orchestration.Service, timeout time.Duration) orchestration.Service {
+	return orchestration.WrapPreserving(service, &timeoutService{Service: service, timeout: timeout})
+}
+
+// action implements task.Runnable for a single action
+type action func(ctx context.Context) error
+
+func (a action) Run(ctx context.Context) error {
+	return a(ctx)
+}
+
+func (t *timeoutService) limit(ctx context.Context, runnable action) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return task.Run([]task.Runnable{runnable}, ctx)[0]
+}
+
+func (t *timeoutService) Check(ctx context.Context) error {
+	return t.limit(ctx, t.Service.Check)
+}
+
+func (t *timeoutService) Run(ctx context.Context) error {
+	return t.limit(ctx, t.Service.Run)
+}
+
+func (t *timeoutService) Rollback(ctx context.Context) error {
+	return t.limit(ctx, t.Service.Rollback)
+}
+
+// Verify is limited like the other actions, it is only exposed by withTimeout when the wrapped Service is a Verifier
+func (t *timeoutService) Verify(ctx context.Context) error {
+	return t.limit(ctx, t.Service.(orchestration.Verifier).Verify)
+}
+
+func (t *timeoutService) Unwrap() orchestration.Service {
+	return t.Service
+}
@@ -0,0 +1,155 @@
+package spec
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration"
+)
+
+// Struct definition required to satisfy the Service interface, built by the "claim" Factory below.
+// Runs in datacenter "DC_FAIL" fail.
+type Claim struct {
+	orchestration.SimpleService
+	Datacenter string
+	Mb         int `json:"mb"`
+}
+
+func (c *Claim) Name() string { return "Claim " + c.Datacenter }
+
+func (c *Claim) Run(_ context.Context) error {
+	if c.Datacenter == "DC_FAIL" {
+		return errors.New("failed")
+	}
+	return nil
+}
+
+func (c *Claim) Rollback(_ context.Context) error { return nil }
+
+func newClaimRegistry() *Registry {
+	registry := NewRegistry()
+	registry.Register("claim", func(def Definition) (orchestration.Service, error) {
+		claim := &Claim{Datacenter: def.Target}
+		if err := def.DecodeParams(claim); err != nil {
+			return nil, err
+		}
+		return claim, nil
+	})
+	return registry
+}
+
+func TestParseAndBuildYaml(t *testing.T) {
+	s, err := Parse([]byte(`
+name: claims
+options:
+  timeout: 1m
+stages:
+  - name: claim
+    quorum: 2
+    services:
+      - type: claim
+        targets: [DC1, DC2, DC_FAIL]
+        params: {mb: 100}
+  - services:
+      - type: claim
+        targets: [DC3]
+        dryRun: true
+`))
+	if err != nil {
+		t.Fatalf("Expected spec to parse, got %v\n", err)
+	}
+	plan, err := s.Build(newClaimRegistry())
+	if err != nil {
+		t.Fatalf("Expected spec to build, got %v\n", err)
+	}
+	if len(plan.Stages) != 2 || len(plan.Stages[0]) != 1 || plan.Stages[1][0].Name() != "Claim DC3 (dryRun)" {
+		t.Fatalf("Expected a quorum group and a dry run stage, got %v\n", plan.Stages)
+	}
+
+	ctx, cancel := plan.Context(context.TODO())
+	defer cancel()
+	if _, _, err := orchestration.CallStagedServices(ctx, plan.Stages, plan.Opts); err != nil {
+		t.Errorf("Expected quorum of 2 out of 3 to succeed, got %v\n", err)
+	}
+}
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	s, err := Parse([]byte(`{"name": "claims", "stages": [
+		{"quorum": 5, "services": [{"type": "claim", "params": {"gb": 1}}, {"type": "unknown"}]}
+	]}`))
+	if err != nil {
+		t.Fatalf("Expected JSON spec to parse, got %v\n", err)
+	}
+	err = s.Validate(newClaimRegistry())
+	if err == nil {
+		t.Fatalf("Expected validation errors\n")
+	}
+	for _, expected := range []string{"unknown field \"gb\"", "unknown type", "quorum 5"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected validation error to contain %q, got %v\n", expected, err)
+		}
+	}
+}
+
+func TestParseRejectsUnknownFields(t *testing.T) {
+	if _, err := Parse([]byte("name: claims\nstagez: []\n")); err == nil {
+		t.Errorf("Expected a typo in the spec to be an error\n")
+	}
+}
+
+// Struct definition required to satisfy the Verifier and OutputConsumer interfaces. Verify blocks until ctx is done.
+type SlowVerifier struct {
+	Claim
+}
+
+func (s *SlowVerifier) Verify(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (s *SlowVerifier) RequiredOutputs() []string { return []string{"claim.id"} }
+
+func TestTimeoutKeepsOptionalInterfaces(t *testing.T) {
+	if _, ok := withTimeout(&Claim{}, time.Minute).(orchestration.Verifier); ok {
+		t.Errorf("Expected a timeout of a Service that does not verify not to be a Verifier\n")
+	}
+
+	service := withTimeout(&SlowVerifier{}, 10*time.Millisecond)
+	if consumer, ok := service.(orchestration.OutputConsumer); !ok || consumer.RequiredOutputs()[0] != "claim.id" {
+		t.Errorf("Expected the RequiredOutputs of the wrapped Service\n")
+	}
+	verifier, ok := service.(orchestration.Verifier)
+	if !ok {
+		t.Fatalf("Expected a timeout of a Verifier to be a Verifier\n")
+	}
+	done := make(chan error)
+	go func() { done <- verifier.Verify(context.TODO()) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("Expected Verify to time out\n")
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected Verify to be limited by the timeout\n")
+	}
+	if wrapper, ok := service.(orchestration.ServiceWrapper); !ok || wrapper.Unwrap().Name() != "Claim " {
+		t.Errorf("Expected the timeout to unwrap to the Service\n")
+	}
+}
+
+func TestRegistryRebuildsServices(t *testing.T) {
+	data, err := MarshalDefinition(Definition{Type: "claim", Target: "DC1", Params: []byte(`{"mb": 100}`)})
+	if err != nil {
+		t.Fatalf("Expected the definition to be marshalled, got %v\n", err)
+	}
+	service, err := newClaimRegistry().NewService("claim", data)
+	if claim, ok := service.(*Claim); err != nil || !ok || claim.Datacenter != "DC1" || claim.Mb != 100 {
+		t.Errorf("Expected the claim to be rebuilt from its definition, got %+v %v\n", service, err)
+	}
+	if _, err := newClaimRegistry().NewService("unknown", data); err == nil {
+		t.Errorf("Expected an error for an unknown type\n")
+	}
+}
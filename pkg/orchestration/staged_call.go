@@ -0,0 +1,137 @@
+package orchestration
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrCancelled = errors.New("cancelled")
+
+// StagedCall is a handle to a CallStagedServices that runs in the background, see CallStagedServicesAsync.
+// Compared to CallStagedServices, all rollbacks are done before the StagedCall is done, and a cancelled
+// StagedCall still rolls back using a context that is not cancelled.
+type StagedCall struct {
+	stages [][]Service
+
+	cancelled  chan struct{}
+	cancelOnce sync.Once
+	abandon    context.CancelFunc
+	done       chan struct{}
+
+	stagesRun int
+	errs      []error
+	err       error
+}
+
+// CallStagedServicesAsync starts CallStagedServices in the background, and returns a handle to cancel or wait
+// for it. For example:
+//
+//	call := CallStagedServicesAsync(context.TODO(), stages, CallServicesOpts{})
+//	...
+//	call.Cancel() // Stops after the current stage, and rolls back all stages that ran
+//	status, response := call.Reply() // Response status is "cancelled"
+func CallStagedServicesAsync(ctx context.Context, stages [][]Service, opts CallServicesOpts) *StagedCall {
+	ctx, abandon := context.WithCancel(ctx)
+	call := &StagedCall{
+		stages:    stages,
+		cancelled: make(chan struct{}),
+		abandon:   abandon,
+		done:      make(chan struct{}),
+	}
+
+	goBackground(func() { // Tracked, so Shutdown waits for the rollbacks of a cancelled call
+		defer close(call.done)
+		defer abandon()
+		call.stagesRun, call.errs, call.err = callStagedServices(ctx, stages, opts, call)
+	})
+	return call
+}
+
+// Cancel stops scheduling further stages. Actions that are in-flight are allowed to finish, after which all
+// stages that ran are rolled back in reversed order, also when the last stage was running. A Cancel after the
+// StagedCall is done has no effect.
+func (c *StagedCall) Cancel() {
+	c.cancelOnce.Do(func() {
+		close(c.cancelled)
+	})
+}
+
+// Abandon is like Cancel, but does not wait for in-flight actions. Their context is cancelled, and they are
+// reported as "timeout".
+func (c *StagedCall) Abandon() {
+	c.Cancel()
+	c.abandon()
+}
+
+// Done is closed when all stages (and rollbacks) are finished
+func (c *StagedCall) Done() <-chan struct{} {
+	return c.done
+}
+
+// Wait blocks until the StagedCall is done, and returns the same values as CallStagedServices
+func (c *StagedCall) Wait() (int, []error, error) {
+	<-c.done
+	return c.stagesRun, c.errs, c.err
+}
+
+// Reply waits like Wait, and generates the response like CallStagedServicesAndReply
+func (c *StagedCall) Reply() (int, *Response) {
+	nStagesRun, errs, err := c.Wait()
+	return GenerateStagedResponse(c.stages, nStagesRun, errs, err)
+}
+
+func (c *StagedCall) isCancelled() bool {
+	if c == nil {
+		return false
+	}
+	select {
+	case <-c.cancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+// cancelChan returns the channel that is closed on Cancel, or nil (blocks forever) when there is no StagedCall
+func (c *StagedCall) cancelChan() <-chan struct{} {
+	if c == nil {
+		return nil
+	}
+	return c.cancelled
+}
+
+// rollback rolls back stages in reversed order, and waits until they are done
+func (c *StagedCall) rollback(ctx context.Context, stages [][]Service, opts CallServicesOpts) {
+	if c == nil || opts.SkipRollback {
+		return
+	}
+	rollbackStages(detachedContext{ctx}, stages)
+}
+
+// detachedContext keeps the values of its parent, but is never cancelled. Used to roll back after a cancel.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+// fillErrors returns n times err, e.g. for services that were never called
+func fillErrors(err error, n int) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
@@ -0,0 +1,103 @@
+package orchestration_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration"
+	"github.com/ing-bank/orchestration-pkg/pkg/orchestration/orchestrationtest"
+)
+
+// waitUntilCalled waits until service started action, so a StagedCall can be cancelled while the action runs
+func waitUntilCalled(t *testing.T, recorder *orchestrationtest.Recorder, service string, action orchestration.ServiceAction) {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		for _, called := range recorder.Actions(service) {
+			if called == action {
+				return
+			}
+		}
+	}
+	t.Fatalf("Expected %s of \"%s\" to be called, got:\n%s\n", action, service, recorder)
+}
+
+func TestCancelBeforeStage(t *testing.T) {
+	recorder := orchestrationtest.NewRecorder()
+	block := make(chan struct{})
+	stages := [][]orchestration.Service{
+		{recorder.Fake("a").On(orchestration.SERVICE_RUN, orchestrationtest.Behavior{Block: block})},
+		{recorder.Fake("b")},
+	}
+
+	call := orchestration.CallStagedServicesAsync(context.TODO(), stages, orchestration.CallServicesOpts{})
+	waitUntilCalled(t, recorder, "a", orchestration.SERVICE_RUN)
+	call.Cancel()
+	close(block)
+
+	nStagesRun, errs, err := call.Wait()
+	if nStagesRun != 1 || !errors.Is(err, orchestration.ErrCancelled) || !errors.Is(errs[0], orchestration.ErrCancelled) {
+		t.Errorf("Expected the second stage to be cancelled, got %d %v %v\n", nStagesRun, errs, err)
+	}
+	orchestrationtest.AssertRolledBack(t, recorder, "a")
+	orchestrationtest.AssertActions(t, recorder, "b")
+}
+
+func TestCancelDuringLastStage(t *testing.T) {
+	recorder := orchestrationtest.NewRecorder()
+	block := make(chan struct{})
+	stages := [][]orchestration.Service{
+		{recorder.Fake("a")},
+		{recorder.Fake("b").On(orchestration.SERVICE_RUN, orchestrationtest.Behavior{Block: block})},
+	}
+
+	call := orchestration.CallStagedServicesAsync(context.TODO(), stages, orchestration.CallServicesOpts{})
+	waitUntilCalled(t, recorder, "b", orchestration.SERVICE_RUN)
+	call.Cancel()
+	close(block)
+
+	status, response := call.Reply()
+	if status != http.StatusInternalServerError || response.Status != "cancelled" || len(response.Details) != 2 {
+		t.Errorf("Expected a cancelled response of both stages, got %d %+v\n", status, response)
+	}
+	orchestrationtest.AssertRolledBack(t, recorder, "a", "b")
+	orchestrationtest.AssertRolledBackInReverseStageOrder(t, recorder, stages)
+}
+
+func TestCancelAfterDone(t *testing.T) {
+	recorder := orchestrationtest.NewRecorder()
+	stages := [][]orchestration.Service{{recorder.Fake("a")}, {recorder.Fake("b")}}
+
+	call := orchestration.CallStagedServicesAsync(context.TODO(), stages, orchestration.CallServicesOpts{})
+	if nStagesRun, _, err := call.Wait(); nStagesRun != 2 || err != nil {
+		t.Fatalf("Expected both stages to run, got %d %v\n", nStagesRun, err)
+	}
+	call.Cancel()
+	orchestration.Wait()
+
+	if _, _, err := call.Wait(); err != nil {
+		t.Errorf("Expected a cancel after the call is done to change nothing, got %v\n", err)
+	}
+	orchestrationtest.AssertNotRolledBack(t, recorder, "a", "b")
+}
+
+func TestAbandon(t *testing.T) {
+	recorder := orchestrationtest.NewRecorder()
+	stages := [][]orchestration.Service{{recorder.Fake("a")}, {recorder.Fake("b").HangOn(orchestration.SERVICE_RUN)}}
+
+	call := orchestration.CallStagedServicesAsync(context.TODO(), stages, orchestration.CallServicesOpts{})
+	waitUntilCalled(t, recorder, "b", orchestration.SERVICE_RUN)
+	call.Abandon()
+
+	if nStagesRun, _, err := call.Wait(); nStagesRun != 1 || !errors.Is(err, orchestration.ErrCancelled) {
+		t.Errorf("Expected the hanging stage to be abandoned, got %d %v\n", nStagesRun, err)
+	}
+	orchestrationtest.AssertRolledBack(t, recorder, "a")
+	orchestrationtest.AssertRolledBackInReverseStageOrder(t, recorder, stages)
+	for _, event := range recorder.Events() {
+		if event.Action == orchestration.SERVICE_ROLLBACK && event.Err != nil {
+			t.Errorf("Expected the rollbacks not to be abandoned, got:\n%s\n", recorder)
+		}
+	}
+}
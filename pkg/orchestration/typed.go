@@ -0,0 +1,132 @@
+package orchestration
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// TypedService is a Service with a response of type T. Any Service that embeds a TypedResponder[T] satisfies it,
+// which allows reading the response with ResultOf or Results instead of type asserting GetResponse.
+type TypedService[T any] interface {
+	Service
+	Result() T
+}
+
+// TypedResponder is the typed counterpart of Responder. For example:
+//
+//	type CreateClaim struct {
+//	    Recoverable
+//	    TypedResponder[Claim]
+//	}
+//
+//	func (svc *CreateClaim) Run(_ context.Context) error {
+//	    svc.Response = Claim{...}
+//	    return nil
+//	}
+type TypedResponder[T any] struct {
+	Response                 T
+	UseNullResponseAsDefault bool
+}
+
+// Result is the typed response of a single Service after CallServices
+type Result[T any] struct {
+	Name  string
+	Value T
+	Err   error
+	Typed bool // False when the Service is not a TypedService[T], Value is the zero value
+}
+
+// TypedResponse can be used by clients to decode a Response of which the details are of type T, see
+// DecodeTypedResponse
+type TypedResponse[T any] struct {
+	Status  string                   `json:"status"`
+	Details []TypedResponseDetail[T] `json:"details"`
+}
+
+type TypedResponseDetail[T any] struct {
+	Name   string `json:"name"`
+	Detail T      `json:"detail"`
+	Error  string `json:"error,omitempty"` // The detail of a failed Service is its error string instead of T
+}
+
+func (r *TypedResponder[T]) GetResponse(err error) any {
+	if err != nil {
+		return err.Error()
+	}
+	if !r.UseNullResponseAsDefault && isNil(r.Response) {
+		return "ok"
+	}
+	return r.Response
+}
+
+func (r *TypedResponder[T]) Result() T {
+	return r.Response
+}
+
+// ResultOf returns the typed response of service, or of the Service it wraps when it is a ServiceWrapper. ok is false
+// when neither is a TypedService[T].
+func ResultOf[T any](service Service) (T, bool) {
+	for service != nil {
+		if typed, ok := service.(TypedService[T]); ok {
+			return typed.Result(), true
+		}
+		wrapper, ok := service.(ServiceWrapper)
+		if !ok {
+			break
+		}
+		service = wrapper.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
+// Results returns the typed response of each service, aligned with the services and errs of CallServices
+func Results[T any](services []Service, errs []error) []Result[T] {
+	errs = alignErrors(errs, len(services))
+	results := make([]Result[T], len(services))
+	for i, service := range services {
+		value, typed := ResultOf[T](service)
+		results[i] = Result[T]{Name: service.Name(), Value: value, Err: errs[i], Typed: typed}
+	}
+	return results
+}
+
+// DecodeTypedResponse decodes a JSON Response. When the Status is not "ok", details that are strings are the errors
+// of failed Services and are stored in Error, other details are decoded as T.
+func DecodeTypedResponse[T any](data []byte) (*TypedResponse[T], error) {
+	raw := struct {
+		Status  string `json:"status"`
+		Details []struct {
+			Name   string          `json:"name"`
+			Detail json.RawMessage `json:"detail"`
+		} `json:"details"`
+	}{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	response := &TypedResponse[T]{Status: raw.Status}
+	for _, rawDetail := range raw.Details {
+		detail := TypedResponseDetail[T]{Name: rawDetail.Name}
+		if raw.Status == "ok" || json.Unmarshal(rawDetail.Detail, &detail.Error) != nil {
+			if err := json.Unmarshal(rawDetail.Detail, &detail.Detail); err != nil {
+				return nil, err
+			}
+		}
+		response.Details = append(response.Details, detail)
+	}
+	return response, nil
+}
+
+// isNil returns true for nil, and for nil values of types that can be nil
+func isNil(v any) bool {
+	if v == nil {
+		return true
+	}
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Pointer, reflect.Slice:
+		return value.IsNil()
+	}
+	return false
+}
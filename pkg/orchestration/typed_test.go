@@ -0,0 +1,56 @@
+package orchestration
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// Struct definition required to satisfy the TypedService interface
+type TypedClaimService struct {
+	Recoverable
+	TypedResponder[Claim]
+}
+
+func (c *TypedClaimService) Name() string { return "Typed claim" }
+
+func (c *TypedClaimService) Check(_ context.Context) error { return nil }
+
+func (c *TypedClaimService) Run(_ context.Context) error {
+	c.Response = Claim{ClaimName: "claim-1", MemoryInMb: 512}
+	return nil
+}
+
+func (c *TypedClaimService) Rollback(_ context.Context) error { return nil }
+
+func TestResultOfWrappedServices(t *testing.T) {
+	service := &TypedClaimService{}
+	_ = service.Run(context.TODO())
+
+	wrapped := []Service{service, MakeDryRun(service), When(nil, service), When(nil, MakeDryRun(service))}
+	for _, result := range Results[Claim](wrapped, nil) {
+		if !result.Typed || result.Value.ClaimName != "claim-1" {
+			t.Errorf("Expected the result of the wrapped Service, got %+v\n", result)
+		}
+	}
+	if _, ok := ResultOf[string](MakeDryRun(service)); ok {
+		t.Errorf("Expected no result of another type\n")
+	}
+}
+
+func TestDecodeTypedResponse(t *testing.T) {
+	response, err := DecodeTypedResponse[string]([]byte(`{"status":"ok","details":[{"name":"a","detail":"created"}]}`))
+	if err != nil || !reflect.DeepEqual(response.Details, []TypedResponseDetail[string]{{Name: "a", Detail: "created"}}) {
+		t.Errorf("Expected a string detail of a successful call to be decoded as T, got %+v %v\n", response, err)
+	}
+
+	claims, err := DecodeTypedResponse[Claim]([]byte(`{"status":"failed","details":[{"name":"a","detail":"quota exceeded"},{"name":"b","detail":{"name":"claim-1"}}]}`))
+	expected := []TypedResponseDetail[Claim]{{Name: "a", Error: "quota exceeded"}, {Name: "b", Detail: Claim{ClaimName: "claim-1"}}}
+	if err != nil || !reflect.DeepEqual(claims.Details, expected) {
+		t.Errorf("Expected the string detail of a failed call to be an error, got %+v %v\n", claims, err)
+	}
+
+	if _, err := DecodeTypedResponse[Claim]([]byte(`{"status":"ok","details":[{"name":"a","detail":"ok"}]}`)); err == nil {
+		t.Errorf("Expected an error when a detail of a successful call is not a T\n")
+	}
+}
@@ -0,0 +1,75 @@
+package orchestration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// ErrConflict matches errors for an object that was changed concurrently, e.g. a ConflictError, or an HTTPError with
+// status 409 or 412
+var ErrConflict = errors.New("conflict")
+
+// Versioned can be implemented by the objects of a RestApi that carry a version, e.g. an ETag or a resourceVersion.
+// RestApiService uses it to make its updates and rollbacks conditional on the version it has seen.
+type Versioned interface {
+	Version() string
+}
+
+// VersionSetter can be implemented by objects of which the version is not part of the body, HTTPRestApi sets it from
+// the ETag header
+type VersionSetter interface {
+	SetVersion(version string)
+}
+
+// VersionedResponse is the response of a write of which the version is not part of the body, e.g. the ETag header
+// of an HTTPRestApi response. It is marshalled as its Body.
+type VersionedResponse struct {
+	Body any
+	ETag string
+}
+
+func (r *VersionedResponse) Version() string {
+	return r.ETag
+}
+
+func (r *VersionedResponse) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.Body)
+}
+
+// ConditionalRestApi can be implemented by a RestApi that only updates or deletes an object when its version still
+// matches, e.g. with If-Match. A mismatch returns an error that matches ErrConflict.
+type ConditionalRestApi interface {
+	PutIfMatch(ctx context.Context, obj Nameable, version string) (interface{}, error)
+	DeleteIfMatch(ctx context.Context, name, version string) (interface{}, error)
+}
+
+// ConflictError is returned when an object changed since it was last seen, e.g. when a Rollback would overwrite a
+// change of someone else
+type ConflictError struct {
+	Name     string
+	Expected string // Version that was seen
+	Actual   string // Current version
+}
+
+func (e *ConflictError) Error() string {
+	return "conflict: " + e.Name + " changed since version " + e.Expected + " (now " + e.Actual + ")"
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// StatusCode implements StatusCoder, a conflict is replied with 409
+func (e *ConflictError) StatusCode() int {
+	return http.StatusConflict
+}
+
+// versionOf returns the version of obj, or an empty string when obj is not Versioned
+func versionOf(obj any) string {
+	if versioned, ok := obj.(Versioned); ok && !isNil(obj) {
+		return versioned.Version()
+	}
+	return ""
+}
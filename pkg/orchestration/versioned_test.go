@@ -0,0 +1,146 @@
+package orchestration
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+type VersionedClaim struct {
+	Claim
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+func (c *VersionedClaim) Version() string { return c.ResourceVersion }
+
+// VersionedApi is an in-memory ConditionalRestApi, every write bumps the version of the claim
+type VersionedApi struct {
+	HideVersion bool // Writes do not return the written claim
+
+	lock    sync.Mutex
+	claims  map[string]VersionedClaim
+	version int
+}
+
+func (a *VersionedApi) Get(_ context.Context, name string) (Nameable, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	claim, ok := a.claims[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &claim, nil
+}
+
+func (a *VersionedApi) Post(ctx context.Context, obj Nameable) (interface{}, error) {
+	return a.PutIfMatch(ctx, obj, "")
+}
+
+func (a *VersionedApi) Put(ctx context.Context, obj Nameable) (interface{}, error) {
+	return a.PutIfMatch(ctx, obj, "")
+}
+
+func (a *VersionedApi) Delete(ctx context.Context, name string) (interface{}, error) {
+	return a.DeleteIfMatch(ctx, name, "")
+}
+
+func (a *VersionedApi) List(_ context.Context) (interface{}, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (a *VersionedApi) PutIfMatch(_ context.Context, obj Nameable, version string) (interface{}, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if err := a.match(obj.Name(), version); err != nil {
+		return nil, err
+	}
+	a.version++
+	claim := *obj.(*VersionedClaim)
+	claim.ResourceVersion = strconv.Itoa(a.version)
+	a.claims[claim.Name()] = claim
+	if a.HideVersion {
+		return "ok", nil
+	}
+	return &claim, nil
+}
+
+func (a *VersionedApi) DeleteIfMatch(_ context.Context, name, version string) (interface{}, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if err := a.match(name, version); err != nil {
+		return nil, err
+	}
+	delete(a.claims, name)
+	return "ok", nil
+}
+
+func (a *VersionedApi) match(name, version string) error {
+	if current := a.claims[name].ResourceVersion; version != "" && version != current {
+		return &ConflictError{Name: name, Expected: version, Actual: current}
+	}
+	return nil
+}
+
+func TestOptimisticConcurrency(t *testing.T) {
+	ctx := context.TODO()
+	claim := func(memory int) *VersionedClaim {
+		return &VersionedClaim{Claim: Claim{ClaimName: "a", MemoryInMb: memory}}
+	}
+	memoryOf := func(api *VersionedApi) int {
+		got, _ := api.Get(ctx, "a")
+		return got.(*VersionedClaim).MemoryInMb
+	}
+	newService := func(api *VersionedApi) Service {
+		svc := RestApiAsService(api, REST_API_PUT, "Resize a", "a", claim(256)).(*RestApiService)
+		svc.Equal = func(want, got Nameable) bool { return want.(*VersionedClaim).Claim == got.(*VersionedClaim).Claim }
+		return svc
+	}
+
+	// Without concurrent changes the Rollback restores the backup
+	api := &VersionedApi{claims: map[string]VersionedClaim{}}
+	_, _ = api.Post(ctx, claim(128))
+	svc := newService(api)
+	if errs, err := CallServices(ctx, []Service{svc}, CallServicesOpts{}); err != nil {
+		t.Fatalf("Expected the update to succeed, got %v %v\n", err, errs)
+	}
+	if err := svc.Rollback(ctx); err != nil || memoryOf(api) != 128 {
+		t.Errorf("Expected the rollback to restore 128, got %d %v\n", memoryOf(api), err)
+	}
+
+	// A change between Check and Run fails the Run
+	api = &VersionedApi{claims: map[string]VersionedClaim{}}
+	_, _ = api.Post(ctx, claim(128))
+	svc = newService(api)
+	_ = svc.Check(ctx)
+	_, _ = api.Put(ctx, claim(512))
+	if err := svc.Run(ctx); !errors.Is(err, ErrConflict) || memoryOf(api) != 512 {
+		t.Errorf("Expected the run to conflict, got %d %v\n", memoryOf(api), err)
+	}
+
+	// A change after Run is not overwritten by the Rollback
+	api = &VersionedApi{claims: map[string]VersionedClaim{}}
+	_, _ = api.Post(ctx, claim(128))
+	svc = newService(api)
+	if errs, err := CallServices(ctx, []Service{svc}, CallServicesOpts{}); err != nil {
+		t.Fatalf("Expected the update to succeed, got %v %v\n", err, errs)
+	}
+	_, _ = api.Put(ctx, claim(512))
+	err := svc.Rollback(ctx)
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || conflict.StatusCode() != 409 || memoryOf(api) != 512 {
+		t.Errorf("Expected the rollback to conflict, got %d %v\n", memoryOf(api), err)
+	}
+
+	// Without the version written by Run, the Rollback is skipped instead of guessing
+	api = &VersionedApi{HideVersion: true, claims: map[string]VersionedClaim{}}
+	_, _ = api.Post(ctx, claim(128))
+	svc = newService(api)
+	if errs, err := CallServices(ctx, []Service{svc}, CallServicesOpts{}); err != nil {
+		t.Fatalf("Expected the update to succeed, got %v %v\n", err, errs)
+	}
+	if err := svc.Rollback(ctx); err == nil || memoryOf(api) != 256 {
+		t.Errorf("Expected the rollback to be skipped, got %d %v\n", memoryOf(api), err)
+	}
+}
@@ -0,0 +1,258 @@
+// Package redact removes secrets from strings, errors and values before they leave the process, e.g. tokens or
+// connection strings in the errors of downstream APIs. Secrets are found by regular expressions, and struct fields
+// can be marked as secret with a tag:
+//
+//	type Credentials struct {
+//	    User     string `json:"user"`
+//	    Password string `json:"password" orchestration:"secret"` // Always redacted by Value
+//	}
+//
+// The orchestration package redacts its responses and logs with Default. Add patterns at startup:
+//
+//	redact.Default.MustAddPattern(`(?i)x-internal-key:\s*(?P<secret>\S+)`)
+//
+// A pattern with a group named "secret" only redacts that group, otherwise the whole match is redacted.
+package redact
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"regexp"
+	"sync"
+)
+
+const REDACTED = "[REDACTED]"
+
+// DefaultPatterns match bearer tokens, passwords, tokens and keys in key=value form, and the password in URLs
+var DefaultPatterns = []string{
+	`(?i)bearer\s+(?P<secret>[A-Za-z0-9\-._~+/]+=*)`,
+	`(?i)(?:password|passwd|pwd|secret|token|api[_-]?key|access[_-]?key)["']?\s*[=:]\s*["']?(?P<secret>[^\s&;,"']+)`,
+	`://[^:/@\s]+:(?P<secret>[^@\s]+)@`,
+}
+
+// Default is used by the package functions and the orchestration package, set it to nil to disable redaction
+var Default = New(DefaultPatterns...)
+
+type Redactor struct {
+	Replacement string // Default REDACTED
+
+	lock     sync.RWMutex
+	patterns []*regexp.Regexp
+}
+
+// New returns a Redactor with patterns, it panics on an invalid pattern like regexp.MustCompile
+func New(patterns ...string) *Redactor {
+	r := &Redactor{}
+	for _, pattern := range patterns {
+		r.MustAddPattern(pattern)
+	}
+	return r
+}
+
+func (r *Redactor) AddPattern(pattern string) error {
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.patterns = append(r.patterns, compiled)
+	return nil
+}
+
+func (r *Redactor) MustAddPattern(pattern string) {
+	if err := r.AddPattern(pattern); err != nil {
+		panic(err)
+	}
+}
+
+func (r *Redactor) replacement() string {
+	if r.Replacement != "" {
+		return r.Replacement
+	}
+	return REDACTED
+}
+
+// String returns text with all matches of the patterns redacted
+func (r *Redactor) String(text string) string {
+	if r == nil {
+		return text
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for _, pattern := range r.patterns {
+		text = redactPattern(pattern, text, r.replacement())
+	}
+	return text
+}
+
+func redactPattern(pattern *regexp.Regexp, text, replacement string) string {
+	group := pattern.SubexpIndex("secret")
+	result, last := "", 0
+	for _, match := range pattern.FindAllStringSubmatchIndex(text, -1) {
+		start, end := match[0], match[1]
+		if group > 0 {
+			start, end = match[2*group], match[2*group+1]
+			if start < 0 {
+				continue
+			}
+		}
+		result += text[last:start] + replacement
+		last = end
+	}
+	return result + text[last:]
+}
+
+// Error returns err with a redacted message, errors.Is and errors.As still work on the original error
+func (r *Redactor) Error(err error) error {
+	if r == nil || err == nil {
+		return err
+	}
+	message := r.String(err.Error())
+	if message == err.Error() {
+		return err
+	}
+	return &redactedError{message: message, err: err}
+}
+
+type redactedError struct {
+	message string
+	err     error
+}
+
+func (e *redactedError) Error() string {
+	return e.message
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// Value returns a copy of v with all strings redacted, fields tagged `orchestration:"secret"` replaced (strings) or
+// cleared (other types), and errors in v or in an "any" converted to their redacted message. The original v is not
+// modified. The exported fields of embedded unexported structs are redacted too, but those of an embedded pointer to
+// an unexported struct cannot be copied and are left as-is. Values nested deeper than maxDepth are cleared.
+func (r *Redactor) Value(v any) any {
+	if r == nil || v == nil {
+		return v
+	}
+	if err, ok := v.(error); ok {
+		return r.String(err.Error())
+	}
+	redacted := r.value(reflect.ValueOf(v), 0)
+	if !redacted.IsValid() {
+		return v
+	}
+	return redacted.Interface()
+}
+
+const maxDepth = 32 // Protects against cyclic values
+
+func (r *Redactor) value(v reflect.Value, depth int) reflect.Value {
+	if depth > maxDepth {
+		return reflect.Zero(v.Type()) // Too deep to redact, so not returned at all
+	}
+	switch v.Kind() {
+	case reflect.String:
+		redacted := reflect.New(v.Type()).Elem()
+		redacted.SetString(r.String(v.String()))
+		return redacted
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		redacted := reflect.New(v.Type().Elem())
+		redacted.Elem().Set(r.value(v.Elem(), depth+1))
+		return redacted
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		redacted := reflect.New(v.Type()).Elem()
+		err, isError := v.Interface().(error)
+		switch {
+		case isError && reflect.TypeOf("").AssignableTo(v.Type()): // E.g. any, errors would be marshalled as {}
+			redacted.Set(reflect.ValueOf(r.String(err.Error())))
+		case isError && reflect.TypeOf(r.Error(err)).AssignableTo(v.Type()):
+			redacted.Set(reflect.ValueOf(r.Error(err)))
+		default:
+			redacted.Set(r.value(v.Elem(), depth+1))
+		}
+		return redacted
+	case reflect.Struct:
+		redacted := reflect.New(v.Type()).Elem()
+		redacted.Set(v) // Also copies the unexported fields, which are left as-is
+		r.fields(redacted, v, depth)
+		return redacted
+	case reflect.Slice:
+		if v.IsNil() || v.Type().Elem().Kind() == reflect.Uint8 { // []byte and json.RawMessage are kept
+			return v
+		}
+		redacted := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			redacted.Index(i).Set(r.value(v.Index(i), depth+1))
+		}
+		return redacted
+	case reflect.Array:
+		redacted := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			redacted.Index(i).Set(r.value(v.Index(i), depth+1))
+		}
+		return redacted
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		redacted := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iterator := v.MapRange()
+		for iterator.Next() {
+			redacted.SetMapIndex(iterator.Key(), r.value(iterator.Value(), depth+1))
+		}
+		return redacted
+	}
+	return v
+}
+
+// fields redacts the exported fields of v into redacted, a settable copy of v
+func (r *Redactor) fields(redacted, v reflect.Value, depth int) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			// The exported fields of an embedded struct are marshalled as if they were fields of v
+			if field.Anonymous && field.Type.Kind() == reflect.Struct {
+				r.fields(redacted.Field(i), v.Field(i), depth+1)
+			}
+			continue
+		}
+		if field.Tag.Get("orchestration") == "secret" {
+			secret := reflect.New(field.Type).Elem()
+			if field.Type.Kind() == reflect.String {
+				secret.SetString(r.replacement())
+			}
+			redacted.Field(i).Set(secret)
+		} else {
+			redacted.Field(i).Set(r.value(v.Field(i), depth+1))
+		}
+	}
+}
+
+// String redacts text with Default
+func String(text string) string {
+	return Default.String(text)
+}
+
+// Error redacts err with Default
+func Error(err error) error {
+	return Default.Error(err)
+}
+
+// Value redacts v with Default
+func Value(v any) any {
+	return Default.Value(v)
+}
+
+// Logf logs like log.Printf, after redacting the formatted message with Default
+func Logf(format string, params ...any) {
+	log.Print(String(fmt.Sprintf(format, params...)))
+}
@@ -0,0 +1,106 @@
+package redact
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStringDefaultPatterns(t *testing.T) {
+	cases := map[string]string{
+		"Authorization: Bearer abc.def-123":             "Authorization: Bearer [REDACTED]",
+		"dial postgres://user:hunter2@db:5432/x failed": "dial postgres://user:[REDACTED]@db:5432/x failed",
+		"host=db password=hunter2 sslmode=off":          "host=db password=[REDACTED] sslmode=off",
+		`{"api_key": "abc123"}`:                         `{"api_key": "[REDACTED]"}`,
+		"nothing to see here":                           "nothing to see here",
+	}
+	for text, expected := range cases {
+		if got := String(text); got != expected {
+			t.Errorf("Expected %q, got %q\n", expected, got)
+		}
+	}
+}
+
+type credentials struct {
+	User     string
+	Password string `orchestration:"secret"`
+	Key      []byte `orchestration:"secret"`
+	Note     *string
+	Nested   map[string]any
+}
+
+func TestValueRedactsTagsAndStrings(t *testing.T) {
+	note := "token=abc"
+	original := &credentials{
+		User:     "alice",
+		Password: "hunter2",
+		Key:      []byte("key"),
+		Note:     &note,
+		Nested:   map[string]any{"error": errors.New("bearer xyz")},
+	}
+
+	redacted, ok := Value(original).(*credentials)
+	if !ok {
+		t.Fatalf("Expected the same type, got %T\n", Value(original))
+	}
+	if redacted.User != "alice" || redacted.Password != REDACTED || redacted.Key != nil {
+		t.Errorf("Expected the secret fields to be redacted, got %+v\n", redacted)
+	}
+	if *redacted.Note != "token=[REDACTED]" || redacted.Nested["error"] != "bearer [REDACTED]" {
+		t.Errorf("Expected nested strings and errors to be redacted, got %q and %v\n", *redacted.Note, redacted.Nested)
+	}
+	if original.Password != "hunter2" || note != "token=abc" {
+		t.Errorf("Expected the original to be unchanged, got %+v\n", original)
+	}
+}
+
+func TestErrorKeepsOriginal(t *testing.T) {
+	original := errors.New("password=hunter2")
+	redacted := Error(original)
+	if redacted.Error() != "password=[REDACTED]" || !errors.Is(redacted, original) {
+		t.Errorf("Expected a redacted message that still is the original error, got %v\n", redacted)
+	}
+}
+
+type login struct {
+	Password string `orchestration:"secret"`
+	Comment  string
+}
+
+type account struct {
+	login // Unexported, but its fields are marshalled as fields of account
+	Name  string
+}
+
+func TestValueRedactsUnexportedEmbeddedStructs(t *testing.T) {
+	original := account{login: login{Password: "hunter2", Comment: "token=abc"}, Name: "alice"}
+	redacted := Value(original).(account)
+	if redacted.Password != REDACTED || redacted.Comment != "token=[REDACTED]" || redacted.Name != "alice" {
+		t.Errorf("Expected the fields of the embedded struct to be redacted, got %+v\n", redacted)
+	}
+	if original.Password != "hunter2" {
+		t.Errorf("Expected the original to be unchanged, got %+v\n", original)
+	}
+}
+
+type node struct {
+	Secret string
+	Next   *node
+}
+
+func TestValueClearsTooDeepValues(t *testing.T) {
+	var root *node
+	for i := 0; i < 40; i++ {
+		root = &node{Secret: "password=hunter2", Next: root}
+	}
+	redacted := Value(root).(*node)
+	depth := 0
+	for current := redacted; current != nil; current = current.Next {
+		if current.Secret != "" && current.Secret != "password=[REDACTED]" {
+			t.Fatalf("Expected no unredacted secret at depth %d, got %q\n", depth, current.Secret)
+		}
+		depth++
+	}
+	if depth >= 40 {
+		t.Errorf("Expected the values beyond the maximum depth to be cleared, got a depth of %d\n", depth)
+	}
+}
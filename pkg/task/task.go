@@ -27,6 +27,8 @@ import (
 	"fmt"
 	"runtime/debug"
 	"sync"
+
+	"github.com/ing-bank/orchestration-pkg/pkg/redact"
 )
 
 // Runnable structs can be passed to the Run function below, for context aware concurrent execution.
@@ -53,7 +55,7 @@ func Run(tasks []Runnable, ctx context.Context) []error {
 				defer close(workerChan)
 				defer func() {
 					if err := recover(); err != nil {
-						fmt.Printf("[CRITICAL] Recovering from exception in task: %v %s\n", err, string(debug.Stack()))
+						fmt.Print(redact.String(fmt.Sprintf("[CRITICAL] Recovering from exception in task: %v %s\n", err, string(debug.Stack()))))
 						workerChan <- errors.New("internal server error")
 					}
 				}()